@@ -0,0 +1,171 @@
+package main
+
+import "math"
+
+// Коэффициенты яркости (Rec. 601)
+const (
+	lumaR = 0.299
+	lumaG = 0.587
+	lumaB = 0.114
+)
+
+// Регулируемые цветокоррекции
+func init() {
+	registerFilter(colorFilter("brightness", "Яркость", "☀️",
+		[]filterParam{numberParam("amount", "Яркость, %", -100, 100, 1, 0)},
+		func(p map[string]float64) pixelFunc {
+			d := float32(p["amount"] / 100)
+			return func(r, g, b float32) (float32, float32, float32) {
+				return r + d, g + d, b + d
+			}
+		}))
+	registerFilter(colorFilter("contrast", "Контраст", "◐",
+		[]filterParam{numberParam("amount", "Контраст, %", -100, 100, 1, 0)},
+		func(p map[string]float64) pixelFunc {
+			// -100 - сплошной серый, +100 - почти порог
+			c := math.Min(p["amount"]/100, 0.995)
+			k := float32((1 + c) / (1 - c))
+			return func(r, g, b float32) (float32, float32, float32) {
+				return (r-0.5)*k + 0.5, (g-0.5)*k + 0.5, (b-0.5)*k + 0.5
+			}
+		}))
+	registerFilter(colorFilter("saturation", "Насыщенность", "🌈",
+		[]filterParam{numberParam("amount", "Насыщенность, %", -100, 100, 1, 0)},
+		func(p map[string]float64) pixelFunc {
+			k := float32(1 + p["amount"]/100)
+			return func(r, g, b float32) (float32, float32, float32) {
+				return saturate(r, g, b, k)
+			}
+		}))
+	registerFilter(colorFilter("vibrance", "Сочность", "💧",
+		[]filterParam{numberParam("amount", "Сочность, %", -100, 100, 1, 0)},
+		func(p map[string]float64) pixelFunc {
+			// как насыщенность, но слабее действует на уже насыщенные цвета
+			amount := float32(p["amount"] / 100)
+			return func(r, g, b float32) (float32, float32, float32) {
+				sat := max(r, g, b) - min(r, g, b)
+				return saturate(r, g, b, 1+amount*(1-sat))
+			}
+		}))
+	registerFilter(colorFilter("hue", "Оттенок", "🎡",
+		[]filterParam{numberParam("degrees", "Поворот оттенка, °", -180, 180, 1, 0)},
+		func(p map[string]float64) pixelFunc {
+			m := hueRotation(p["degrees"])
+			return func(r, g, b float32) (float32, float32, float32) {
+				return m[0][0]*r + m[0][1]*g + m[0][2]*b,
+					m[1][0]*r + m[1][1]*g + m[1][2]*b,
+					m[2][0]*r + m[2][1]*g + m[2][2]*b
+			}
+		}))
+	registerFilter(colorFilter("gamma", "Гамма", "γ",
+		[]filterParam{numberParam("gamma", "Гамма", 0.1, 5, 0.05, 1)},
+		func(p map[string]float64) pixelFunc {
+			// gamma > 1 осветляет средние тона
+			lut := curveLUT(func(v float64) float64 { return math.Pow(v, 1/p["gamma"]) })
+			return lut.apply
+		}))
+	registerFilter(colorFilter("exposure", "Экспозиция", "📷",
+		[]filterParam{numberParam("stops", "Экспозиция, ступени", -5, 5, 0.1, 0)},
+		func(p map[string]float64) pixelFunc {
+			// умножение в линейном свете: +1 ступень - вдвое больше света
+			k := math.Exp2(p["stops"])
+			lut := curveLUT(func(v float64) float64 {
+				return linearToSRGB(srgbToLinear(v) * k)
+			})
+			return lut.apply
+		}))
+	registerFilter(colorFilter("posterize", "Постеризация", "🎨",
+		[]filterParam{numberParam("levels", "Уровней на канал", 2, 64, 1, 4)},
+		func(p map[string]float64) pixelFunc {
+			n := float32(p["levels"] - 1)
+			step := func(v float32) float32 { return float32(math.Round(float64(clampF32(v, 0, 1)*n))) / n }
+			return func(r, g, b float32) (float32, float32, float32) {
+				return step(r), step(g), step(b)
+			}
+		}))
+	registerFilter(colorFilter("threshold", "Порог (1 бит)", "◼️",
+		[]filterParam{numberParam("level", "Порог", 0, 255, 1, 128)},
+		func(p map[string]float64) pixelFunc {
+			// яркость не ниже порога - белый, иначе черный
+			level := float32(p["level"] / 255)
+			return func(r, g, b float32) (float32, float32, float32) {
+				if lumaR*r+lumaG*g+lumaB*b >= level {
+					return 1, 1, 1
+				}
+				return 0, 0, 0
+			}
+		}))
+}
+
+// saturate - изменение насыщенности: удаление от серого той же яркости в k раз
+func saturate(r, g, b, k float32) (float32, float32, float32) {
+	l := lumaR*r + lumaG*g + lumaB*b
+	return l + (r-l)*k, l + (g-l)*k, l + (b-l)*k
+}
+
+// hueRotation - матрица поворота оттенка вокруг оси серого с сохранением
+// яркости (та же, что у CSS-фильтра hue-rotate)
+func hueRotation(degrees float64) [3][3]float32 {
+	rad := degrees * math.Pi / 180
+	c, s := math.Cos(rad), math.Sin(rad)
+	m := [3][3]float64{
+		{0.213 + c*0.787 - s*0.213, 0.715 - c*0.715 - s*0.715, 0.072 - c*0.072 + s*0.928},
+		{0.213 - c*0.213 + s*0.143, 0.715 + c*0.285 + s*0.140, 0.072 - c*0.072 - s*0.283},
+		{0.213 - c*0.213 - s*0.787, 0.715 - c*0.715 + s*0.715, 0.072 + c*0.928 + s*0.072},
+	}
+	var out [3][3]float32
+	for i := range m {
+		for j := range m[i] {
+			out[i][j] = float32(m[i][j])
+		}
+	}
+	return out
+}
+
+// toneLUT - одинаковая для всех каналов кривая, заданная таблицей
+type toneLUT []float32
+
+// curveLUTSize - точек в таблице кривой; между ними - линейная интерполяция
+const curveLUTSize = 4096
+
+// curveLUT - таблица функции f на отрезке 0..1
+func curveLUT(f func(v float64) float64) toneLUT {
+	lut := make(toneLUT, curveLUTSize+1)
+	for i := range lut {
+		lut[i] = float32(f(float64(i) / curveLUTSize))
+	}
+	return lut
+}
+
+func (t toneLUT) at(v float32) float32 {
+	pos := clampF32(v, 0, 1) * curveLUTSize
+	i := min(int(pos), curveLUTSize-1)
+	f := pos - float32(i)
+	return t[i]*(1-f) + t[i+1]*f
+}
+
+func (t toneLUT) apply(r, g, b float32) (float32, float32, float32) {
+	return t.at(r), t.at(g), t.at(b)
+}
+
+// channelLUTs - свои кривые для R, G и B
+type channelLUTs [3]toneLUT
+
+func (t channelLUTs) apply(r, g, b float32) (float32, float32, float32) {
+	return t[0].at(r), t[1].at(g), t[2].at(b)
+}
+
+// srgbToLinear, linearToSRGB - кривая sRGB и обратная к ней
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
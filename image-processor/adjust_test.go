@@ -0,0 +1,121 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// filterPixel - результат фильтра id для однопиксельного изображения цвета c
+func filterPixel(t *testing.T, id string, params map[string]interface{}, c color.NRGBA) color.NRGBA {
+	t.Helper()
+	values, err := filterParams(id, params)
+	if err != nil {
+		t.Fatalf("%s: %v", id, err)
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.SetNRGBA(0, 0, c)
+	out, err := applyFilter(src, id, values, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", id, err)
+	}
+	return color.NRGBAModel.Convert(out.At(0, 0)).(color.NRGBA)
+}
+
+// closeColor - цвета совпадают с точностью до 1 уровня
+func closeColor(a, b color.NRGBA) bool {
+	return absDiff(a.R, b.R) <= 1 && absDiff(a.G, b.G) <= 1 && absDiff(a.B, b.B) <= 1 && a.A == b.A
+}
+
+func TestAdjustFilters(t *testing.T) {
+	orange := color.NRGBA{200, 100, 50, 255}
+	tests := []struct {
+		id     string
+		params map[string]interface{}
+		in     color.NRGBA
+		want   color.NRGBA
+	}{
+		{"brightness", map[string]interface{}{"amount": 20.0}, orange, color.NRGBA{251, 151, 101, 255}},
+		{"brightness", map[string]interface{}{"amount": -100.0}, orange, color.NRGBA{0, 0, 0, 255}},
+		{"contrast", map[string]interface{}{"amount": -100.0}, orange, color.NRGBA{128, 128, 128, 255}},
+		{"contrast", map[string]interface{}{"amount": 50.0}, orange, color.NRGBA{255, 45, 0, 255}},
+		{"saturation", map[string]interface{}{"amount": -100.0}, orange, color.NRGBA{124, 124, 124, 255}},
+		{"vibrance", map[string]interface{}{"amount": -100.0}, color.NRGBA{100, 100, 100, 255}, color.NRGBA{100, 100, 100, 255}},
+		{"hue", map[string]interface{}{"degrees": 180.0}, color.NRGBA{128, 128, 128, 255}, color.NRGBA{128, 128, 128, 255}},
+		{"gamma", map[string]interface{}{"gamma": 2.0}, color.NRGBA{64, 0, 255, 255}, color.NRGBA{128, 0, 255, 255}},
+		{"exposure", map[string]interface{}{"stops": 1.0}, color.NRGBA{0, 128, 255, 255}, color.NRGBA{0, 175, 255, 255}},
+		{"posterize", map[string]interface{}{"levels": 2.0}, orange, color.NRGBA{255, 0, 0, 255}},
+		{"threshold", map[string]interface{}{"level": 120.0}, orange, color.NRGBA{255, 255, 255, 255}},
+		{"threshold", map[string]interface{}{"level": 130.0}, orange, color.NRGBA{0, 0, 0, 255}},
+		// альфа не меняется
+		{"brightness", map[string]interface{}{"amount": 100.0}, color.NRGBA{0, 0, 0, 77}, color.NRGBA{255, 255, 255, 77}},
+	}
+	for _, tt := range tests {
+		if got := filterPixel(t, tt.id, tt.params, tt.in); !closeColor(got, tt.want) {
+			t.Errorf("%s %v: %v → %v, ожидается %v", tt.id, tt.params, tt.in, got, tt.want)
+		}
+	}
+}
+
+// Параметры по умолчанию не меняют изображение
+func TestAdjustDefaultsIdentity(t *testing.T) {
+	colors := []color.NRGBA{{200, 100, 50, 255}, {0, 0, 0, 255}, {255, 255, 255, 128}, {17, 240, 99, 255}}
+	for _, id := range []string{"brightness", "contrast", "saturation", "vibrance", "hue", "gamma", "exposure"} {
+		for _, c := range colors {
+			if got := filterPixel(t, id, nil, c); !closeColor(got, c) {
+				t.Errorf("%s: %v → %v", id, c, got)
+			}
+		}
+	}
+}
+
+func TestResolveParams(t *testing.T) {
+	schema := []filterParam{
+		numberParam("amount", "Сила", -100, 100, 1, 10),
+		choiceParam("mode", "Режим", []string{"soft", "hard"}, 0),
+		colorParam("color", "Цвет", 0xff0000),
+	}
+	tests := []struct {
+		name    string
+		given   map[string]interface{}
+		want    map[string]float64
+		wantErr string
+	}{
+		{"defaults", nil, map[string]float64{"amount": 10, "mode": 0, "color": 0xff0000}, ""},
+		{"values", map[string]interface{}{"amount": -100.0, "mode": "HARD", "color": "#00ff00"},
+			map[string]float64{"amount": -100, "mode": 1, "color": 0x00ff00}, ""},
+		{"choice by number", map[string]interface{}{"mode": 1.0}, map[string]float64{"amount": 10, "mode": 1, "color": 0xff0000}, ""},
+
+		{"out of range", map[string]interface{}{"amount": 101.0}, nil, "от -100 до 100"},
+		{"unknown", map[string]interface{}{"radius": 1.0}, nil, "Допустимые: amount, mode, color"},
+		{"string number", map[string]interface{}{"amount": "5"}, nil, "ожидается число"},
+		{"bad choice", map[string]interface{}{"mode": "medium"}, nil, "soft, hard"},
+		{"fractional choice", map[string]interface{}{"mode": 0.5}, nil, "одно из"},
+		{"bad color", map[string]interface{}{"color": "#12"}, nil, "неверный цвет"},
+		{"bool", map[string]interface{}{"amount": true}, nil, "ожидается число"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveParams(schema, tt.given)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидается с %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for id, v := range tt.want {
+				if got[id] != v {
+					t.Errorf("%s = %g, ожидается %g", id, got[id], v)
+				}
+			}
+		})
+	}
+
+	if _, err := resolveParams(nil, map[string]interface{}{"x": 1.0}); err == nil || !strings.Contains(err.Error(), "нет параметров") {
+		t.Errorf("фильтр без параметров: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+)
+
+// maxAnimationPixels - предел суммарного числа пикселей всех кадров
+const maxAnimationPixels = 200_000_000
+
+// decodeAnimation - анимированный GIF (больше одного кадра), иначе nil.
+// Размер и число кадров проверяются до декодирования, чтобы большая
+// анимация не заняла память раньше проверки.
+func decodeAnimation(data []byte) (*gif.GIF, error) {
+	if !bytes.HasPrefix(data, []byte("GIF8")) {
+		return nil, nil
+	}
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	frames := countGIFFrames(data)
+	if frames < 2 {
+		return nil, nil
+	}
+	if cfg.Width*cfg.Height*frames > maxAnimationPixels {
+		return nil, fmt.Errorf("слишком большая анимация: %d кадров %d×%d",
+			frames, cfg.Width, cfg.Height)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) < 2 {
+		return nil, nil
+	}
+	return g, nil
+}
+
+// countGIFFrames - число кадров GIF по структуре блоков, без распаковки.
+// Для испорченного файла - число кадров до места ошибки (ее сообщит
+// gif.DecodeAll).
+func countGIFFrames(data []byte) int {
+	const headerLen = 13 // сигнатура и логический экран
+	if len(data) < headerLen {
+		return 0
+	}
+	pos := headerLen
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&7 + 1) // глобальная палитра
+	}
+
+	// skipBlocks - пропуск цепочки подблоков до нулевого
+	skipBlocks := func() bool {
+		for pos < len(data) {
+			n := int(data[pos])
+			pos += 1 + n
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // расширение: метка и подблоки
+			pos += 2
+			if !skipBlocks() {
+				return frames
+			}
+		case 0x2c: // кадр: дескриптор, локальная палитра, LZW
+			if pos+10 > len(data) {
+				return frames
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1)
+			}
+			pos++ // минимальный размер кода LZW
+			if !skipBlocks() {
+				return frames
+			}
+			frames++
+		default: // 0x3b - конец файла, прочее - ошибка
+			return frames
+		}
+	}
+	return frames
+}
+
+// compositeFrames - полные кадры анимации с учетом способа смены (disposal)
+func compositeFrames(g *gif.GIF) []*image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	frames := make([]*image.RGBA, len(g.Image))
+
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var saved *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			saved = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames[i] = cloneRGBA(canvas)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = saved
+		}
+	}
+	return frames
+}
+
+// processAnimation - конвейер применяется к каждому кадру; задержки,
+// способы смены кадров и число повторов сохраняются.
+// Кадры пишутся целиком, поэтому исходный disposal для них остается корректным.
+func processAnimation(g *gif.GIF, ops pipeline, rc *runContext) (*gif.GIF, error) {
+	frames := compositeFrames(g)
+
+	out := &gif.GIF{
+		Image:     make([]*image.Paletted, len(frames)),
+		Delay:     make([]int, len(frames)),
+		Disposal:  make([]byte, len(frames)),
+		LoopCount: g.LoopCount,
+	}
+	copy(out.Delay, g.Delay)
+	copy(out.Disposal, g.Disposal)
+
+	var size image.Rectangle
+	for i, frame := range frames {
+		img, err := ops.run(frame, rc)
+		if err != nil {
+			return nil, err
+		}
+
+		b := img.Bounds()
+		if i == 0 {
+			size = image.Rect(0, 0, b.Dx(), b.Dy())
+		} else if b.Dx() != size.Dx() || b.Dy() != size.Dy() {
+			return nil, errors.New("кадры анимации получили разный размер после обработки")
+		}
+		out.Image[i] = palettize(img, 256)
+	}
+
+	out.Config = image.Config{Width: size.Dx(), Height: size.Dy()}
+	return out, nil
+}
+
+// encodeAnimation - запись анимированного GIF
+func encodeAnimation(g *gif.GIF) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, g)
+	return buf.Bytes(), err
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+	return dst
+}
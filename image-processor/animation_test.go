@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// testGIF - анимация из n кадров w×h; кадры после первого меняют только
+// квадрат 2×2 в левом верхнем углу
+func testGIF(w, h, n int, disposal byte) *gif.GIF {
+	palette := color.Palette{color.Transparent, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}}
+	g := &gif.GIF{LoopCount: 3, Config: image.Config{Width: w, Height: h, ColorModel: palette}}
+	for i := 0; i < n; i++ {
+		rect := image.Rect(0, 0, w, h)
+		if i > 0 {
+			rect = image.Rect(0, 0, 2, 2)
+		}
+		frame := image.NewPaletted(rect, palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(1 + i%2)
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10*(i+1))
+		g.Disposal = append(g.Disposal, disposal)
+	}
+	return g
+}
+
+func encodeGIF(t *testing.T, g *gif.GIF) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeAnimation(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    func(t *testing.T) []byte
+		frames  int // 0 - не анимация
+		wantErr bool
+	}{
+		{"animated", func(t *testing.T) []byte { return encodeGIF(t, testGIF(8, 6, 3, gif.DisposalNone)) }, 3, false},
+		{"single frame", func(t *testing.T) []byte { return encodeGIF(t, testGIF(8, 6, 1, gif.DisposalNone)) }, 0, false},
+		{"png", func(t *testing.T) []byte { return []byte("\x89PNG\r\n\x1a\n") }, 0, false},
+		{"too large", func(t *testing.T) []byte { return encodeGIF(t, testGIF(5000, 5000, 9, gif.DisposalNone)) }, 0, true},
+		{"truncated", func(t *testing.T) []byte {
+			data := encodeGIF(t, testGIF(8, 6, 3, gif.DisposalNone))
+			return data[:len(data)-8]
+		}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := decodeAnimation(tt.data(t))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ошибка не возвращена")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (g == nil) != (tt.frames == 0) || (g != nil && len(g.Image) != tt.frames) {
+				t.Errorf("результат %v, ожидается %d кадров", g != nil, tt.frames)
+			}
+		})
+	}
+}
+
+func TestCountGIFFrames(t *testing.T) {
+	data := encodeGIF(t, testGIF(8, 6, 4, gif.DisposalNone))
+	if n := countGIFFrames(data); n != 4 {
+		t.Errorf("кадров %d, ожидается 4", n)
+	}
+	if n := countGIFFrames(data[:20]); n != 0 {
+		t.Errorf("обрезанный файл: %d кадров", n)
+	}
+	if n := countGIFFrames(nil); n != 0 {
+		t.Errorf("пустые данные: %d кадров", n)
+	}
+}
+
+// Кадры собираются с учетом disposal: при DisposalBackground угол
+// очищается, при DisposalNone под ним остается предыдущий кадр
+func TestCompositeFrames(t *testing.T) {
+	for _, tt := range []struct {
+		disposal byte
+		corner   color.RGBA // (0,0) третьего кадра
+		rest     color.RGBA // (5,5) третьего кадра
+	}{
+		{gif.DisposalNone, color.RGBA{255, 0, 0, 255}, color.RGBA{255, 0, 0, 255}},
+		{gif.DisposalBackground, color.RGBA{255, 0, 0, 255}, color.RGBA{}},
+		{gif.DisposalPrevious, color.RGBA{255, 0, 0, 255}, color.RGBA{}},
+	} {
+		frames := compositeFrames(testGIF(8, 6, 3, tt.disposal))
+		if c := frames[2].RGBAAt(0, 0); c != tt.corner {
+			t.Errorf("disposal %d: угол %v, ожидается %v", tt.disposal, c, tt.corner)
+		}
+		if c := frames[2].RGBAAt(5, 5); c != tt.rest {
+			t.Errorf("disposal %d: фон %v, ожидается %v", tt.disposal, c, tt.rest)
+		}
+	}
+}
+
+func TestProcessAnimation(t *testing.T) {
+	src := testGIF(8, 6, 3, gif.DisposalNone)
+	p, err := parsePipeline(`[{"op":"resize","width":4},{"op":"filter","name":"grayscale"}]`, defaultOptions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := processAnimation(src, p, newRunContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 3 || g.Config.Width != 4 || g.Config.Height != 3 || g.LoopCount != 3 {
+		t.Fatalf("%d кадров %d×%d, повторов %d", len(g.Image), g.Config.Width, g.Config.Height, g.LoopCount)
+	}
+	for i, d := range g.Delay {
+		if d != src.Delay[i] {
+			t.Errorf("кадр %d: задержка %d, ожидается %d", i, d, src.Delay[i])
+		}
+	}
+	data, err := encodeAnimation(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := decodeAnimation(data); err != nil || again == nil || len(again.Image) != 3 {
+		t.Errorf("повторное чтение: %v", err)
+	}
+}
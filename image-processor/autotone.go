@@ -0,0 +1,310 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// Автоматическая коррекция по гистограмме: уровни, контраст, баланс белого,
+// эквализация и CLAHE. Параметры кривых вычисляются по самому изображению.
+
+// histBins - ячеек в гистограмме канала (значения 0..1)
+const histBins = 1024
+
+// Варианты автоматического баланса белого
+var whiteBalanceMethods = []string{"gray-world", "white-patch"}
+
+// Автоматические коррекции
+func init() {
+	clipParams := []filterParam{
+		numberParam("low", "Отсечение теней, %", 0, 10, 0.1, 0.5),
+		numberParam("high", "Отсечение светов, %", 0, 10, 0.1, 0.5),
+	}
+	registerFilter(newFilter("autolevels", "Автоуровни", "📊", clipParams,
+		func(img image.Image, p map[string]float64) image.Image {
+			return autoLevels(img, p["low"]/100, p["high"]/100, false)
+		}))
+	registerFilter(newFilter("autocontrast", "Автоконтраст", "🌗", clipParams,
+		func(img image.Image, p map[string]float64) image.Image {
+			return autoLevels(img, p["low"]/100, p["high"]/100, true)
+		}))
+	registerFilter(newFilter("whitebalance", "Автобаланс белого", "⚪",
+		[]filterParam{
+			choiceParam("method", "Метод", whiteBalanceMethods, 0),
+			numberParam("clip", "Отсечение светов (white-patch), %", 0, 10, 0.1, 1),
+		},
+		func(img image.Image, p map[string]float64) image.Image {
+			return autoWhiteBalance(img, whiteBalanceMethods[int(p["method"])], p["clip"]/100)
+		}))
+	registerFilter(newFilter("equalize", "Эквализация", "📶", nil,
+		func(img image.Image, _ map[string]float64) image.Image {
+			return equalize(img)
+		}))
+	registerFilter(newFilter("clahe", "Локальный контраст (CLAHE)", "🔆",
+		[]filterParam{
+			numberParam("tiles", "Плиток по стороне", 2, 16, 1, 8),
+			numberParam("clip", "Ограничение контраста", 1, 10, 0.1, 2),
+		},
+		func(img image.Image, p map[string]float64) image.Image {
+			return clahe(img, int(p["tiles"]), p["clip"])
+		}))
+}
+
+// histogram - гистограммы R, G, B и яркости; пиксели учитываются с весом
+// по непрозрачности, полностью прозрачные не влияют на результат
+type histogram struct {
+	rgb   [3][]float64
+	luma  []float64
+	total float64
+}
+
+func imageHistogram(img image.Image) *histogram {
+	h := &histogram{luma: make([]float64, histBins)}
+	for c := range h.rgb {
+		h.rgb[c] = make([]float64, histBins)
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := straightAt(img, x, y)
+			if c.A == 0 {
+				continue
+			}
+			wt := float64(c.A) / 0xffff
+			h.rgb[0][histBin(c.R)] += wt
+			h.rgb[1][histBin(c.G)] += wt
+			h.rgb[2][histBin(c.B)] += wt
+			l := lumaR*float64(c.R) + lumaG*float64(c.G) + lumaB*float64(c.B)
+			h.luma[histBin(uint16(l+0.5))] += wt
+			h.total += wt
+		}
+	}
+	return h
+}
+
+// histBin - ячейка гистограммы для 16-битного значения
+func histBin(v uint16) int {
+	return int(v) * (histBins - 1) / 0xffff
+}
+
+// binValue - значение 0..1, соответствующее ячейке
+func binValue(i int) float64 {
+	return float64(i) / (histBins - 1)
+}
+
+// clipRange - границы канала после отсечения доли low самых темных и high
+// самых светлых пикселей
+func clipRange(bins []float64, total, low, high float64) (lo, hi float64) {
+	var sum float64
+	i := 0
+	for ; i < histBins-1; i++ {
+		if sum += bins[i]; sum > low*total {
+			break
+		}
+	}
+	sum = 0
+	j := histBins - 1
+	for ; j > 0; j-- {
+		if sum += bins[j]; sum > high*total {
+			break
+		}
+	}
+	return binValue(i), binValue(j)
+}
+
+// stretchLUT - линейное растяжение [lo, hi] на весь диапазон
+func stretchLUT(lo, hi float64) toneLUT {
+	if hi-lo < 1.0/256 {
+		return curveLUT(func(v float64) float64 { return v }) // почти однотонный канал не растягиваем
+	}
+	return curveLUT(func(v float64) float64 { return (v - lo) / (hi - lo) })
+}
+
+// autoLevels - растяжение каналов на весь диапазон с отсечением доли low
+// теней и high светов. linked - общие границы для всех каналов
+// (автоконтраст, без сдвига цвета), иначе у каждого канала свои (автоуровни,
+// заодно убирают цветовой оттенок).
+func autoLevels(img image.Image, low, high float64, linked bool) image.Image {
+	h := imageHistogram(img)
+	if h.total == 0 {
+		return img
+	}
+
+	var luts channelLUTs
+	if linked {
+		// границы - по сумме гистограмм каналов
+		sum := make([]float64, histBins)
+		for _, bins := range h.rgb {
+			for i, v := range bins {
+				sum[i] += v
+			}
+		}
+		lut := stretchLUT(clipRange(sum, 3*h.total, low, high))
+		luts = channelLUTs{lut, lut, lut}
+	} else {
+		for c, bins := range h.rgb {
+			luts[c] = stretchLUT(clipRange(bins, h.total, low, high))
+		}
+	}
+	return mapPixels(img, luts.apply)
+}
+
+// autoWhiteBalance - усиление каналов в линейном свете так, чтобы средний
+// цвет стал серым (gray-world) или самые светлые участки белыми
+// (white-patch; доля clip самых светлых пикселей не учитывается)
+func autoWhiteBalance(img image.Image, method string, clip float64) image.Image {
+	h := imageHistogram(img)
+	if h.total == 0 {
+		return img
+	}
+
+	var ref [3]float64 // опорное значение канала в линейном свете
+	for c, bins := range h.rgb {
+		if method == "white-patch" {
+			_, hi := clipRange(bins, h.total, 0, clip)
+			ref[c] = srgbToLinear(hi)
+			continue
+		}
+		for i, v := range bins {
+			ref[c] += srgbToLinear(binValue(i)) * v
+		}
+		ref[c] /= h.total
+	}
+
+	target := 1.0
+	if method != "white-patch" {
+		target = (ref[0] + ref[1] + ref[2]) / 3
+	}
+	var luts channelLUTs
+	for c := range luts {
+		gain := 1.0
+		if ref[c] > 1e-4 {
+			gain = target / ref[c]
+		}
+		luts[c] = curveLUT(func(v float64) float64 { return linearToSRGB(srgbToLinear(v) * gain) })
+	}
+	return mapPixels(img, luts.apply)
+}
+
+// equalize - выравнивание гистограммы яркости: яркость заменяется ее долей
+// в накопленной гистограмме. Каналы сдвигаются на изменение яркости, так
+// что цвет не меняется.
+func equalize(img image.Image) image.Image {
+	h := imageHistogram(img)
+	if h.total == 0 {
+		return img
+	}
+
+	cdf := make([]float64, histBins)
+	var sum, first float64
+	for i, v := range h.luma {
+		sum += v
+		cdf[i] = sum
+		if first == 0 {
+			first = sum
+		}
+	}
+	lut := curveLUT(func(v float64) float64 {
+		i := int(v*(histBins-1) + 0.5)
+		if h.total == first {
+			return v // однотонное изображение
+		}
+		return math.Max(cdf[i]-first, 0) / (h.total - first)
+	})
+	return mapPixels(img, func(r, g, b float32) (float32, float32, float32) {
+		l := lumaR*r + lumaG*g + lumaB*b
+		d := lut.at(l) - l
+		return r + d, g + d, b + d
+	})
+}
+
+// claheBins - ячеек в гистограммах плиток CLAHE
+const claheBins = 256
+
+// clahe - эквализация с ограничением контраста по плиткам (CLAHE). Яркость
+// выравнивается в каждой плитке сетки tiles×tiles; столбцы гистограммы выше
+// clip средних обрезаются, избыток распределяется поровну (так не
+// усиливается шум на ровных участках). Кривые соседних плиток смешиваются
+// билинейно, чтобы не было видно швов.
+func clahe(img image.Image, tiles int, clip float64) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tx, ty := min(tiles, w), min(tiles, h)
+	tileW, tileH := float64(w)/float64(tx), float64(h)/float64(ty)
+
+	hist := make([][]float64, tx*ty)
+	for i := range hist {
+		hist[i] = make([]float64, claheBins)
+	}
+	for y := 0; y < h; y++ {
+		row := hist[min(int(float64(y)/tileH), ty-1)*tx:]
+		for x := 0; x < w; x++ {
+			c := straightAt(img, b.Min.X+x, b.Min.Y+y)
+			l := (lumaR*float64(c.R) + lumaG*float64(c.G) + lumaB*float64(c.B)) / 0xffff
+			row[min(int(float64(x)/tileW), tx-1)][int(l*(claheBins-1)+0.5)] += float64(c.A) / 0xffff
+		}
+	}
+
+	// кривая каждой плитки: значения в узлах 0..claheBins-1
+	maps := make([][]float32, len(hist))
+	for i, bins := range hist {
+		maps[i] = claheMap(bins, clip)
+	}
+
+	at := func(m []float32, l float32) float32 {
+		pos := clampF32(l, 0, 1) * (claheBins - 1)
+		j := min(int(pos), claheBins-2)
+		f := pos - float32(j)
+		return m[j]*(1-f) + m[j+1]*f
+	}
+	// cell - плитки слева/справа (сверху/снизу) от точки и вес второй
+	cell := func(p float64, size float64, n int) (int, int, float32) {
+		f := p/size - 0.5
+		i0 := int(math.Floor(f))
+		wt := f - float64(i0)
+		return clampInt(i0, 0, n-1), clampInt(i0+1, 0, n-1), float32(wt)
+	}
+
+	return mapPixelsAt(img, func(x, y int, r, g, bl float32) (float32, float32, float32) {
+		x0, x1, fx := cell(float64(x-b.Min.X)+0.5, tileW, tx)
+		y0, y1, fy := cell(float64(y-b.Min.Y)+0.5, tileH, ty)
+		l := lumaR*r + lumaG*g + lumaB*bl
+		top := at(maps[y0*tx+x0], l)*(1-fx) + at(maps[y0*tx+x1], l)*fx
+		bottom := at(maps[y1*tx+x0], l)*(1-fx) + at(maps[y1*tx+x1], l)*fx
+		d := top*(1-fy) + bottom*fy - l
+		return r + d, g + d, bl + d
+	})
+}
+
+// claheMap - кривая выравнивания гистограммы плитки с ограничением clip
+// (в долях от среднего столбца)
+func claheMap(bins []float64, clip float64) []float32 {
+	var total float64
+	for _, v := range bins {
+		total += v
+	}
+	m := make([]float32, len(bins))
+	if total == 0 {
+		for i := range m {
+			m[i] = float32(i) / float32(len(m)-1)
+		}
+		return m
+	}
+
+	limit := clip * total / float64(len(bins))
+	var excess float64
+	clipped := make([]float64, len(bins))
+	for i, v := range bins {
+		clipped[i] = math.Min(v, limit)
+		excess += v - clipped[i]
+	}
+	add := excess / float64(len(bins))
+
+	var sum float64
+	for i, v := range clipped {
+		sum += v + add
+		m[i] = float32(sum / total)
+	}
+	return m
+}
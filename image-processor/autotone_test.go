@@ -0,0 +1,150 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// rampImage - горизонтальный градиент: канал c идет от lo[c] до hi[c]
+func rampImage(w, h int, lo, hi [3]uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var v [3]uint8
+			for c := range v {
+				v[c] = uint8(int(lo[c]) + (int(hi[c])-int(lo[c]))*x/(w-1))
+			}
+			img.SetNRGBA(x, y, color.NRGBA{v[0], v[1], v[2], 255})
+		}
+	}
+	return img
+}
+
+// channelRange - наименьшее и наибольшее значения канала c
+func channelRange(img image.Image, c int) (lo, hi uint8) {
+	lo = 255
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			px := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if px.A == 0 {
+				continue
+			}
+			v := [3]uint8{px.R, px.G, px.B}[c]
+			lo, hi = min(lo, v), max(hi, v)
+		}
+	}
+	return lo, hi
+}
+
+func TestAutoLevels(t *testing.T) {
+	src := rampImage(64, 4, [3]uint8{50, 100, 0}, [3]uint8{150, 200, 255})
+	// прозрачные пиксели за пределами диапазона не учитываются
+	src.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 0})
+	src.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 0})
+
+	tests := []struct {
+		linked bool
+		want   [3][2]uint8
+	}{
+		{false, [3][2]uint8{{0, 255}, {0, 255}, {0, 255}}},
+		// общие границы 0..255 из-за синего - красный и зеленый не растягиваются
+		{true, [3][2]uint8{{50, 150}, {100, 200}, {0, 255}}},
+	}
+	for _, tt := range tests {
+		out := autoLevels(src, 0, 0, tt.linked)
+		for c, want := range tt.want {
+			lo, hi := channelRange(out, c)
+			if absDiff(lo, want[0]) > 1 || absDiff(hi, want[1]) > 1 {
+				t.Errorf("linked=%v, канал %d: %d..%d, ожидается %d..%d", tt.linked, c, lo, hi, want[0], want[1])
+			}
+		}
+	}
+
+	// общие границы по суженному диапазону растягивают все каналы одинаково
+	out := autoLevels(rampImage(64, 4, [3]uint8{60, 60, 60}, [3]uint8{180, 180, 180}), 0, 0, true)
+	if lo, hi := channelRange(out, 0); lo > 1 || hi < 254 {
+		t.Errorf("автоконтраст серого градиента: %d..%d", lo, hi)
+	}
+}
+
+func TestClipRange(t *testing.T) {
+	bins := make([]float64, histBins)
+	bins[0], bins[100], bins[900], bins[histBins-1] = 1, 49, 49, 1
+	if lo, hi := clipRange(bins, 100, 0, 0); lo != 0 || hi != 1 {
+		t.Errorf("без отсечения: %g..%g", lo, hi)
+	}
+	if lo, hi := clipRange(bins, 100, 0.02, 0.02); lo != binValue(100) || hi != binValue(900) {
+		t.Errorf("отсечение 2%%: %g..%g", lo, hi)
+	}
+}
+
+func TestAutoWhiteBalance(t *testing.T) {
+	// однотонное изображение с теплым оттенком становится серым
+	cast := flatImage(8, 8, color.NRGBA{180, 140, 110, 255})
+	c := color.NRGBAModel.Convert(autoWhiteBalance(cast, "gray-world", 0).At(3, 3)).(color.NRGBA)
+	if absDiff(c.R, c.G) > 2 || absDiff(c.G, c.B) > 2 {
+		t.Errorf("gray-world: %v, ожидается серый", c)
+	}
+
+	// самый светлый участок становится белым
+	src := rampImage(64, 4, [3]uint8{0, 0, 0}, [3]uint8{230, 200, 180})
+	out := autoWhiteBalance(src, "white-patch", 0)
+	c = color.NRGBAModel.Convert(out.At(63, 0)).(color.NRGBA)
+	if c.R < 253 || c.G < 253 || c.B < 253 {
+		t.Errorf("white-patch: %v, ожидается белый", c)
+	}
+}
+
+func TestEqualize(t *testing.T) {
+	// значения сжаты в узкий диапазон - после эквализации занимают почти весь
+	out := equalize(rampImage(256, 2, [3]uint8{100, 100, 100}, [3]uint8{130, 130, 130}))
+	if lo, hi := channelRange(out, 1); lo > 10 || hi < 245 {
+		t.Errorf("диапазон после эквализации %d..%d", lo, hi)
+	}
+
+	flat := flatImage(4, 4, color.NRGBA{90, 90, 90, 255})
+	if c := color.NRGBAModel.Convert(equalize(flat).At(1, 1)).(color.NRGBA); c != flat.NRGBAAt(1, 1) {
+		t.Errorf("однотонное изображение изменилось: %v", c)
+	}
+
+	// цвет сохраняется: каналы сдвигаются одинаково
+	c := color.NRGBAModel.Convert(equalize(rampImage(64, 2, [3]uint8{100, 80, 60}, [3]uint8{140, 120, 100})).At(40, 0)).(color.NRGBA)
+	if d1, d2 := int(c.R)-int(c.G), int(c.G)-int(c.B); d1 < 18 || d1 > 22 || d2 < 18 || d2 > 22 {
+		t.Errorf("разница каналов не сохранилась: %v", c)
+	}
+}
+
+func TestCLAHE(t *testing.T) {
+	src := rampImage(64, 64, [3]uint8{100, 100, 100}, [3]uint8{140, 140, 140})
+	out := clahe(src, 4, 4)
+	if out.Bounds() != src.Bounds() {
+		t.Fatalf("размер %v", out.Bounds())
+	}
+	lo, hi := channelRange(out, 0)
+	if int(hi)-int(lo) <= 40 {
+		t.Errorf("контраст не увеличился: %d..%d", lo, hi)
+	}
+
+	// больше плиток, чем пикселей, - не ошибка
+	if out := clahe(testImage(3, 2), 16, 2); out.Bounds().Size() != image.Pt(3, 2) {
+		t.Errorf("маленькое изображение: %v", out.Bounds())
+	}
+}
+
+func TestCLAHEMap(t *testing.T) {
+	empty := claheMap(make([]float64, claheBins), 2)
+	if empty[0] != 0 || empty[claheBins-1] != 1 {
+		t.Errorf("пустая гистограмма: %g..%g, ожидается тождественная кривая", empty[0], empty[claheBins-1])
+	}
+
+	bins := make([]float64, claheBins)
+	bins[10], bins[200] = 500, 20
+	m := claheMap(bins, 2)
+	for i := 1; i < len(m); i++ {
+		if m[i] < m[i-1] || m[i] > 1 {
+			t.Fatalf("кривая не монотонна в %d: %g, %g", i, m[i-1], m[i])
+		}
+	}
+}
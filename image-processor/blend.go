@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// intensityParam - сила эффекта, есть у каждого фильтра
+var intensityParam = numberParam("intensity", "Сила, %", 0, 100, 1, 100)
+
+// blendFilter - смешивание результата фильтра out с исходным src. Вес эффекта -
+// intensity (0..1), умноженная на яркость маски (белое - полный эффект,
+// черное и прозрачное - без эффекта). Маска растягивается на размер изображения.
+func blendFilter(src, out image.Image, intensity float64, mask image.Image) image.Image {
+	if intensity >= 1 && mask == nil {
+		return out
+	}
+	if intensity <= 0 {
+		return src
+	}
+
+	b := src.Bounds()
+	if mask != nil {
+		if mb := mask.Bounds(); mb.Dx() != b.Dx() || mb.Dy() != b.Dy() {
+			mask = resampleImage(mask, b.Dx(), b.Dy(), resampleKernels["bilinear"])
+		}
+	}
+
+	deep := isDeep(src) || isDeep(out)
+	var dst image.Image
+	var set func(x, y int, c color.NRGBA64)
+	if deep {
+		d := image.NewNRGBA64(b)
+		dst, set = d, d.SetNRGBA64
+	} else {
+		d := image.NewNRGBA(b)
+		dst, set = d, func(x, y int, c color.NRGBA64) {
+			d.SetNRGBA(x, y, color.NRGBA{round16to8(c.R), round16to8(c.G), round16to8(c.B), round16to8(c.A)})
+		}
+	}
+
+	ob, mb := out.Bounds(), image.Rectangle{}
+	if mask != nil {
+		mb = mask.Bounds()
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			w := intensity
+			if mask != nil {
+				// яркость маски с учетом ее прозрачности (RGBA() уже предумножен)
+				r, g, bl, _ := mask.At(mb.Min.X+x, mb.Min.Y+y).RGBA()
+				w *= (lumaR*float64(r) + lumaG*float64(g) + lumaB*float64(bl)) / 0xffff
+			}
+
+			s := straightAt(src, b.Min.X+x, b.Min.Y+y)
+			if w <= 0 {
+				set(b.Min.X+x, b.Min.Y+y, s)
+				continue
+			}
+			o := straightAt(out, ob.Min.X+x, ob.Min.Y+y)
+			mix := func(a, b uint16) uint16 {
+				return uint16(float64(a) + (float64(b)-float64(a))*w + 0.5)
+			}
+			set(b.Min.X+x, b.Min.Y+y, color.NRGBA64{mix(s.R, o.R), mix(s.G, o.G), mix(s.B, o.B), mix(s.A, o.A)})
+		}
+	}
+	return dst
+}
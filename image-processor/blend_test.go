@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFilterIntensity(t *testing.T) {
+	in := color.NRGBA{200, 100, 50, 255}
+	tests := []struct {
+		intensity float64
+		want      color.NRGBA
+	}{
+		{100, color.NRGBA{0, 0, 0, 255}},
+		{50, color.NRGBA{100, 50, 25, 255}},
+		{25, color.NRGBA{150, 75, 38, 255}},
+		{0, in},
+	}
+	for _, tt := range tests {
+		got := filterPixel(t, "brightness", map[string]interface{}{"amount": -100.0, "intensity": tt.intensity}, in)
+		if !closeColor(got, tt.want) {
+			t.Errorf("intensity %g: %v, ожидается %v", tt.intensity, got, tt.want)
+		}
+	}
+}
+
+func TestBlendFilterMask(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	out := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	for x := 0; x < 4; x++ {
+		src.SetNRGBA(x, 0, color.NRGBA{0, 0, 0, 255})
+		out.SetNRGBA(x, 0, color.NRGBA{200, 200, 200, 255})
+	}
+	// маска вдвое меньше изображения: белый и черный пиксели растягиваются
+	mask := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	mask.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255})
+	mask.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 0}) // прозрачное - без эффекта
+
+	res := blendFilter(src, out, 1, mask)
+	if res.Bounds() != src.Bounds() {
+		t.Fatalf("размер %v", res.Bounds())
+	}
+	first := color.NRGBAModel.Convert(res.At(0, 0)).(color.NRGBA)
+	last := color.NRGBAModel.Convert(res.At(3, 0)).(color.NRGBA)
+	if first.R < 150 || last.R > 50 {
+		t.Errorf("слева %v (ожидается эффект), справа %v (ожидается исходник)", first, last)
+	}
+
+	if got := blendFilter(src, out, 1, nil); got != image.Image(out) {
+		t.Error("полная сила без маски должна вернуть результат фильтра")
+	}
+	if got := blendFilter(src, out, 0, mask); got != image.Image(src) {
+		t.Error("нулевая сила должна вернуть исходник")
+	}
+	if !isDeep(blendFilter(image.NewNRGBA64(src.Bounds()), out, 0.5, nil)) {
+		t.Error("смешивание с 16-битным исходником стало 8-битным")
+	}
+}
+
+// Маска шага filter берется из файла формы
+func TestFilterStepMask(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("m1", "mask.png")
+	mask := image.NewGray(image.Rect(0, 0, 2, 1))
+	mask.Pix[0] = 255
+	png.Encode(fw, mask)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/process", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parsePipelineOptions(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parsePipeline(`[{"op":"filter","name":"invert","mask":"m2"}]`, opts); err == nil {
+		t.Error("маска без файла в форме принята")
+	}
+	p, err := parsePipeline(`[{"op":"filter","name":"invert","mask":"m1"}]`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	out, err := p.run(src, newRunContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := color.NRGBAModel.Convert(out.At(0, 1)).(color.NRGBA)
+	right := color.NRGBAModel.Convert(out.At(3, 1)).(color.NRGBA)
+	if left.R > 50 || right.R < 200 {
+		t.Errorf("слева %v (ожидается инверсия), справа %v (ожидается исходник)", left, right)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// namedColors - имена цветов, которые можно передавать вместо hex
+var namedColors = map[string]color.NRGBA{
+	"transparent": {0, 0, 0, 0},
+	"white":       {255, 255, 255, 255},
+	"black":       {0, 0, 0, 255},
+	"gray":        {128, 128, 128, 255},
+	"red":         {255, 0, 0, 255},
+	"green":       {0, 128, 0, 255},
+	"blue":        {0, 0, 255, 255},
+}
+
+// parseColor - разбор цвета: #rgb, #rgba, #rrggbb, #rrggbbaa или имя
+func parseColor(s string) (color.NRGBA, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := namedColors[s]; ok {
+		return c, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	switch len(hex) {
+	case 3, 4:
+		// короткая запись: каждая цифра повторяется
+		var long strings.Builder
+		for _, ch := range hex {
+			long.WriteRune(ch)
+			long.WriteRune(ch)
+		}
+		hex = long.String()
+	case 6, 8:
+	default:
+		return color.NRGBA{}, fmt.Errorf("неверный цвет %q", s)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("неверный цвет %q", s)
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
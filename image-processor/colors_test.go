@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.NRGBA
+		wantErr bool
+	}{
+		{"white", color.NRGBA{255, 255, 255, 255}, false},
+		{" Transparent ", color.NRGBA{}, false},
+		{"#f80", color.NRGBA{0xff, 0x88, 0x00, 0xff}, false},
+		{"#f808", color.NRGBA{0xff, 0x88, 0x00, 0x88}, false},
+		{"#1A2b3C", color.NRGBA{0x1a, 0x2b, 0x3c, 0xff}, false},
+		{"1a2b3c80", color.NRGBA{0x1a, 0x2b, 0x3c, 0x80}, false},
+		{"#12345", color.NRGBA{}, true},
+		{"#ggg", color.NRGBA{}, true},
+		{"purple", color.NRGBA{}, true},
+		{"", color.NRGBA{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseColor(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseColor(%q) = %v, %v, ожидается %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestFlattenAlpha(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{0, 0, 255, 0})    // прозрачный - цвет не виден
+	src.SetNRGBA(1, 0, color.NRGBA{0, 0, 255, 128})  // полупрозрачный синий
+	src.SetNRGBA(2, 0, color.NRGBA{10, 20, 30, 255}) // непрозрачный
+
+	tests := []struct {
+		bg   color.NRGBA
+		want [3]color.NRGBA
+	}{
+		{color.NRGBA{255, 0, 0, 255}, [3]color.NRGBA{{255, 0, 0, 255}, {127, 0, 128, 255}, {10, 20, 30, 255}}},
+		// полупрозрачный фон сначала накладывается на белый
+		{color.NRGBA{0, 0, 0, 128}, [3]color.NRGBA{{127, 127, 127, 255}, {63, 63, 191, 255}, {10, 20, 30, 255}}},
+	}
+	for _, tt := range tests {
+		out := flattenAlpha(src, tt.bg)
+		for x, want := range tt.want {
+			got := color.NRGBAModel.Convert(out.At(x, 0)).(color.NRGBA)
+			if absDiff(got.R, want.R) > 1 || absDiff(got.G, want.G) > 1 || absDiff(got.B, want.B) > 1 || got.A != 255 {
+				t.Errorf("фон %v, пиксель %d: %v, ожидается %v", tt.bg, x, got, want)
+			}
+		}
+	}
+
+	opaque := testImage(2, 2)
+	if flattenAlpha(opaque, color.NRGBA{}) != image.Image(opaque) {
+		t.Error("непрозрачное изображение скопировано")
+	}
+	if !isDeep(flattenAlpha(image.NewNRGBA64(image.Rect(0, 0, 2, 2)), color.NRGBA{A: 255})) {
+		t.Error("16-битное изображение стало 8-битным")
+	}
+}
+
+// Для JPEG прозрачность заменяется фоном из запроса
+func TestEncodeJPEGBackground(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16)) // полностью прозрачное
+	for _, bg := range []color.NRGBA{{255, 255, 255, 255}, {0, 0, 0, 255}, {200, 30, 30, 255}} {
+		data, err := encodeImage(src, "jpg", 95, bg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := decodeImage(data, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := color.NRGBAModel.Convert(img.At(8, 8)).(color.NRGBA)
+		if absDiff(got.R, bg.R) > 4 || absDiff(got.G, bg.G) > 4 || absDiff(got.B, bg.B) > 4 {
+			t.Errorf("фон %v: получено %v", bg, got)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// Чтение и запись метаданных в контейнерах. Метаданные переносятся
+// в JPEG, PNG и WebP; BMP, GIF, TIFF и QOI пишутся без них.
+
+// Сигнатуры блоков метаданных
+var (
+	xmpHeaderJPEG = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeaderJPEG = []byte("ICC_PROFILE\x00")
+	psHeaderJPEG  = []byte("Photoshop 3.0\x00")
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// Ограничения контейнеров
+const (
+	jpegMaxSegment = 65533 // данные сегмента без маркера и длины
+	jpegICCChunk   = jpegMaxSegment - 14
+	iccMaxSize     = 16 << 20 // предел распакованного ICC-профиля из PNG
+	textMaxSize    = 4 << 20  // предел распакованного текста iTXt (XMP) из PNG
+	iptcResourceID = 0x0404   // ресурс Photoshop с записями IPTC-IIM
+	pngXMPKeyword  = "XML:com.adobe.xmp"
+)
+
+// Флаги чанка VP8X
+const (
+	webpFlagICC   = 0x20
+	webpFlagAlpha = 0x10
+	webpFlagEXIF  = 0x08
+	webpFlagXMP   = 0x04
+)
+
+// fileChunk - чанк PNG или RIFF (без длины и CRC)
+type fileChunk struct {
+	Type string
+	Data []byte
+}
+
+// keyword - ключевое слово текстового чанка
+func (c fileChunk) keyword() string {
+	if i := bytes.IndexByte(c.Data, 0); i >= 0 {
+		return string(c.Data[:i])
+	}
+	return ""
+}
+
+// readMetadata - метаданные исходного файла (для TIFF - страницы page)
+func readMetadata(data []byte, format string, page int) *imageMeta {
+	m := &imageMeta{}
+	switch format {
+	case "jpeg":
+		var icc [][]byte
+		for _, s := range jpegSegments(data) {
+			switch {
+			case s.Marker == 0xe1 && bytes.HasPrefix(s.Data, exifHeader):
+				m.EXIF = s.Data[len(exifHeader):]
+			case s.Marker == 0xe1 && bytes.HasPrefix(s.Data, xmpHeaderJPEG):
+				m.XMP = s.Data[len(xmpHeaderJPEG):]
+			case s.Marker == 0xe2 && bytes.HasPrefix(s.Data, iccHeaderJPEG) && len(s.Data) > 14:
+				// части профиля пронумерованы с 1
+				seq := int(s.Data[12])
+				for len(icc) < seq {
+					icc = append(icc, nil)
+				}
+				if seq > 0 {
+					icc[seq-1] = s.Data[14:]
+				}
+			case s.Marker == 0xed && bytes.HasPrefix(s.Data, psHeaderJPEG):
+				m.IPTC = photoshopResource(s.Data[len(psHeaderJPEG):], iptcResourceID)
+			}
+		}
+		m.ICC = bytes.Join(icc, nil)
+
+	case "png":
+		for _, c := range pngChunks(data) {
+			switch c.Type {
+			case "eXIf":
+				m.EXIF = bytes.TrimPrefix(c.Data, exifHeader)
+			case "iCCP":
+				m.ICC = pngICC(c.Data)
+			case "iTXt":
+				if c.keyword() == pngXMPKeyword {
+					m.XMP = pngITXtText(c.Data)
+					continue
+				}
+				m.Text = append(m.Text, c)
+			case "tEXt", "zTXt":
+				m.Text = append(m.Text, c)
+			}
+		}
+
+	case "webp":
+		for _, c := range riffChunks(data) {
+			switch c.Type {
+			case "EXIF":
+				m.EXIF = bytes.TrimPrefix(c.Data, exifHeader)
+			case "ICCP":
+				m.ICC = c.Data
+			case "XMP ":
+				m.XMP = c.Data
+			}
+		}
+
+	case "tiff":
+		if page > 0 {
+			var err error
+			if data, err = tiffPage(data, page); err != nil {
+				return m
+			}
+		}
+		m.EXIF = data
+		if exif := parseExif(data); exif != nil {
+			for _, e := range exif.ifd0.Entries {
+				switch e.Tag {
+				case tiffTagXMP:
+					m.XMP = e.Value
+				case tiffTagIPTC:
+					m.IPTC = e.Value
+				case tiffTagICC:
+					m.ICC = e.Value
+				}
+			}
+		}
+	}
+	return m
+}
+
+// embedMetadata - запись метаданных в закодированный файл формата format
+func embedMetadata(data []byte, format string, m *imageMeta) []byte {
+	if m == nil || m.EXIF == nil && m.XMP == nil && m.ICC == nil && m.IPTC == nil && m.Text == nil {
+		return data
+	}
+	switch format {
+	case "jpg":
+		return embedJPEG(data, m)
+	case "png":
+		return embedPNG(data, m)
+	case "webp":
+		return embedWebP(data, m)
+	}
+	return data
+}
+
+// embedJPEG - сегменты APP1 (EXIF, XMP), APP2 (ICC) и APP13 (IPTC) сразу после SOI.
+// Блоки, не помещающиеся в сегмент, пропускаются.
+func embedJPEG(data []byte, m *imageMeta) []byte {
+	if len(data) < 2 {
+		return data
+	}
+	var segs bytes.Buffer
+	write := func(marker byte, parts ...[]byte) {
+		n := 0
+		for _, p := range parts {
+			n += len(p)
+		}
+		if n > jpegMaxSegment {
+			return
+		}
+		segs.Write([]byte{0xff, marker, byte((n + 2) >> 8), byte(n + 2)})
+		for _, p := range parts {
+			segs.Write(p)
+		}
+	}
+
+	if m.EXIF != nil {
+		write(0xe1, exifHeader, m.EXIF)
+	}
+	if m.XMP != nil {
+		write(0xe1, xmpHeaderJPEG, m.XMP)
+	}
+	if m.ICC != nil {
+		count := (len(m.ICC) + jpegICCChunk - 1) / jpegICCChunk
+		if count <= 255 {
+			for i := 0; i < count; i++ {
+				chunk := m.ICC[i*jpegICCChunk : min((i+1)*jpegICCChunk, len(m.ICC))]
+				write(0xe2, iccHeaderJPEG, []byte{byte(i + 1), byte(count)}, chunk)
+			}
+		}
+	}
+	if m.IPTC != nil {
+		write(0xed, psHeaderJPEG, photoshopIRB(iptcResourceID, m.IPTC))
+	}
+
+	out := make([]byte, 0, len(data)+segs.Len())
+	out = append(out, data[:2]...)
+	out = append(out, segs.Bytes()...)
+	return append(out, data[2:]...)
+}
+
+// embedPNG - чанки iCCP, eXIf, iTXt (XMP) и текстовые сразу после IHDR.
+// IPTC в PNG стандартного места не имеет и не переносится.
+func embedPNG(data []byte, m *imageMeta) []byte {
+	chunks := pngChunks(data)
+	if len(chunks) == 0 || chunks[0].Type != "IHDR" {
+		return data
+	}
+	ihdrEnd := len(pngSignature) + 12 + len(chunks[0].Data)
+
+	var extra bytes.Buffer
+	if m.ICC != nil {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(m.ICC)
+		zw.Close()
+		writePNGChunk(&extra, "iCCP", append([]byte("ICC Profile\x00\x00"), z.Bytes()...))
+	}
+	if m.EXIF != nil {
+		writePNGChunk(&extra, "eXIf", m.EXIF)
+	}
+	if m.XMP != nil {
+		// ключевое слово, флаг и метод сжатия, пустые язык и перевод
+		head := append([]byte(pngXMPKeyword), 0, 0, 0, 0, 0)
+		writePNGChunk(&extra, "iTXt", append(head, m.XMP...))
+	}
+	for _, c := range m.Text {
+		writePNGChunk(&extra, c.Type, c.Data)
+	}
+
+	out := make([]byte, 0, len(data)+extra.Len())
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, extra.Bytes()...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+// embedWebP - перевод в расширенный формат (VP8X) с чанками ICCP, EXIF и XMP.
+// Поддерживается только результат собственного кодировщика (один чанк VP8L).
+func embedWebP(data []byte, m *imageMeta) []byte {
+	chunks := riffChunks(data)
+	if len(chunks) != 1 || chunks[0].Type != "VP8L" || len(chunks[0].Data) < 5 {
+		return data
+	}
+	vp8l := chunks[0].Data
+	hdr := binary.LittleEndian.Uint32(vp8l[1:])
+	width := hdr&0x3fff + 1
+	height := hdr>>14&0x3fff + 1
+
+	var flags byte
+	if hdr>>28&1 == 1 { // после 14 бит ширины и 14 бит высоты
+		flags |= webpFlagAlpha
+	}
+	if m.ICC != nil {
+		flags |= webpFlagICC
+	}
+	if m.EXIF != nil {
+		flags |= webpFlagEXIF
+	}
+	if m.XMP != nil {
+		flags |= webpFlagXMP
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = flags
+	putUint24(vp8x[4:], width-1)
+	putUint24(vp8x[7:], height-1)
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	writeRIFFChunk(&body, "VP8X", vp8x)
+	if m.ICC != nil {
+		writeRIFFChunk(&body, "ICCP", m.ICC)
+	}
+	writeRIFFChunk(&body, "VP8L", vp8l)
+	if m.EXIF != nil {
+		writeRIFFChunk(&body, "EXIF", m.EXIF)
+	}
+	if m.XMP != nil {
+		writeRIFFChunk(&body, "XMP ", m.XMP)
+	}
+
+	out := make([]byte, 8, 8+body.Len())
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(body.Len()))
+	return append(out, body.Bytes()...)
+}
+
+// photoshopResource - данные ресурса id из блока Photoshop IRB
+func photoshopResource(irb []byte, id uint16) []byte {
+	for p := 0; p+12 <= len(irb) && string(irb[p:p+4]) == "8BIM"; {
+		rid := binary.BigEndian.Uint16(irb[p+4:])
+		// имя - строка Pascal, выровненная до четной длины
+		nameLen := int(irb[p+6]) + 1
+		nameLen += nameLen & 1
+		q := p + 6 + nameLen
+		if q+4 > len(irb) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(irb[q:]))
+		q += 4
+		if size < 0 || q+size > len(irb) {
+			break
+		}
+		if rid == id {
+			return irb[q : q+size]
+		}
+		p = q + size + size&1
+	}
+	return nil
+}
+
+// photoshopIRB - блок Photoshop IRB из одного ресурса с пустым именем
+func photoshopIRB(id uint16, data []byte) []byte {
+	out := []byte("8BIM")
+	out = binary.BigEndian.AppendUint16(out, id)
+	out = append(out, 0, 0)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// pngChunks - чанки PNG до IEND (CRC не проверяется)
+func pngChunks(data []byte) []fileChunk {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil
+	}
+	var chunks []fileChunk
+	for p := len(pngSignature); p+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[p:]))
+		if n < 0 || p+12+n > len(data) {
+			break
+		}
+		c := fileChunk{string(data[p+4 : p+8]), data[p+8 : p+8+n]}
+		chunks = append(chunks, c)
+		if c.Type == "IEND" {
+			break
+		}
+		p += 12 + n
+	}
+	return chunks
+}
+
+func writePNGChunk(w *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	w.Write(n[:])
+	crc := crc32.NewIEEE()
+	io.WriteString(crc, typ)
+	crc.Write(data)
+	w.WriteString(typ)
+	w.Write(data)
+	w.Write(crc.Sum(nil))
+}
+
+// pngICC - распакованный профиль из чанка iCCP (имя, 0, метод сжатия, zlib)
+func pngICC(data []byte) []byte {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 || i+2 > len(data) {
+		return nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data[i+2:]))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	icc, err := io.ReadAll(io.LimitReader(zr, iccMaxSize))
+	if err != nil {
+		return nil
+	}
+	return icc
+}
+
+// pngITXtText - текст чанка iTXt (при необходимости распакованный); nil -
+// чанк поврежден или текст длиннее textMaxSize
+func pngITXtText(data []byte) []byte {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 || i+3 > len(data) {
+		return nil
+	}
+	compressed := data[i+1] == 1
+	rest := data[i+3:]
+	// язык и переведенное ключевое слово
+	for k := 0; k < 2; k++ {
+		j := bytes.IndexByte(rest, 0)
+		if j < 0 {
+			return nil
+		}
+		rest = rest[j+1:]
+	}
+	if !compressed {
+		return rest
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	// больший текст отбрасывается: сжатый чанк в несколько КБ может
+	// распаковаться в гигабайты
+	text, err := io.ReadAll(io.LimitReader(zr, textMaxSize+1))
+	if err != nil || len(text) > textMaxSize {
+		return nil
+	}
+	return text
+}
+
+// riffChunks - чанки верхнего уровня файла WebP
+func riffChunks(data []byte) []fileChunk {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil
+	}
+	var chunks []fileChunk
+	for p := 12; p+8 <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[p+4:]))
+		if n < 0 || p+8+n > len(data) {
+			break
+		}
+		chunks = append(chunks, fileChunk{string(data[p : p+4]), data[p+8 : p+8+n]})
+		p += 8 + n + n&1
+	}
+	return chunks
+}
+
+func writeRIFFChunk(w *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(data)))
+	w.WriteString(typ)
+	w.Write(n[:])
+	w.Write(data)
+	if len(data)%2 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"testing"
+)
+
+// iTXtChunk - данные чанка iTXt с ключевым словом keyword
+func iTXtChunk(keyword string, text []byte, compress bool) []byte {
+	b := append([]byte(keyword), 0)
+	if compress {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(text)
+		zw.Close()
+		b = append(b, 1, 0, 0, 0) // сжат, метод 0, пустые язык и перевод
+		return append(b, z.Bytes()...)
+	}
+	b = append(b, 0, 0, 0, 0)
+	return append(b, text...)
+}
+
+func TestPNGITXtText(t *testing.T) {
+	xmp := []byte("<x:xmpmeta>test</x:xmpmeta>")
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"plain", iTXtChunk(pngXMPKeyword, xmp, false), xmp},
+		{"compressed", iTXtChunk(pngXMPKeyword, xmp, true), xmp},
+		{"at limit", iTXtChunk(pngXMPKeyword, make([]byte, textMaxSize), true), make([]byte, textMaxSize)},
+		{"bomb", iTXtChunk(pngXMPKeyword, make([]byte, textMaxSize+1), true), nil},
+		{"no keyword end", []byte("XML:com.adobe.xmp"), nil},
+		{"bad zlib", append([]byte("k\x00\x01\x00\x00\x00"), "garbage"...), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pngITXtText(tt.data); !bytes.Equal(got, tt.want) {
+				t.Errorf("получено %d байт, ожидается %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	exif := exifWithOrientation(1)
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF/></x:xmpmeta>`)
+	icc := encodeICC(builtinProfiles["display-p3"])
+
+	for _, format := range []string{"jpg", "png", "webp"} {
+		t.Run(format, func(t *testing.T) {
+			out, _ := lookupOutputFormat(format)
+			var buf bytes.Buffer
+			if err := out.Encode(&buf, testImage(4, 4), 90); err != nil {
+				t.Fatal(err)
+			}
+			data := embedMetadata(buf.Bytes(), format, &imageMeta{EXIF: exif, XMP: xmp, ICC: icc})
+
+			src, err := detectFormat(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("файл после встраивания не читается: %v", err)
+			}
+			m := readMetadata(data, src, 0)
+			if !bytes.Equal(m.EXIF, exif) {
+				t.Error("EXIF не совпадает")
+			}
+			if !bytes.Equal(m.XMP, xmp) {
+				t.Errorf("XMP не совпадает: %q", m.XMP)
+			}
+			if !bytes.Equal(m.ICC, icc) {
+				t.Error("ICC не совпадает")
+			}
+		})
+	}
+}
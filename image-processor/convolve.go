@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Свертка. Пиксели берутся предумноженными (как в resampleImage), иначе
+// цвет прозрачных пикселей "протекает" на соседние.
+
+// edgeMode - чем считаются пиксели за границей изображения
+type edgeMode int
+
+const (
+	edgeClamp  edgeMode = iota // повтор крайнего пикселя
+	edgeWrap                   // изображение повторяется (плитка)
+	edgeMirror                 // зеркальное отражение без повтора крайнего
+)
+
+// edgeModes - имена режимов краев в запросах (по номеру edgeMode)
+var edgeModes = []string{"clamp", "wrap", "mirror"}
+
+// edgeParam - режим краев, есть у всех фильтров на свертке
+var edgeParam = choiceParam("edge", "Края", edgeModes, int(edgeClamp))
+
+// Ограничения свертки
+const (
+	maxKernelSide   = 25 // сторона пользовательского ядра
+	gaussBoxedSigma = 4  // при большей sigma гаусс заменяется тремя box-размытиями
+)
+
+// index - индекс в пределах 0..n-1 для координаты i, возможно вне изображения
+func (m edgeMode) index(i, n int) int {
+	if i >= 0 && i < n {
+		return i
+	}
+	switch m {
+	case edgeWrap:
+		return (i%n + n) % n
+	case edgeMirror:
+		if n == 1 {
+			return 0
+		}
+		period := 2 * (n - 1)
+		i = (i%period + period) % period
+		if i >= n {
+			i = period - i
+		}
+		return i
+	}
+	return clampInt(i, 0, n-1)
+}
+
+// plane - изображение w×h в предумноженных RGBA (0..65535, по 4 на пиксель)
+type plane struct {
+	w, h int
+	pix  []float32
+}
+
+func newPlane(img image.Image) *plane {
+	b := img.Bounds()
+	return &plane{b.Dx(), b.Dy(), toPremul(img)}
+}
+
+// image - результат с глубиной цвета исходника; значения обрезаются до
+// допустимых (цвет не больше альфы)
+func (p *plane) image(deep bool) image.Image {
+	for i := 0; i < len(p.pix); i += 4 {
+		a := clampF32(p.pix[i+3], 0, 65535)
+		p.pix[i] = clampF32(p.pix[i], 0, a)
+		p.pix[i+1] = clampF32(p.pix[i+1], 0, a)
+		p.pix[i+2] = clampF32(p.pix[i+2], 0, a)
+		p.pix[i+3] = a
+	}
+	return fromPremul(p.pix, p.w, p.h, deep)
+}
+
+// transpose - строки становятся столбцами: вертикальный проход сводится
+// к горизонтальному
+func (p *plane) transpose() *plane {
+	t := &plane{p.h, p.w, make([]float32, len(p.pix))}
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			copy(t.pix[(x*t.w+y)*4:(x*t.w+y)*4+4], p.pix[(y*p.w+x)*4:])
+		}
+	}
+	return t
+}
+
+// separable - горизонтальный проход rows, затем он же по столбцам
+func (p *plane) separable(rows func(*plane) *plane) *plane {
+	return rows(rows(p).transpose()).transpose()
+}
+
+// convolveRows - свертка строк одномерным ядром k (центр - середина k)
+func convolveRows(p *plane, k []float32, edge edgeMode) *plane {
+	out := &plane{p.w, p.h, make([]float32, len(p.pix))}
+	r := len(k) / 2
+	for y := 0; y < p.h; y++ {
+		row := p.pix[y*p.w*4 : (y+1)*p.w*4]
+		for x := 0; x < p.w; x++ {
+			var s [4]float32
+			for j, wt := range k {
+				src := row[edge.index(x+j-r, p.w)*4:]
+				s[0] += src[0] * wt
+				s[1] += src[1] * wt
+				s[2] += src[2] * wt
+				s[3] += src[3] * wt
+			}
+			copy(out.pix[(y*p.w+x)*4:], s[:])
+		}
+	}
+	return out
+}
+
+// boxRows - скользящее среднее по строкам с радиусом r; время не зависит от r
+func boxRows(p *plane, r int, edge edgeMode) *plane {
+	out := &plane{p.w, p.h, make([]float32, len(p.pix))}
+	norm := 1 / float32(2*r+1)
+	for y := 0; y < p.h; y++ {
+		row := p.pix[y*p.w*4 : (y+1)*p.w*4]
+		var s [4]float64 // в float64, чтобы ошибка не накапливалась вдоль строки
+		for i := -r; i <= r; i++ {
+			src := row[edge.index(i, p.w)*4:]
+			for c := 0; c < 4; c++ {
+				s[c] += float64(src[c])
+			}
+		}
+		for x := 0; x < p.w; x++ {
+			dst := out.pix[(y*p.w+x)*4:]
+			for c := 0; c < 4; c++ {
+				dst[c] = float32(s[c]) * norm
+			}
+			add, sub := row[edge.index(x+r+1, p.w)*4:], row[edge.index(x-r, p.w)*4:]
+			for c := 0; c < 4; c++ {
+				s[c] += float64(add[c] - sub[c])
+			}
+		}
+	}
+	return out
+}
+
+// boxBlur - размытие средним по квадрату (2r+1)×(2r+1)
+func boxBlur(p *plane, r int, edge edgeMode) *plane {
+	return p.separable(func(q *plane) *plane { return boxRows(q, r, edge) })
+}
+
+// gaussianBlur - размытие Гаусса. Малые sigma - точным сепарабельным ядром,
+// большие - тремя последовательными box-размытиями (отличие от гаусса
+// меньше 3%, а время не зависит от радиуса).
+func gaussianBlur(p *plane, sigma float64, edge edgeMode) *plane {
+	if sigma > gaussBoxedSigma {
+		for _, r := range gaussBoxes(sigma, 3) {
+			p = boxBlur(p, r, edge)
+		}
+		return p
+	}
+
+	r := int(math.Ceil(3 * sigma))
+	k := make([]float32, 2*r+1)
+	var sum float32
+	for i := range k {
+		x := float64(i - r)
+		k[i] = float32(math.Exp(-x * x / (2 * sigma * sigma)))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return p.separable(func(q *plane) *plane { return convolveRows(q, k, edge) })
+}
+
+// gaussBoxes - радиусы n box-размытий, вместе дающих гаусс с заданной sigma
+func gaussBoxes(sigma float64, n int) []int {
+	ideal := math.Sqrt(12*sigma*sigma/float64(n) + 1)
+	wl := int(ideal)
+	if wl%2 == 0 {
+		wl--
+	}
+	wu := wl + 2
+	m := int(math.Round((12*sigma*sigma - float64(n*wl*wl) - float64(4*n*wl) - float64(3*n)) / float64(-4*wl-4)))
+
+	radii := make([]int, n)
+	for i := range radii {
+		if i < m {
+			radii[i] = (wl - 1) / 2
+		} else {
+			radii[i] = (wu - 1) / 2
+		}
+	}
+	return radii
+}
+
+// kernel2D - матрица свертки; центр - в середине (у четных сторон - правее/ниже)
+type kernel2D struct {
+	w, h int
+	data []float32
+}
+
+// taps - число ненулевых элементов (нулевые convolve2D пропускает)
+func (k kernel2D) taps() int {
+	n := 0
+	for _, v := range k.data {
+		if v != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// checkConvolveWork - предел объема работы для свертки матрицей k, общий с
+// шумоподавлением: элемент ядра на пиксель обходится примерно в две единицы
+func checkConvolveWork(w, h int, k kernel2D) error {
+	return checkDenoiseWork("convolve", w, h, 2*float64(k.taps()))
+}
+
+// convolve2D - свертка произвольной матрицей; bias добавляется к цвету
+// (в долях от 0..1, с учетом прозрачности)
+func convolve2D(p *plane, k kernel2D, bias float32, edge edgeMode) *plane {
+	out := &plane{p.w, p.h, make([]float32, len(p.pix))}
+	cx, cy := k.w/2, k.h/2
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			var s [3]float32
+			for ky := 0; ky < k.h; ky++ {
+				row := edge.index(y+ky-cy, p.h) * p.w
+				for kx := 0; kx < k.w; kx++ {
+					wt := k.data[ky*k.w+kx]
+					if wt == 0 {
+						continue
+					}
+					src := p.pix[(row+edge.index(x+kx-cx, p.w))*4:]
+					s[0] += src[0] * wt
+					s[1] += src[1] * wt
+					s[2] += src[2] * wt
+				}
+			}
+			// альфа не сворачивается: контуры объектов остаются на месте
+			i := (y*p.w + x) * 4
+			a := p.pix[i+3]
+			out.pix[i] = s[0] + bias*a
+			out.pix[i+1] = s[1] + bias*a
+			out.pix[i+2] = s[2] + bias*a
+			out.pix[i+3] = a
+		}
+	}
+	return out
+}
+
+// lumaPlane - яркость (предумноженная) каждого пикселя во всех трех каналах
+func lumaPlane(p *plane) *plane {
+	out := &plane{p.w, p.h, make([]float32, len(p.pix))}
+	for i := 0; i < len(p.pix); i += 4 {
+		l := lumaR*p.pix[i] + lumaG*p.pix[i+1] + lumaB*p.pix[i+2]
+		out.pix[i], out.pix[i+1], out.pix[i+2], out.pix[i+3] = l, l, l, p.pix[i+3]
+	}
+	return out
+}
+
+// gradientMagnitude - модуль градиента яркости по паре ядер (Собель, Прюитт);
+// norm приводит максимально возможный отклик к 1
+func gradientMagnitude(p *plane, kx, ky kernel2D, norm float32, edge edgeMode) *plane {
+	l := lumaPlane(p)
+	gx := convolve2D(l, kx, 0, edge)
+	gy := convolve2D(l, ky, 0, edge)
+	for i := 0; i < len(gx.pix); i += 4 {
+		m := float32(math.Hypot(float64(gx.pix[i]), float64(gy.pix[i]))) / norm
+		gx.pix[i], gx.pix[i+1], gx.pix[i+2] = m, m, m
+	}
+	return gx
+}
+
+// Ядра выделения краев
+var (
+	sobelX   = kernel2D{3, 3, []float32{-1, 0, 1, -2, 0, 2, -1, 0, 1}}
+	sobelY   = kernel2D{3, 3, []float32{-1, -2, -1, 0, 0, 0, 1, 2, 1}}
+	prewittX = kernel2D{3, 3, []float32{-1, 0, 1, -1, 0, 1, -1, 0, 1}}
+	prewittY = kernel2D{3, 3, []float32{-1, -1, -1, 0, 0, 0, 1, 1, 1}}
+)
+
+// edgeOf - режим краев из значения параметра edge
+func edgeOf(p map[string]float64) edgeMode {
+	return edgeMode(p[edgeParam.ID])
+}
+
+// planeFilter - фильтр, работающий с предумноженной плоскостью
+func planeFilter(id, name, icon string, params []filterParam, apply func(p *plane, v map[string]float64) *plane) Filter {
+	return newFilter(id, name, icon, params, func(img image.Image, v map[string]float64) image.Image {
+		return apply(newPlane(img), v).image(isDeep(img))
+	})
+}
+
+// Фильтры на свертке
+func init() {
+	registerFilter(planeFilter("blur", "Размытие", "🌫️",
+		[]filterParam{numberParam("radius", "Радиус, px", 1, 100, 1, 2), edgeParam},
+		func(p *plane, v map[string]float64) *plane {
+			return boxBlur(p, int(v["radius"]), edgeOf(v))
+		}))
+	registerFilter(planeFilter("gaussian", "Размытие по Гауссу", "💨",
+		[]filterParam{numberParam("sigma", "Sigma, px", 0.1, 100, 0.1, 2), edgeParam},
+		func(p *plane, v map[string]float64) *plane {
+			return gaussianBlur(p, v["sigma"], edgeOf(v))
+		}))
+	registerFilter(planeFilter("sharpen", "Резкость", "🔪",
+		[]filterParam{numberParam("amount", "Сила", 0.1, 5, 0.1, 1), edgeParam},
+		func(p *plane, v map[string]float64) *plane {
+			a := float32(v["amount"])
+			k := kernel2D{3, 3, []float32{0, -a, 0, -a, 1 + 4*a, -a, 0, -a, 0}}
+			return convolve2D(p, k, 0, edgeOf(v))
+		}))
+	registerFilter(planeFilter("unsharp", "Нерезкая маска", "🔍",
+		[]filterParam{
+			numberParam("amount", "Сила, %", 0, 500, 1, 100),
+			numberParam("radius", "Радиус, px", 0.1, 50, 0.1, 2),
+			numberParam("threshold", "Порог", 0, 255, 1, 0),
+			edgeParam,
+		},
+		func(p *plane, v map[string]float64) *plane {
+			return unsharpMask(p, v["amount"]/100, v["radius"], v["threshold"], edgeOf(v))
+		}))
+	registerFilter(planeFilter("sobel", "Края (Собель)", "📐", []filterParam{edgeParam},
+		func(p *plane, v map[string]float64) *plane {
+			return gradientMagnitude(p, sobelX, sobelY, 4, edgeOf(v))
+		}))
+	registerFilter(planeFilter("prewitt", "Края (Прюитт)", "📏", []filterParam{edgeParam},
+		func(p *plane, v map[string]float64) *plane {
+			return gradientMagnitude(p, prewittX, prewittY, 3, edgeOf(v))
+		}))
+	registerFilter(planeFilter("emboss", "Тиснение", "🗿",
+		[]filterParam{numberParam("strength", "Сила", 0.1, 5, 0.1, 1), edgeParam},
+		func(p *plane, v map[string]float64) *plane {
+			s := float32(v["strength"])
+			k := kernel2D{3, 3, []float32{-s, -s, 0, -s, 0, s, 0, s, s}}
+			return convolve2D(lumaPlane(p), k, 0.5, edgeOf(v))
+		}))
+}
+
+// unsharpMask - усиление отличий от размытой копии: out = src + amount·(src - blur).
+// Отличия меньше threshold (в уровнях 0..255) не усиливаются - так не
+// проявляется шум на ровных участках.
+func unsharpMask(p *plane, amount, radius, threshold float64, edge edgeMode) *plane {
+	blur := gaussianBlur(p, radius, edge)
+	t := float32(threshold * 257)
+	a := float32(amount)
+	for i := 0; i < len(p.pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			d := p.pix[i+c] - blur.pix[i+c]
+			if d > t || d < -t {
+				blur.pix[i+c] = p.pix[i+c] + a*d
+			} else {
+				blur.pix[i+c] = p.pix[i+c]
+			}
+		}
+		blur.pix[i+3] = p.pix[i+3]
+	}
+	return blur
+}
+
+// convolveOperation - описание операции "convolve" для /api/filters
+func convolveOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"op":     "convolve",
+		"name":   "Свертка",
+		"params": []filterParam{edgeParam},
+		// kernel - матрица (массив строк), divisor и bias - числа
+		"kernel": map[string]interface{}{"max_side": maxKernelSide},
+	}
+}
+
+// parseKernel - проверка матрицы и нормировка на divisor
+func parseKernel(rows [][]float64, divisor float64) (kernel2D, error) {
+	h := len(rows)
+	if h == 0 {
+		return kernel2D{}, errors.New("kernel: ожидается непустая матрица, например [[0,-1,0],[-1,5,-1],[0,-1,0]]")
+	}
+	w := len(rows[0])
+	if w == 0 || w > maxKernelSide || h > maxKernelSide {
+		return kernel2D{}, fmt.Errorf("kernel: стороны матрицы от 1 до %d", maxKernelSide)
+	}
+
+	k := kernel2D{w, h, make([]float32, 0, w*h)}
+	var sum float64
+	for i, row := range rows {
+		if len(row) != w {
+			return kernel2D{}, fmt.Errorf("kernel: в строке %d %d элементов, ожидается %d", i, len(row), w)
+		}
+		for _, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return kernel2D{}, errors.New("kernel: элементы должны быть числами")
+			}
+			sum += v
+		}
+	}
+
+	if divisor == 0 {
+		divisor = sum
+		if math.Abs(divisor) < 1e-9 {
+			divisor = 1
+		}
+	}
+	if math.IsNaN(divisor) || math.IsInf(divisor, 0) {
+		return kernel2D{}, errors.New("divisor: ожидается число")
+	}
+	for _, row := range rows {
+		for _, v := range row {
+			k.data = append(k.data, float32(v/divisor))
+		}
+	}
+	return k, nil
+}
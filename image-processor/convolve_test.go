@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEdgeModeIndex(t *testing.T) {
+	tests := []struct {
+		mode edgeMode
+		i, n int
+		want int
+	}{
+		{edgeClamp, -3, 5, 0},
+		{edgeClamp, 7, 5, 4},
+		{edgeWrap, -1, 5, 4},
+		{edgeWrap, 12, 5, 2},
+		{edgeMirror, -1, 5, 1},
+		{edgeMirror, -4, 5, 4},
+		{edgeMirror, 5, 5, 3},
+		{edgeMirror, 9, 5, 1},
+		{edgeMirror, -7, 1, 0},
+		{edgeWrap, 2, 5, 2},
+	}
+	for _, tt := range tests {
+		if got := tt.mode.index(tt.i, tt.n); got != tt.want {
+			t.Errorf("%s.index(%d, %d) = %d, ожидается %d", edgeModes[tt.mode], tt.i, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseKernel(t *testing.T) {
+	big := make([][]float64, maxKernelSide+1)
+	for i := range big {
+		big[i] = []float64{1}
+	}
+	tests := []struct {
+		name    string
+		rows    [][]float64
+		divisor float64
+		want    []float32
+		wantErr string
+	}{
+		{"sharpen", [][]float64{{0, -1, 0}, {-1, 5, -1}, {0, -1, 0}}, 0, []float32{0, -1, 0, -1, 5, -1, 0, -1, 0}, ""},
+		{"auto divisor", [][]float64{{1, 1}, {1, 1}}, 0, []float32{0.25, 0.25, 0.25, 0.25}, ""},
+		{"zero sum", [][]float64{{-1, 1}}, 0, []float32{-1, 1}, ""},
+		{"divisor", [][]float64{{2, 4}}, 2, []float32{1, 2}, ""},
+		{"empty", nil, 0, nil, "непустая"},
+		{"empty row", [][]float64{{}}, 0, nil, "стороны"},
+		{"too tall", big, 0, nil, "стороны"},
+		{"ragged", [][]float64{{1, 2}, {3}}, 0, nil, "в строке 1"},
+		{"nan", [][]float64{{math.NaN()}}, 0, nil, "числами"},
+		{"inf divisor", [][]float64{{1}}, math.Inf(1), nil, "divisor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := parseKernel(tt.rows, tt.divisor)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидается с %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseKernel: %v", err)
+			}
+			if fmt.Sprint(k.data) != fmt.Sprint(tt.want) {
+				t.Errorf("ядро %v, ожидается %v", k.data, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvolveWorkLimit(t *testing.T) {
+	full := kernel2D{maxKernelSide, maxKernelSide, make([]float32, maxKernelSide*maxKernelSide)}
+	for i := range full.data {
+		full.data[i] = 1
+	}
+	sparse := kernel2D{maxKernelSide, maxKernelSide, make([]float32, maxKernelSide*maxKernelSide)}
+	sparse.data[0] = 1
+
+	if err := checkConvolveWork(4000, 4000, full); err == nil {
+		t.Error("ядро 25×25 на 4000×4000 пропущено")
+	}
+	if err := checkConvolveWork(4000, 4000, sparse); err != nil {
+		t.Errorf("ядро с одним ненулевым элементом: %v", err)
+	}
+	if err := checkConvolveWork(500, 500, full); err != nil {
+		t.Errorf("ядро 25×25 на 500×500: %v", err)
+	}
+
+	// отказ при выполнении шага - ошибка шага с кодом 422
+	row := "[" + strings.TrimSuffix(strings.Repeat("1,", maxKernelSide), ",") + "]"
+	kernel := "[" + strings.TrimSuffix(strings.Repeat(row+",", maxKernelSide), ",") + "]"
+	steps, err := parsePipeline(`[{"op":"convolve","kernel":`+kernel+`}]`, &pipelineOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = steps.run(image.NewNRGBA(image.Rect(0, 0, 2000, 1000)), newRunContext())
+	var se *stepError
+	if !errors.As(err, &se) || se.Op != "convolve" || runErrorStatus(err) != 422 {
+		t.Errorf("ошибка %v, ожидается отказ шага convolve", err)
+	}
+}
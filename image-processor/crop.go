@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// cropSpec - прямоугольник обрезки в пикселях или процентах, либо пропорции + gravity
+type cropSpec struct {
+	X, Y, Width, Height float64
+	Percent             bool
+	Aspect              float64 // > 0 - режим пропорций
+	Gravity             gravity
+}
+
+func buildCropStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	var p struct {
+		X       *float64 `json:"x"`
+		Y       *float64 `json:"y"`
+		Width   *float64 `json:"width"`
+		Height  *float64 `json:"height"`
+		Unit    string   `json:"unit"`
+		Aspect  string   `json:"aspect"`
+		Gravity string   `json:"gravity"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	spec := cropSpec{Gravity: opts.Gravity}
+	hasRect := p.X != nil || p.Y != nil || p.Width != nil || p.Height != nil
+
+	if p.Aspect != "" {
+		if hasRect || p.Unit != "" {
+			return nil, errors.New("aspect нельзя сочетать с x, y, width, height и unit")
+		}
+		aspect, err := parseAspect(p.Aspect)
+		if err != nil {
+			return nil, err
+		}
+		spec.Aspect = aspect
+		if p.Gravity != "" {
+			if spec.Gravity, err = parseGravity(p.Gravity); err != nil {
+				return nil, err
+			}
+		}
+		return func(img image.Image, rc *runContext) (image.Image, error) {
+			w, h := spec.aspectSize(img.Bounds())
+			return cropImage(img, rc.placeRect(img, w, h, spec.Gravity)), nil
+		}, nil
+	}
+
+	if p.Gravity != "" {
+		return nil, errors.New("gravity используется только вместе с aspect")
+	}
+	if p.Width == nil || p.Height == nil {
+		return nil, errors.New("нужно указать width и height или aspect")
+	}
+
+	switch p.Unit {
+	case "", "px":
+	case "%", "percent":
+		spec.Percent = true
+	default:
+		return nil, fmt.Errorf("unit: ожидается px или %%, получено %q", p.Unit)
+	}
+
+	if p.X != nil {
+		spec.X = *p.X
+	}
+	if p.Y != nil {
+		spec.Y = *p.Y
+	}
+	spec.Width, spec.Height = *p.Width, *p.Height
+
+	for _, v := range []float64{spec.X, spec.Y, spec.Width, spec.Height} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, errors.New("координаты должны быть конечными числами")
+		}
+	}
+	if spec.X < 0 || spec.Y < 0 {
+		return nil, errors.New("x и y не могут быть отрицательными")
+	}
+	if spec.Width <= 0 || spec.Height <= 0 {
+		return nil, errors.New("width и height должны быть положительными")
+	}
+	if spec.Percent && (spec.X+spec.Width > 100 || spec.Y+spec.Height > 100) {
+		return nil, fmt.Errorf("прямоугольник %g%%,%g%% %g%%×%g%% выходит за 100%%",
+			spec.X, spec.Y, spec.Width, spec.Height)
+	}
+
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		bounds := img.Bounds()
+		rect := spec.rect(bounds)
+		if rect.Empty() {
+			return nil, errors.New("область обрезки меньше одного пикселя")
+		}
+		if !rect.In(bounds) {
+			return nil, fmt.Errorf("прямоугольник x=%d y=%d %d×%d выходит за границы изображения %d×%d",
+				rect.Min.X-bounds.Min.X, rect.Min.Y-bounds.Min.Y, rect.Dx(), rect.Dy(), bounds.Dx(), bounds.Dy())
+		}
+		return cropImage(img, rect), nil
+	}, nil
+}
+
+// aspectSize - наибольшая область с пропорциями Aspect внутри bounds
+func (s cropSpec) aspectSize(bounds image.Rectangle) (int, int) {
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	cw, ch := w, math.Round(w/s.Aspect)
+	if ch > h {
+		cw, ch = math.Round(h*s.Aspect), h
+	}
+	return int(math.Max(cw, 1)), int(math.Max(ch, 1))
+}
+
+// rect - абсолютный прямоугольник обрезки для изображения с границами bounds
+func (s cropSpec) rect(bounds image.Rectangle) image.Rectangle {
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+
+	x, y, cw, ch := s.X, s.Y, s.Width, s.Height
+	if s.Percent {
+		x, y, cw, ch = x*w/100, y*h/100, cw*w/100, ch*h/100
+	}
+	x0, y0 := int(math.Round(x)), int(math.Round(y))
+	x1, y1 := int(math.Round(x+cw)), int(math.Round(y+ch))
+	return image.Rect(x0, y0, x1, y1).Add(bounds.Min)
+}
+
+// parseAspect - пропорции в виде "16:9", "16/9" или числа "1.5"
+func parseAspect(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	sep := strings.IndexAny(s, ":/x")
+	var aspect float64
+	if sep < 0 {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("aspect: неверное значение %q", s)
+		}
+		aspect = v
+	} else {
+		a, errA := strconv.ParseFloat(strings.TrimSpace(s[:sep]), 64)
+		b, errB := strconv.ParseFloat(strings.TrimSpace(s[sep+1:]), 64)
+		if errA != nil || errB != nil || b == 0 {
+			return 0, fmt.Errorf("aspect: неверное значение %q", s)
+		}
+		aspect = a / b
+	}
+	if !(aspect > 0) || math.IsInf(aspect, 0) {
+		return 0, fmt.Errorf("aspect: значение должно быть положительным, получено %q", s)
+	}
+	return aspect, nil
+}
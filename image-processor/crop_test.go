@@ -0,0 +1,122 @@
+package main
+
+import (
+	"image"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseAspect(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64 // 0 - ошибка
+	}{
+		{"16:9", 16.0 / 9},
+		{"4/3", 4.0 / 3},
+		{" 2 x 1 ", 2},
+		{"1.5", 1.5},
+		{"1:0", 0},
+		{"0:1", 0},
+		{"-1", 0},
+		{"abc", 0},
+		{"16:", 0},
+		{"inf", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseAspect(tt.in)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("parseAspect(%q) = %g, ожидается ошибка", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("parseAspect(%q) = %g, %v, ожидается %g", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestCropSpecRect(t *testing.T) {
+	bounds := image.Rect(10, 20, 210, 120) // 200×100 со смещенным началом
+	tests := []struct {
+		name string
+		spec cropSpec
+		want image.Rectangle
+	}{
+		{"pixels", cropSpec{X: 5, Y: 6, Width: 50, Height: 40}, image.Rect(15, 26, 65, 66)},
+		{"percent", cropSpec{X: 25, Y: 50, Width: 50, Height: 50, Percent: true}, image.Rect(60, 70, 160, 120)},
+		{"rounding", cropSpec{X: 0.4, Y: 0.6, Width: 10.2, Height: 10.2}, image.Rect(10, 21, 21, 31)},
+	}
+	for _, tt := range tests {
+		if got := tt.spec.rect(bounds); got != tt.want {
+			t.Errorf("%s: %v, ожидается %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCropAspectSize(t *testing.T) {
+	tests := []struct {
+		w, h   int
+		aspect float64
+		want   image.Point
+	}{
+		{200, 100, 1, image.Pt(100, 100)},
+		{200, 100, 16.0 / 9, image.Pt(178, 100)},
+		{100, 200, 16.0 / 9, image.Pt(100, 56)},
+		{300, 100, 3, image.Pt(300, 100)},
+		{1, 1000, 1000, image.Pt(1, 1)},
+		{1000, 1, 0.001, image.Pt(1, 1)},
+	}
+	for _, tt := range tests {
+		w, h := cropSpec{Aspect: tt.aspect}.aspectSize(image.Rect(0, 0, tt.w, tt.h))
+		if got := image.Pt(w, h); got != tt.want {
+			t.Errorf("%d×%d, aspect %g: %v, ожидается %v", tt.w, tt.h, tt.aspect, got, tt.want)
+		}
+	}
+}
+
+func TestCropStep(t *testing.T) {
+	tests := []struct {
+		params  string
+		want    image.Point
+		wantErr string
+	}{
+		{`{"x":2,"y":3,"width":10,"height":5}`, image.Pt(10, 5), ""},
+		{`{"width":50,"height":25,"unit":"%"}`, image.Pt(10, 3), ""},
+		{`{"aspect":"1:1"}`, image.Pt(12, 12), ""},
+		{`{"aspect":"1:1","gravity":"east"}`, image.Pt(12, 12), ""},
+
+		{`{"width":10}`, image.Point{}, "width и height"},
+		{`{"aspect":"1:1","width":10}`, image.Point{}, "нельзя сочетать"},
+		{`{"width":10,"height":5,"gravity":"north"}`, image.Point{}, "только вместе с aspect"},
+		{`{"width":10,"height":5,"unit":"cm"}`, image.Point{}, "unit"},
+		{`{"x":-1,"width":10,"height":5}`, image.Point{}, "отрицательными"},
+		{`{"width":0,"height":5}`, image.Point{}, "положительными"},
+		{`{"x":60,"width":50,"height":50,"unit":"%"}`, image.Point{}, "выходит за 100%"},
+		{`{"x":15,"width":10,"height":5}`, image.Point{}, "выходит за границы"},
+		{`{"width":0.2,"height":0.2}`, image.Point{}, "меньше одного пикселя"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.params, func(t *testing.T) {
+			p, err := parsePipeline(`[{"op":"crop",`+tt.params[1:]+`]`, defaultOptions(t))
+			var out image.Image
+			if err == nil {
+				out, err = p.run(testImage(20, 12), newRunContext())
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидается с %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := out.Bounds().Size(); got != tt.want {
+				t.Errorf("размер %v, ожидается %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Подавление шума. Как и свертка, работает с предумноженными значениями
+// (plane); за краем изображения - зеркальное отражение.
+
+// denoiseMaxWork - предел объема работы фильтров шумоподавления (единица -
+// около 10 нс), чтобы большие кадры не занимали сервер на минуты
+const denoiseMaxWork = 1.5e9
+
+// Фильтры шумоподавления
+func init() {
+	registerFilter(withWorkLimit(planeFilter("median", "Медиана", "🧹",
+		[]filterParam{numberParam("radius", "Радиус, px", 1, 15, 1, 1)},
+		func(p *plane, v map[string]float64) *plane {
+			return medianFilter(p, int(v["radius"]))
+		}),
+		func(w, h int, v map[string]float64) error {
+			// скользящая гистограмма: на пиксель и канал обновляется 2 столбца окна
+			return checkDenoiseWork("median", w, h, 8*(2*v["radius"]+1))
+		}))
+	registerFilter(withWorkLimit(planeFilter("bilateral", "Билатеральный", "🪞",
+		[]filterParam{
+			numberParam("sigma", "Радиус сглаживания, px", 0.5, 10, 0.5, 2),
+			numberParam("range", "Порог краев", 1, 100, 1, 20),
+		},
+		func(p *plane, v map[string]float64) *plane {
+			return bilateralFilter(p, v["sigma"], v["range"])
+		}),
+		func(w, h int, v map[string]float64) error {
+			side := 2*bilateralRadius(v["sigma"]) + 1
+			return checkDenoiseWork("bilateral", w, h, float64(side*side))
+		}))
+	registerFilter(withWorkLimit(planeFilter("nlmeans", "Шумоподавление (NLM)", "✨",
+		[]filterParam{
+			numberParam("strength", "Сила", 1, 50, 1, 10),
+			numberParam("search", "Радиус поиска, px", 1, 10, 1, 3),
+			numberParam("patch", "Радиус патча, px", 1, 3, 1, 1),
+		},
+		func(p *plane, v map[string]float64) *plane {
+			return nlMeans(p, v["strength"], int(v["search"]), int(v["patch"]))
+		}),
+		func(w, h int, v map[string]float64) error {
+			// время не зависит от размера патча (суммы по патчам - через интегральное изображение)
+			side := 2*v["search"] + 1
+			return checkDenoiseWork("nlmeans", w, h, 4*side*side)
+		}))
+}
+
+// checkDenoiseWork - отказ, если w×h×perPixel превышает denoiseMaxWork
+func checkDenoiseWork(name string, w, h int, perPixel float64) error {
+	if float64(w)*float64(h)*perPixel > denoiseMaxWork {
+		return fmt.Errorf("%s: слишком большой объем работы для %d×%d, уменьшите изображение или радиус", name, w, h)
+	}
+	return nil
+}
+
+// medianFilter - медиана по квадрату (2r+1)×(2r+1) для каждого канала.
+// Окно скользит змейкой, гистограмма обновляется по одному столбцу или
+// строке, а медиана сдвигается от предыдущей (алгоритм Хуанга). Чтобы не
+// терять 16 бит, гистограмма строится по рангам встречающихся значений.
+// Цвет не превышает альфу: порядковая статистика монотонна.
+func medianFilter(p *plane, r int) *plane {
+	n := p.w * p.h
+	out := &plane{p.w, p.h, make([]float32, len(p.pix))}
+
+	for c := 0; c < 4; c++ {
+		levels, ranks := rankValues(p.pix, c, n)
+		m := newMedianWindow(len(levels), (2*r+1)*(2*r+1))
+
+		// column, row - добавление (sign = 1) или удаление (sign = -1)
+		// столбца x или строки y окна с центром в cy или cx
+		column := func(x, cy, sign int) {
+			x = edgeMirror.index(x, p.w)
+			for y := cy - r; y <= cy+r; y++ {
+				m.update(ranks[edgeMirror.index(y, p.h)*p.w+x], sign)
+			}
+		}
+		row := func(y, cx, sign int) {
+			y = edgeMirror.index(y, p.h) * p.w
+			for x := cx - r; x <= cx+r; x++ {
+				m.update(ranks[y+edgeMirror.index(x, p.w)], sign)
+			}
+		}
+
+		for x := -r; x <= r; x++ {
+			column(x, 0, 1)
+		}
+		x, dir := 0, 1
+		for y := 0; y < p.h; y++ {
+			if y > 0 {
+				row(y-r-1, x, -1)
+				row(y+r, x, 1)
+			}
+			for {
+				out.pix[(y*p.w+x)*4+c] = float32(levels[m.median()])
+				if x+dir < 0 || x+dir >= p.w {
+					break
+				}
+				column(x-dir*r, y, -1)
+				x += dir
+				column(x+dir*r, y, 1)
+			}
+			dir = -dir
+		}
+	}
+	return out
+}
+
+// rankValues - различные значения канала c по возрастанию и номер значения
+// каждого пикселя в этом списке
+func rankValues(pix []float32, c, n int) ([]uint16, []uint16) {
+	var seen [65536]bool
+	for i := 0; i < n; i++ {
+		seen[uint16(pix[i*4+c]+0.5)] = true
+	}
+	var index [65536]uint16
+	levels := make([]uint16, 0, 256)
+	for v, ok := range seen {
+		if ok {
+			index[v] = uint16(len(levels))
+			levels = append(levels, uint16(v))
+		}
+	}
+	ranks := make([]uint16, n)
+	for i := range ranks {
+		ranks[i] = index[uint16(pix[i*4+c]+0.5)]
+	}
+	return levels, ranks
+}
+
+// medianWindow - гистограмма окна и текущая медиана
+type medianWindow struct {
+	hist []int32
+	k    int32 // номер медианы среди значений окна
+	med  int   // ячейка с медианой
+	lt   int32 // значений в ячейках меньше med
+}
+
+func newMedianWindow(bins, size int) *medianWindow {
+	return &medianWindow{hist: make([]int32, bins), k: int32(size / 2)}
+}
+
+// update - добавление (sign = 1) или удаление (sign = -1) значения
+func (m *medianWindow) update(bin uint16, sign int) {
+	m.hist[bin] += int32(sign)
+	if int(bin) < m.med {
+		m.lt += int32(sign)
+	}
+}
+
+// median - ячейка медианы; сдвиг от прошлой обычно короткий
+func (m *medianWindow) median() int {
+	for m.lt > m.k {
+		m.med--
+		m.lt -= m.hist[m.med]
+	}
+	for m.lt+m.hist[m.med] <= m.k {
+		m.lt += m.hist[m.med]
+		m.med++
+	}
+	return m.med
+}
+
+// bilateralRadius - радиус окна для пространственной sigma (2 sigma)
+func bilateralRadius(sigma float64) int {
+	return int(math.Ceil(2 * sigma))
+}
+
+// bilateralFilter - сглаживание с весом, падающим и с расстоянием, и с
+// отличием цвета (rangeSigma - в уровнях 0..255): шум усредняется, а
+// контрастные края не размываются
+func bilateralFilter(p *plane, sigma, rangeSigma float64) *plane {
+	r := bilateralRadius(sigma)
+	side := 2*r + 1
+	spatial := make([]float32, side*side)
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			spatial[(dy+r)*side+dx+r] = float32(math.Exp(-float64(dx*dx+dy*dy) / (2 * sigma * sigma)))
+		}
+	}
+
+	// вес по отличию цвета: таблица по квадрату расстояния в уровнях 0..255
+	// (сумма по 4 каналам), дальше 4 sigma вес считается нулевым
+	limit := int(math.Min(16*rangeSigma*rangeSigma, 4*255*255)) + 1
+	rangeW := make([]float32, limit)
+	for d := range rangeW {
+		rangeW[d] = float32(math.Exp(-float64(d) / (2 * rangeSigma * rangeSigma)))
+	}
+	const scale = 1.0 / (257 * 257)
+
+	out := &plane{p.w, p.h, make([]float32, len(p.pix))}
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			c := p.pix[(y*p.w+x)*4:]
+			var s [4]float32
+			var wsum float32
+			for dy := -r; dy <= r; dy++ {
+				row := edgeMirror.index(y+dy, p.h) * p.w
+				for dx := -r; dx <= r; dx++ {
+					q := p.pix[(row+edgeMirror.index(x+dx, p.w))*4:]
+					d0, d1, d2, d3 := q[0]-c[0], q[1]-c[1], q[2]-c[2], q[3]-c[3]
+					d := int((d0*d0 + d1*d1 + d2*d2 + d3*d3) * scale)
+					if d >= limit {
+						continue
+					}
+					wt := spatial[(dy+r)*side+dx+r] * rangeW[d]
+					s[0] += q[0] * wt
+					s[1] += q[1] * wt
+					s[2] += q[2] * wt
+					s[3] += q[3] * wt
+					wsum += wt
+				}
+			}
+			// wsum > 0: центральный пиксель всегда входит с весом 1
+			dst := out.pix[(y*p.w+x)*4:]
+			for i := range s {
+				dst[i] = s[i] / wsum
+			}
+		}
+	}
+	return out
+}
+
+// nlMeans - нелокальное среднее (non-local means): пиксель усредняется с
+// пикселями окна поиска, чья окрестность (патч) похожа на его собственную.
+// strength - в уровнях 0..255. Для каждого сдвига разности сворачиваются
+// по патчу через интегральное изображение, поэтому время не зависит от
+// размера патча.
+func nlMeans(p *plane, strength float64, search, patch int) *plane {
+	w, h := p.w, p.h
+	n := w * h
+	acc := make([]float32, len(p.pix))
+	wsum := make([]float32, n)
+	wmax := make([]float32, n)
+	diff := make([]float32, n)
+	sum := make([]float64, (w+1)*(h+1))
+
+	// средний квадрат разности по каналу, деленный на h², в вес exp(-t)
+	norm := 1 / (strength * strength * 257 * 257 * 4)
+
+	for oy := -search; oy <= search; oy++ {
+		for ox := -search; ox <= search; ox++ {
+			if ox == 0 && oy == 0 {
+				continue
+			}
+
+			for y := 0; y < h; y++ {
+				qy := edgeMirror.index(y+oy, h) * w
+				for x := 0; x < w; x++ {
+					a := p.pix[(y*w+x)*4:]
+					b := p.pix[(qy+edgeMirror.index(x+ox, w))*4:]
+					d0, d1, d2, d3 := a[0]-b[0], a[1]-b[1], a[2]-b[2], a[3]-b[3]
+					diff[y*w+x] = d0*d0 + d1*d1 + d2*d2 + d3*d3
+				}
+			}
+			integrate(diff, sum, w, h)
+
+			for y := 0; y < h; y++ {
+				y0, y1 := max(y-patch, 0), min(y+patch+1, h)
+				qy := edgeMirror.index(y+oy, h) * w
+				for x := 0; x < w; x++ {
+					x0, x1 := max(x-patch, 0), min(x+patch+1, w)
+					d := sum[y1*(w+1)+x1] - sum[y0*(w+1)+x1] - sum[y1*(w+1)+x0] + sum[y0*(w+1)+x0]
+					t := d / float64((y1-y0)*(x1-x0)) * norm
+					if t > 10 {
+						continue // вес меньше 5e-5
+					}
+					wt := float32(math.Exp(-t))
+
+					i := y*w + x
+					q := p.pix[(qy+edgeMirror.index(x+ox, w))*4:]
+					acc[i*4] += q[0] * wt
+					acc[i*4+1] += q[1] * wt
+					acc[i*4+2] += q[2] * wt
+					acc[i*4+3] += q[3] * wt
+					wsum[i] += wt
+					wmax[i] = max(wmax[i], wt)
+				}
+			}
+		}
+	}
+
+	// собственный вес пикселя - наибольший из весов соседей (вес 1 сделал бы
+	// его заметно сильнее всех остальных)
+	out := &plane{w, h, acc}
+	for i := 0; i < n; i++ {
+		self := wmax[i]
+		if self == 0 {
+			self = 1
+		}
+		total := wsum[i] + self
+		for c := 0; c < 4; c++ {
+			acc[i*4+c] = (acc[i*4+c] + p.pix[i*4+c]*self) / total
+		}
+	}
+	return out
+}
+
+// integrate - интегральное изображение: sum[y*(w+1)+x] - сумма v по
+// прямоугольнику [0,x)×[0,y)
+func integrate(v []float32, sum []float64, w, h int) {
+	for y := 0; y < h; y++ {
+		var row float64
+		for x := 0; x < w; x++ {
+			row += float64(v[y*w+x])
+			sum[(y+1)*(w+1)+x+1] = sum[y*(w+1)+x+1] + row
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// randomPlane - непрозрачная плоскость со случайным цветом
+func randomPlane(w, h int, seed int64) *plane {
+	rnd := rand.New(rand.NewSource(seed))
+	p := &plane{w, h, make([]float32, w*h*4)}
+	for i := 0; i < len(p.pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			p.pix[i+c] = float32(rnd.Intn(256) * 257)
+		}
+		p.pix[i+3] = 65535
+	}
+	return p
+}
+
+// Медиана совпадает с прямым подсчетом по окну с зеркальными краями
+func TestMedianFilter(t *testing.T) {
+	for _, r := range []int{1, 2, 3} {
+		p := randomPlane(9, 7, int64(r))
+		out := medianFilter(p, r)
+		for y := 0; y < p.h; y++ {
+			for x := 0; x < p.w; x++ {
+				for c := 0; c < 4; c++ {
+					var window []float64
+					for dy := -r; dy <= r; dy++ {
+						for dx := -r; dx <= r; dx++ {
+							sx, sy := edgeMirror.index(x+dx, p.w), edgeMirror.index(y+dy, p.h)
+							window = append(window, float64(p.pix[(sy*p.w+sx)*4+c]))
+						}
+					}
+					sort.Float64s(window)
+					want := window[len(window)/2]
+					if got := float64(out.pix[(y*p.w+x)*4+c]); got != want {
+						t.Fatalf("r=%d (%d,%d) канал %d: %g, ожидается %g", r, x, y, c, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// stepPlane - левая половина черная, правая белая, плюс шум амплитуды noise
+func stepPlane(w, h int, noise float32) *plane {
+	rnd := rand.New(rand.NewSource(7))
+	p := &plane{w, h, make([]float32, w*h*4)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := float32(0.2 * 65535)
+			if x >= w/2 {
+				v = 0.8 * 65535
+			}
+			i := (y*w + x) * 4
+			for c := 0; c < 3; c++ {
+				p.pix[i+c] = clampF32(v+(rnd.Float32()*2-1)*noise, 0, 65535)
+			}
+			p.pix[i+3] = 65535
+		}
+	}
+	return p
+}
+
+// deviation - среднее отклонение от чистого перехода по всем пикселям и
+// перепад на границе ступеньки
+func deviation(p *plane) (noise, edge float64) {
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			v := 0.2 * 65535
+			if x >= p.w/2 {
+				v = 0.8 * 65535
+			}
+			noise += math.Abs(float64(p.pix[(y*p.w+x)*4]) - v)
+		}
+	}
+	mid := p.h / 2 * p.w
+	edge = float64(p.pix[(mid+p.w/2)*4] - p.pix[(mid+p.w/2-1)*4])
+	return noise / float64(p.w*p.h), edge
+}
+
+// Шумоподавление уменьшает шум и сохраняет резкий край
+func TestDenoiseFilters(t *testing.T) {
+	src := stepPlane(32, 24, 0.1*65535)
+	srcNoise, _ := deviation(src)
+	tests := []struct {
+		name string
+		out  *plane
+	}{
+		{"median", medianFilter(src, 2)},
+		{"bilateral", bilateralFilter(src, 2, 30)},
+		{"nlmeans", nlMeans(src, 20, 3, 1)},
+	}
+	for _, tt := range tests {
+		noise, edge := deviation(tt.out)
+		if noise > srcNoise*0.7 {
+			t.Errorf("%s: шум %.0f, у исходника %.0f", tt.name, noise, srcNoise)
+		}
+		// исходный перепад 0.6, размытие окном 5 px оставило бы около 0.12
+		if edge < 0.3*65535 {
+			t.Errorf("%s: перепад на краю %.0f, край размыт", tt.name, edge)
+		}
+	}
+}
+
+func TestDenoiseWorkLimit(t *testing.T) {
+	tests := []struct {
+		id     string
+		params map[string]interface{}
+	}{
+		{"median", map[string]interface{}{"radius": 15.0}},
+		{"bilateral", map[string]interface{}{"sigma": 10.0}},
+		{"nlmeans", map[string]interface{}{"search": 10.0}},
+	}
+	for _, tt := range tests {
+		limited, ok := lookupFilter(tt.id).(workLimited)
+		if !ok {
+			t.Errorf("%s: нет ограничения объема работы", tt.id)
+			continue
+		}
+		values, err := filterParams(tt.id, tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := limited.CheckWork(8000, 8000, values); err == nil {
+			t.Errorf("%s: 8000×8000 с наибольшим радиусом пропущено", tt.id)
+		}
+		if err := limited.CheckWork(200, 200, values); err != nil {
+			t.Errorf("%s: 200×200: %v", tt.id, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Глубина цвета: изображения с 16 битами на канал (16-битные PNG и TIFF)
+// обрабатываются в RGBA64/NRGBA64, остальные - в 8-битных типах.
+
+// isDeep - 16 бит на канал
+func isDeep(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// newCanvas - пустой холст для результата операции над src с той же глубиной цвета
+func newCanvas(src image.Image, r image.Rectangle) draw.Image {
+	if isDeep(src) {
+		return image.NewRGBA64(r)
+	}
+	return image.NewRGBA(r)
+}
+
+// toPremul - предумноженные значения RGBA (0..65535, по 4 на пиксель)
+// построчно, начиная с левого верхнего угла
+func toPremul(img image.Image) []float32 {
+	b := img.Bounds()
+	pix := make([]float32, b.Dx()*b.Dy()*4)
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = float32(r), float32(g), float32(bl), float32(a)
+			i += 4
+		}
+	}
+	return pix
+}
+
+// fromPremul - изображение w×h из предумноженных значений RGBA (0..65535,
+// по 4 на пиксель). deep - сохранить 16 бит на канал.
+func fromPremul(pix []float32, w, h int, deep bool) image.Image {
+	if deep {
+		dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+		for i, v := range pix[:w*h*4] {
+			v16 := uint16(v + 0.5)
+			dst.Pix[i*2], dst.Pix[i*2+1] = uint8(v16>>8), uint8(v16)
+		}
+		return dst
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, v := range pix[:w*h*4] {
+		dst.Pix[i] = uint8(v/257 + 0.5)
+	}
+	return dst
+}
+
+// pixelFunc - преобразование цвета пикселя: компоненты без предумножения, 0..1
+type pixelFunc func(r, g, b float32) (float32, float32, float32)
+
+// mapPixels - попиксельное преобразование цвета. f получает и возвращает
+// компоненты без предумножения на альфу в диапазоне 0..1 (выход за него
+// обрезается); альфа не меняется. Точность исходника сохраняется: 16-битные
+// изображения дают NRGBA64, остальные - NRGBA.
+func mapPixels(img image.Image, f pixelFunc) image.Image {
+	return mapPixelsAt(img, func(_, _ int, r, g, b float32) (float32, float32, float32) {
+		return f(r, g, b)
+	})
+}
+
+// mapPixelsAt - как mapPixels, но преобразование получает и координаты пикселя
+func mapPixelsAt(img image.Image, f func(x, y int, r, g, b float32) (float32, float32, float32)) image.Image {
+	b := img.Bounds()
+	deep := isDeep(img)
+
+	var dst8 *image.NRGBA
+	var dst16 *image.NRGBA64
+	if deep {
+		dst16 = image.NewNRGBA64(b)
+	} else {
+		dst8 = image.NewNRGBA(b)
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := straightAt(img, x, y)
+			r, g, bl := f(x, y, float32(c.R)/65535, float32(c.G)/65535, float32(c.B)/65535)
+
+			if deep {
+				p := dst16.Pix[dst16.PixOffset(x, y):]
+				for i, v := range [4]uint16{unitTo16(r), unitTo16(g), unitTo16(bl), c.A} {
+					p[i*2], p[i*2+1] = uint8(v>>8), uint8(v)
+				}
+				continue
+			}
+			p := dst8.Pix[dst8.PixOffset(x, y):]
+			p[0], p[1], p[2], p[3] = unitTo8(r), unitTo8(g), unitTo8(bl), uint8(c.A>>8)
+		}
+	}
+
+	if deep {
+		return dst16
+	}
+	return dst8
+}
+
+// straightAt - цвет пикселя без предумножения на альфу. У NRGBA и NRGBA64
+// значения берутся как есть: обратное деление после RGBA() теряет точность
+// у полупрозрачных пикселей.
+func straightAt(img image.Image, x, y int) color.NRGBA64 {
+	switch src := img.(type) {
+	case *image.NRGBA:
+		c := src.NRGBAAt(x, y)
+		return color.NRGBA64{uint16(c.R) * 0x101, uint16(c.G) * 0x101, uint16(c.B) * 0x101, uint16(c.A) * 0x101}
+	case *image.NRGBA64:
+		return src.NRGBA64At(x, y)
+	}
+	return color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+}
+
+// flattenAlpha - наложение изображения на сплошной фон (для форматов без
+// прозрачности). Полупрозрачный фон сначала накладывается на белый.
+func flattenAlpha(img image.Image, bg color.NRGBA) image.Image {
+	if !hasAlpha(img) {
+		return img
+	}
+	if bg.A != 0xff {
+		a := uint32(bg.A)
+		blend := func(v uint8) uint8 { return uint8((uint32(v)*a + 0xff*(0xff-a) + 0x7f) / 0xff) }
+		bg = color.NRGBA{blend(bg.R), blend(bg.G), blend(bg.B), 0xff}
+	}
+
+	b := img.Bounds()
+	dst := newCanvas(img, b)
+	draw.Draw(dst, b, image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
+
+// unitTo8, unitTo16 - значение 0..1 в целый канал с округлением
+func unitTo8(v float32) uint8 {
+	return uint8(clampF32(v, 0, 1)*255 + 0.5)
+}
+
+func unitTo16(v float32) uint16 {
+	return uint16(clampF32(v, 0, 1)*65535 + 0.5)
+}
+
+// round16to8 - 16-битный канал в 8 бит с округлением
+func round16to8(v uint16) uint8 {
+	return uint8((uint32(v)*255 + 32767) / 65535)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// deepImage - 16-битный градиент, у которого младшие 8 бит не нулевые
+func deepImage(w, h int) *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA64(x, y, color.NRGBA64{uint16(1000 + 37*x), uint16(20000 + 11*y), 0x1234, 0xffff})
+		}
+	}
+	return img
+}
+
+func TestRound16to8(t *testing.T) {
+	for v, want := range map[uint16]uint8{0: 0, 128: 0, 129: 1, 0x8080: 128, 0xfeff: 254, 0xff00: 254, 0xff80: 255, 0xffff: 255} {
+		if got := round16to8(v); got != want {
+			t.Errorf("round16to8(%#x) = %d, ожидается %d", v, got, want)
+		}
+	}
+}
+
+func TestMapPixelsDepth(t *testing.T) {
+	identity := func(r, g, b float32) (float32, float32, float32) { return r, g, b }
+
+	deep := deepImage(5, 3)
+	out, ok := mapPixels(deep, identity).(*image.NRGBA64)
+	if !ok {
+		t.Fatalf("16 бит: результат %T", mapPixels(deep, identity))
+	}
+	if !bytes.Equal(out.Pix, deep.Pix) {
+		t.Error("16 бит: тождественное преобразование изменило пиксели")
+	}
+
+	// полупрозрачный 8-битный пиксель не теряет точность цвета
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{201, 77, 3, 10})
+	if c := mapPixels(src, identity).(*image.NRGBA).NRGBAAt(0, 0); c != src.NRGBAAt(0, 0) {
+		t.Errorf("8 бит: %v, ожидается %v", c, src.NRGBAAt(0, 0))
+	}
+}
+
+// Шаги конвейера сохраняют 16 бит, и результат записывается 16-битным PNG
+func TestPipelineKeepsDepth(t *testing.T) {
+	ops := []string{
+		`{"op":"resize","width":8}`,
+		`{"op":"crop","width":4,"height":3}`,
+		`{"op":"rotate","angle":30}`,
+		`{"op":"flip","direction":"both"}`,
+		`{"op":"filter","name":"brightness"}`,
+		`{"op":"filter","name":"gaussian"}`,
+		`{"op":"convolve","kernel":[[0,-1,0],[-1,5,-1],[0,-1,0]]}`,
+		`{"op":"levels","rgb":{"black":10}}`,
+		`{"op":"curves","rgb":[[0,0],[128,140],[255,255]]}`,
+	}
+	for _, op := range ops {
+		p, err := parsePipeline("["+op+"]", defaultOptions(t))
+		if err != nil {
+			t.Errorf("%s: %v", op, err)
+			continue
+		}
+		out, err := p.run(deepImage(12, 9), newRunContext())
+		if err != nil {
+			t.Errorf("%s: %v", op, err)
+			continue
+		}
+		if !isDeep(out) {
+			t.Errorf("%s: результат %T, ожидается 16 бит", op, out)
+		}
+	}
+
+	data, err := encodeImage(deepImage(4, 4), "png", 0, color.NRGBA{255, 255, 255, 255})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.NRGBA64Model.Convert(img.At(3, 2)).(color.NRGBA64); c.R != 1000+37*3 || c.B != 0x1234 {
+		t.Errorf("после записи PNG %T: %v", img, c)
+	}
+}
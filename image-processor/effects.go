@@ -0,0 +1,194 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// Стилизация: виньетка, пикселизация, зерно, дуотон, тонирование и карта
+// градиента (операция "gradientmap")
+
+// maxGradientColors - наибольшее число цветов карты градиента
+const maxGradientColors = 16
+
+// Эффекты стилизации
+func init() {
+	registerFilter(newFilter("vignette", "Виньетка", "🔘",
+		[]filterParam{
+			numberParam("strength", "Сила, %", 0, 100, 1, 60),
+			numberParam("radius", "Радиус, %", 0, 100, 1, 50),
+			colorParam("color", "Цвет", 0x000000),
+		},
+		func(img image.Image, p map[string]float64) image.Image {
+			return vignette(img, p["strength"]/100, p["radius"]/100, p["color"])
+		}))
+	registerFilter(newFilter("pixelate", "Пикселизация", "👾",
+		[]filterParam{numberParam("size", "Размер блока, px", 2, 200, 1, 10)},
+		func(img image.Image, p map[string]float64) image.Image {
+			return pixelate(newPlane(img), int(p["size"])).image(isDeep(img))
+		}))
+	registerFilter(newFilter("grain", "Зерно пленки", "🌫️",
+		[]filterParam{
+			numberParam("amount", "Сила, %", 0, 100, 1, 30),
+			numberParam("size", "Размер зерна, px", 1, 8, 0.5, 1),
+			numberParam("seed", "Зерно случайности", 0, 1e6, 1, 0),
+		},
+		func(img image.Image, p map[string]float64) image.Image {
+			return filmGrain(img, p["amount"]/100, p["size"], uint64(p["seed"]))
+		}))
+	registerFilter(colorFilter("duotone", "Дуотон", "🌓",
+		[]filterParam{
+			colorParam("shadows", "Тени", 0x1b1f5e),
+			colorParam("highlights", "Света", 0xffd9a0),
+		},
+		func(p map[string]float64) pixelFunc {
+			return gradientMap([]float64{p["shadows"], p["highlights"]})
+		}))
+	registerFilter(colorFilter("tint", "Тонирование", "🫖",
+		[]filterParam{
+			colorParam("color", "Цвет", 0xff9a3c),
+			numberParam("amount", "Сила, %", 0, 100, 1, 30),
+		},
+		func(p map[string]float64) pixelFunc {
+			return tint(p["color"], float32(p["amount"]/100))
+		}))
+}
+
+// vignette - затемнение (или окрашивание в color) к краям. radius - доля
+// полудиагонали, с которой начинается эффект; к углам он плавно доходит
+// до strength. Эллипс повторяет пропорции кадра.
+func vignette(img image.Image, strength, radius, color float64) image.Image {
+	b := img.Bounds()
+	cx, cy := float64(b.Min.X+b.Max.X)/2, float64(b.Min.Y+b.Max.Y)/2
+	hw, hh := float64(b.Dx())/2, float64(b.Dy())/2
+	vr, vg, vb := unpackColor(color)
+
+	return mapPixelsAt(img, func(x, y int, r, g, bl float32) (float32, float32, float32) {
+		dx, dy := (float64(x)+0.5-cx)/hw, (float64(y)+0.5-cy)/hh
+		d := math.Sqrt((dx*dx + dy*dy) / 2) // 1 - в углах
+		w := float32(strength * smoothstep(radius, 1, d))
+		return r + (vr-r)*w, g + (vg-g)*w, bl + (vb-bl)*w
+	})
+}
+
+// smoothstep - плавный переход от 0 (v <= lo) к 1 (v >= hi)
+func smoothstep(lo, hi, v float64) float64 {
+	if v <= lo {
+		return 0
+	}
+	if v >= hi {
+		return 1
+	}
+	t := (v - lo) / (hi - lo)
+	return t * t * (3 - 2*t)
+}
+
+// pixelate - замена блоков size×size их средним цветом (с учетом прозрачности)
+func pixelate(p *plane, size int) *plane {
+	for by := 0; by < p.h; by += size {
+		for bx := 0; bx < p.w; bx += size {
+			x1, y1 := min(bx+size, p.w), min(by+size, p.h)
+			var s [4]float32
+			for y := by; y < y1; y++ {
+				for x := bx; x < x1; x++ {
+					px := p.pix[(y*p.w+x)*4:]
+					s[0] += px[0]
+					s[1] += px[1]
+					s[2] += px[2]
+					s[3] += px[3]
+				}
+			}
+			n := float32((x1 - bx) * (y1 - by))
+			for c := range s {
+				s[c] /= n
+			}
+			for y := by; y < y1; y++ {
+				for x := bx; x < x1; x++ {
+					copy(p.pix[(y*p.w+x)*4:], s[:])
+				}
+			}
+		}
+	}
+	return p
+}
+
+// filmGrain - яркостный шум, сильнее в средних тонах. Шум задается в узлах
+// сетки с шагом size и интерполируется между ними; значение в узле зависит
+// только от его координат и seed, так что результат воспроизводим.
+func filmGrain(img image.Image, amount, size float64, seed uint64) image.Image {
+	b := img.Bounds()
+	sigma := float32(amount * 0.2)
+	seed = splitmix64(seed)
+
+	return mapPixelsAt(img, func(x, y int, r, g, bl float32) (float32, float32, float32) {
+		fx, fy := float64(x-b.Min.X)/size, float64(y-b.Min.Y)/size
+		ix, iy := int(math.Floor(fx)), int(math.Floor(fy))
+		tx, ty := float32(fx-float64(ix)), float32(fy-float64(iy))
+		n := (grainNoise(ix, iy, seed)*(1-tx)+grainNoise(ix+1, iy, seed)*tx)*(1-ty) +
+			(grainNoise(ix, iy+1, seed)*(1-tx)+grainNoise(ix+1, iy+1, seed)*tx)*ty
+
+		l := lumaR*r + lumaG*g + lumaB*bl
+		d := n * sigma * (0.25 + 3*l*(1-l)) // в тенях и светах зерно слабее
+		return r + d, g + d, bl + d
+	})
+}
+
+// grainNoise - нормально распределенное (примерно) значение шума в узле сетки
+func grainNoise(x, y int, seed uint64) float32 {
+	h := splitmix64(uint64(uint32(x)) | uint64(uint32(y))<<32 ^ seed)
+	// сумма четырех равномерных величин, приведенная к дисперсии 1
+	var s float32
+	for i := 0; i < 4; i++ {
+		s += float32(h>>(16*i)&0xffff) / 0xffff
+	}
+	return (s - 2) * 1.7320508 // дисперсия суммы - 1/3
+}
+
+// splitmix64 - перемешивание битов (хеш для детерминированного шума)
+func splitmix64(v uint64) uint64 {
+	v += 0x9e3779b97f4a7c15
+	v = (v ^ v>>30) * 0xbf58476d1ce4e5b9
+	v = (v ^ v>>27) * 0x94d049bb133111eb
+	return v ^ v>>31
+}
+
+// gradientMap - замена цвета по яркости на градиент через равноотстоящие
+// цвета colors (0xRRGGBB): темные тона - первый цвет, светлые - последний
+func gradientMap(colors []float64) pixelFunc {
+	stops := make([][3]float32, len(colors))
+	for i, c := range colors {
+		stops[i][0], stops[i][1], stops[i][2] = unpackColor(c)
+	}
+	last := float32(len(stops) - 1)
+
+	return func(r, g, b float32) (float32, float32, float32) {
+		pos := clampF32(lumaR*r+lumaG*g+lumaB*b, 0, 1) * last
+		i := min(int(pos), len(stops)-2)
+		t := pos - float32(i)
+		a, c := stops[i], stops[i+1]
+		return a[0] + (c[0]-a[0])*t, a[1] + (c[1]-a[1])*t, a[2] + (c[2]-a[2])*t
+	}
+}
+
+// tint - тонирование: цвет умножается на color с сохранением яркости и
+// смешивается с исходным в доле amount
+func tint(color float64, amount float32) pixelFunc {
+	tr, tg, tb := unpackColor(color)
+	return func(r, g, b float32) (float32, float32, float32) {
+		l := lumaR*r + lumaG*g + lumaB*b
+		mr, mg, mb := r*tr, g*tg, b*tb
+		d := l - (lumaR*mr + lumaG*mg + lumaB*mb)
+		mr, mg, mb = mr+d, mg+d, mb+d
+		return r + (mr-r)*amount, g + (mg-g)*amount, b + (mb-b)*amount
+	}
+}
+
+// gradientMapOperation - описание операции "gradientmap" для /api/filters
+func gradientMapOperation() map[string]interface{} {
+	return map[string]interface{}{
+		"op":   "gradientmap",
+		"name": "Карта градиента",
+		// colors - массив цветов от теней к светам
+		"colors": map[string]interface{}{"min_count": 2, "max_count": maxGradientColors},
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestVignette(t *testing.T) {
+	gray := color.NRGBA{200, 200, 200, 255}
+	src := flatImage(40, 30, gray)
+	tests := []struct {
+		name     string
+		strength float64
+		color    float64
+		corner   color.NRGBA
+	}{
+		{"off", 0, 0x000000, gray},
+		{"black", 1, 0x000000, color.NRGBA{0, 0, 0, 255}},
+		{"white", 1, 0xffffff, color.NRGBA{255, 255, 255, 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := vignette(src, tt.strength, 0.5, tt.color)
+			center := color.NRGBAModel.Convert(out.At(20, 15)).(color.NRGBA)
+			if !closeColor(center, gray) {
+				t.Errorf("центр %v, ожидается %v", center, gray)
+			}
+			// угловой пиксель не совпадает с вершиной эллипса - допуск шире
+			corner := color.NRGBAModel.Convert(out.At(0, 0)).(color.NRGBA)
+			if absDiff(corner.R, tt.corner.R) > 8 || absDiff(corner.B, tt.corner.B) > 8 {
+				t.Errorf("угол %v, ожидается около %v", corner, tt.corner)
+			}
+		})
+	}
+}
+
+func TestPixelate(t *testing.T) {
+	src := photoImage(10, 7)
+	for _, size := range []int{2, 3, 4, 20} {
+		img := pixelate(newPlane(src), size).image(false)
+		if img.Bounds().Size() != src.Bounds().Size() {
+			t.Fatalf("size %d: размер %v", size, img.Bounds())
+		}
+		for y := 0; y < 7; y++ {
+			for x := 0; x < 10; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				first := color.NRGBAModel.Convert(img.At(x/size*size, y/size*size)).(color.NRGBA)
+				if !closeColor(c, first) {
+					t.Fatalf("size %d: блок неоднороден в (%d,%d): %v и %v", size, x, y, c, first)
+				}
+			}
+		}
+	}
+}
+
+func TestFilmGrain(t *testing.T) {
+	src := flatImage(16, 16, color.NRGBA{128, 128, 128, 255})
+	same := func(a, b image.Image) bool {
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				if color.NRGBAModel.Convert(a.At(x, y)) != color.NRGBAModel.Convert(b.At(x, y)) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	if !same(filmGrain(src, 0, 1, 7), src) {
+		t.Error("amount 0 меняет изображение")
+	}
+	a, b := filmGrain(src, 0.5, 1, 7), filmGrain(src, 0.5, 1, 7)
+	if !same(a, b) {
+		t.Error("одинаковый seed дает разный результат")
+	}
+	if same(a, src) {
+		t.Error("зерно не добавлено")
+	}
+	if same(a, filmGrain(src, 0.5, 1, 8)) {
+		t.Error("разный seed дает одинаковый результат")
+	}
+}
+
+func TestColorEffects(t *testing.T) {
+	black := color.NRGBA{0, 0, 0, 255}
+	white := color.NRGBA{255, 255, 255, 255}
+	gray := color.NRGBA{128, 128, 128, 255}
+	orange := color.NRGBA{200, 100, 50, 255}
+	tests := []struct {
+		id     string
+		params map[string]interface{}
+		in     color.NRGBA
+		want   color.NRGBA
+	}{
+		{"duotone", map[string]interface{}{}, black, color.NRGBA{0x1b, 0x1f, 0x5e, 255}},
+		{"duotone", map[string]interface{}{}, white, color.NRGBA{0xff, 0xd9, 0xa0, 255}},
+		{"duotone", map[string]interface{}{"shadows": "#000000", "highlights": "#ffffff"}, orange, color.NRGBA{124, 124, 124, 255}},
+		{"tint", map[string]interface{}{"amount": 0.0}, orange, orange},
+		{"tint", map[string]interface{}{"color": "#ffffff", "amount": 100.0}, orange, orange},
+		{"tint", map[string]interface{}{"color": "#ff0000", "amount": 100.0}, gray, color.NRGBA{218, 90, 90, 255}},
+	}
+	for _, tt := range tests {
+		if got := filterPixel(t, tt.id, tt.params, tt.in); !closeColor(got, tt.want) {
+			t.Errorf("%s %v (%v): %v, ожидается %v", tt.id, tt.params, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGradientMapStep(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    string
+		wantErr string
+	}{
+		{"two colors", `{"op":"gradientmap","colors":["black","white"]}`, ""},
+		{"three colors", `{"op":"gradientmap","colors":["#102030","red","#ffe080"],"intensity":50}`, ""},
+		{"one color", `{"op":"gradientmap","colors":["black"]}`, "от 2 до"},
+		{"too many", `{"op":"gradientmap","colors":[` + strings.Repeat(`"red",`, maxGradientColors) + `"red"]}`, "от 2 до"},
+		{"bad color", `{"op":"gradientmap","colors":["black","nope"]}`, "colors[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePipeline("["+tt.step+"]", defaultOptions(t))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parsePipeline: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидается с %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// края градиента: черный - первый цвет, белый - последний
+	p, err := parsePipeline(`[{"op":"gradientmap","colors":["#102030","red","#ffe080"]}]`, defaultOptions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 255})
+	out, err := p.run(src, newRunContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []color.NRGBA{{0x10, 0x20, 0x30, 255}, {0xff, 0xe0, 0x80, 255}} {
+		if got := color.NRGBAModel.Convert(out.At(x, 0)).(color.NRGBA); !closeColor(got, want) {
+			t.Errorf("пиксель %d: %v, ожидается %v", x, got, want)
+		}
+	}
+}
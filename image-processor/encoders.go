@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// outputFormat - формат результата обработки
+type outputFormat struct {
+	Name        string // каноническое имя, оно же расширение файла
+	Title       string
+	ContentType string
+	Alpha       bool // сохраняет ли формат прозрачность
+	Encode      func(w io.Writer, img image.Image, quality int) error
+}
+
+// outputFormats - поддерживаемые форматы вывода в порядке показа
+var outputFormats = []*outputFormat{
+	{"jpg", "JPEG", "image/jpeg", false, func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}},
+	{"png", "PNG", "image/png", true, func(w io.Writer, img image.Image, _ int) error {
+		// *image.Paletted пишется как PNG с палитрой (1, 2, 4 или 8 бит)
+		return png.Encode(w, img)
+	}},
+	{"gif", "GIF", "image/gif", true, func(w io.Writer, img image.Image, _ int) error {
+		// изображение после операции quantize уже в палитре
+		p, ok := img.(*image.Paletted)
+		if !ok {
+			p = palettize(img, 256)
+		}
+		return gif.Encode(w, p, nil)
+	}},
+	{"bmp", "BMP", "image/bmp", false, func(w io.Writer, img image.Image, _ int) error {
+		// альфу 32-битного BMP с коротким заголовком читатели (и x/image/bmp)
+		// игнорируют, поэтому прозрачность накладывается на фон
+		return bmp.Encode(w, img)
+	}},
+	{"tiff", "TIFF", "image/tiff", true, func(w io.Writer, img image.Image, _ int) error {
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	}},
+	{"webp", "WebP", "image/webp", true, func(w io.Writer, img image.Image, _ int) error {
+		return encodeWebP(w, img)
+	}},
+	{"qoi", "QOI", "image/qoi", true, func(w io.Writer, img image.Image, _ int) error {
+		return encodeQOI(w, img)
+	}},
+}
+
+// outputAliases - другие написания имен форматов
+var outputAliases = map[string]string{"jpeg": "jpg", "tif": "tiff"}
+
+// lookupOutputFormat - формат вывода по имени из запроса
+func lookupOutputFormat(name string) (*outputFormat, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := outputAliases[name]; ok {
+		name = alias
+	}
+	for _, f := range outputFormats {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+
+	names := make([]string, len(outputFormats))
+	for i, f := range outputFormats {
+		names[i] = f.Name
+	}
+	return nil, fmt.Errorf("неизвестный формат вывода %q. Допустимые: %s", name, strings.Join(names, ", "))
+}
+
+// toNRGBA - копия изображения в NRGBA с началом координат в нуле
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
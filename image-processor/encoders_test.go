@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// alphaImage - градиент с полупрозрачной и полностью прозрачной областями
+func alphaImage(w, h int) *image.NRGBA {
+	img := testImage(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w/2; x++ {
+			c := img.NRGBAAt(x, y)
+			c.A = uint8(x * 255 / max(w/2, 1))
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// decodeQOI - чтение QOI для проверки кодировщика (в сервере не нужно)
+func decodeQOI(data []byte) (*image.NRGBA, error) {
+	if len(data) < 22 || string(data[:4]) != "qoif" {
+		return nil, errors.New("не QOI")
+	}
+	w, h := int(binary.BigEndian.Uint32(data[4:])), int(binary.BigEndian.Uint32(data[8:]))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	pos, run := 14, 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			if pos >= len(data)-8 {
+				return nil, errors.New("данные обрываются")
+			}
+			b := data[pos]
+			pos++
+			switch {
+			case b == qoiOpRGB:
+				copy(px[:3], data[pos:pos+3])
+				pos += 3
+			case b == qoiOpRGBA:
+				copy(px[:], data[pos:pos+4])
+				pos += 4
+			case b&0xc0 == qoiOpIndex:
+				px = index[b]
+			case b&0xc0 == qoiOpDiff:
+				px[0] += (b>>4)&3 - 2
+				px[1] += (b>>2)&3 - 2
+				px[2] += b&3 - 2
+			case b&0xc0 == qoiOpLuma:
+				dg := b&0x3f - 32
+				b2 := data[pos]
+				pos++
+				px[0] += dg - 8 + b2>>4
+				px[1] += dg
+				px[2] += dg - 8 + b2&0x0f
+			case b&0xc0 == qoiOpRun:
+				run = int(b & 0x3f)
+			}
+			index[(int(px[0])*3+int(px[1])*5+int(px[2])*7+int(px[3])*11)%64] = px
+		}
+		copy(img.Pix[i:], px[:])
+	}
+	if !bytes.Equal(data[pos:], []byte{0, 0, 0, 0, 0, 0, 0, 1}) {
+		return nil, errors.New("нет завершающих байтов")
+	}
+	return img, nil
+}
+
+func TestLookupOutputFormat(t *testing.T) {
+	for name, want := range map[string]string{
+		"jpg": "jpg", "JPEG": "jpg", " tif ": "tiff", "webp": "webp", "qoi": "qoi", "avif": "",
+	} {
+		f, err := lookupOutputFormat(name)
+		if want == "" {
+			if err == nil || !strings.Contains(err.Error(), "qoi") {
+				t.Errorf("%q: ошибка %v, ожидается список форматов", name, err)
+			}
+			continue
+		}
+		if err != nil || f.Name != want {
+			t.Errorf("%q: %v, %v, ожидается %s", name, f, err, want)
+		}
+	}
+}
+
+// Форматы без потерь возвращают те же пиксели: с прозрачностью, а
+// форматы без нее - наложенными на фон
+func TestEncodersRoundTrip(t *testing.T) {
+	src := alphaImage(13, 7)
+	background := color.NRGBA{10, 20, 30, 255}
+	for _, f := range outputFormats {
+		t.Run(f.Name, func(t *testing.T) {
+			data, err := encodeImage(src, f.Name, 90, background)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var img image.Image
+			if f.Name == "qoi" {
+				img, err = decodeQOI(data)
+			} else {
+				var format string
+				img, format, err = decodeImage(data, 0, false)
+				if err == nil && format != f.Name && outputAliases[format] != f.Name {
+					t.Errorf("прочитан как %s", format)
+				}
+			}
+			if err != nil {
+				t.Fatalf("чтение: %v", err)
+			}
+			if img.Bounds().Size() != src.Bounds().Size() {
+				t.Fatalf("размер %v", img.Bounds())
+			}
+			if f.Name == "jpg" || f.Name == "gif" {
+				return // с потерями
+			}
+
+			var want image.Image = src
+			if !f.Alpha {
+				want = flattenAlpha(src, background)
+			}
+			for y := 0; y < 7; y++ {
+				for x := 0; x < 13; x++ {
+					w := color.NRGBAModel.Convert(want.At(x, y))
+					if got := color.NRGBAModel.Convert(img.At(x, y)); got != w {
+						t.Fatalf("(%d,%d): %v, ожидается %v", x, y, got, w)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Изображение после quantize пишется в GIF и PNG с той же палитрой
+func TestEncodePaletted(t *testing.T) {
+	palette := color.Palette{color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}}
+	src := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+	src.Pix[5] = 1
+	for _, name := range []string{"gif", "png"} {
+		f, _ := lookupOutputFormat(name)
+		var buf bytes.Buffer
+		if err := f.Encode(&buf, src, 0); err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := decodeImage(buf.Bytes(), 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, ok := img.(*image.Paletted)
+		if !ok || len(p.Palette) != 2 || p.Pix[5] != 1 {
+			t.Errorf("%s: прочитан %T, палитра %d цветов", name, img, len(p.Palette))
+		}
+	}
+}
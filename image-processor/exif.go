@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"sort"
+)
+
+// Теги EXIF
+const (
+	exifTagOrientation = 0x0112
+	exifTagArtist      = 0x013b
+	exifTagCopyright   = 0x8298
+	exifTagExifIFD     = 0x8769 // указатель на Exif IFD
+	exifTagGPSIFD      = 0x8825 // указатель на GPS IFD
+	exifTagInteropIFD  = 0xa005 // указатель на Interoperability IFD (внутри Exif IFD)
+	exifTagColorSpace  = 0xa001
+	exifTagPixelX      = 0xa002
+	exifTagPixelY      = 0xa003
+	exifTagMakerNote   = 0x927c
+	tiffTagXMP         = 0x02bc
+	tiffTagIPTC        = 0x83bb
+	tiffTagICC         = 0x8773
+)
+
+// exifTypeSizes - размер одного значения для типов TIFF (1 BYTE ... 13 IFD)
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// exifMaxDepth - предел вложенности IFD (защита от зацикленных файлов)
+const exifMaxDepth = 4
+
+// exifHeader - начало сегмента APP1 с EXIF в JPEG
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegSegment - маркерный сегмент JPEG до начала сжатых данных (SOS)
+type jpegSegment struct {
+	Marker byte
+	Data   []byte // содержимое без маркера и длины
+}
+
+// jpegSegments - сегменты заголовка JPEG; обход останавливается на SOS
+func jpegSegments(data []byte) []jpegSegment {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	var segs []jpegSegment
+	p := 2
+	for p+4 <= len(data) {
+		if data[p] != 0xff {
+			break
+		}
+		marker := data[p+1]
+		if marker == 0xff {
+			// заполняющие байты перед маркером
+			p++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[p+2:]))
+		if n < 2 || p+2+n > len(data) {
+			break
+		}
+		segs = append(segs, jpegSegment{marker, data[p+4 : p+2+n]})
+		p += 2 + n
+	}
+	return segs
+}
+
+// exifBlock - EXIF в виде TIFF-структуры (как в JPEG после "Exif\0\0").
+// У TIFF метаданные лежат в самом файле, у PNG - в чанке eXIf, у WebP -
+// в чанке EXIF (иногда с тем же префиксом, что в JPEG).
+func exifBlock(data []byte, format string) []byte {
+	switch format {
+	case "jpeg":
+		for _, s := range jpegSegments(data) {
+			if s.Marker == 0xe1 && bytes.HasPrefix(s.Data, exifHeader) {
+				return s.Data[len(exifHeader):]
+			}
+		}
+	case "tiff":
+		return data
+	case "png":
+		for _, c := range pngChunks(data) {
+			if c.Type == "eXIf" {
+				return bytes.TrimPrefix(c.Data, exifHeader)
+			}
+		}
+	case "webp":
+		for _, c := range riffChunks(data) {
+			if c.Type == "EXIF" {
+				return bytes.TrimPrefix(c.Data, exifHeader)
+			}
+		}
+	}
+	return nil
+}
+
+// tiffByteOrder - порядок байтов по заголовку TIFF
+func tiffByteOrder(data []byte) binary.ByteOrder {
+	if len(data) < 8 {
+		return nil
+	}
+	switch string(data[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian
+	case "MM\x00*":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// exifOrientation - значение тега Orientation из первого IFD (1, если его нет)
+func exifOrientation(tiff []byte) int {
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			break
+		}
+		// тип 3 - SHORT, значение хранится в самой записи
+		if order.Uint16(tiff[e:]) == exifTagOrientation && order.Uint16(tiff[e+2:]) == 3 {
+			if v := int(order.Uint16(tiff[e+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+		}
+	}
+	return 1
+}
+
+// applyOrientation - приведение пикселей к нормальной ориентации (Orientation = 1).
+// Значения 2-8: 2 - отражение по горизонтали, 3 - поворот на 180°,
+// 4 - отражение по вертикали, 5 - транспонирование, 6 - поворот на 90° по
+// часовой, 7 - транспонирование относительно побочной диагонали,
+// 8 - поворот на 90° против часовой. Глубина цвета сохраняется.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := newCanvas(img, image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// exifEntry - запись IFD; значение хранится в исходном порядке байтов
+type exifEntry struct {
+	Tag, Type uint16
+	Count     uint32
+	Value     []byte
+	Sub       *exifIFD // вложенный IFD для тегов-указателей
+}
+
+// exifIFD - каталог записей
+type exifIFD struct {
+	Entries []exifEntry
+}
+
+// exifData - разобранный EXIF: первый IFD и вложенные в него (Exif, GPS, Interop).
+// Следующие IFD (миниатюра) не переносятся - это уменьшенная исходная картинка.
+type exifData struct {
+	order binary.ByteOrder
+	ifd0  *exifIFD
+}
+
+// parseExif - разбор TIFF-структуры EXIF; nil, если она повреждена
+func parseExif(tiff []byte) *exifData {
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		return nil
+	}
+	ifd := parseIFD(tiff, order, int(order.Uint32(tiff[4:])), 0)
+	if ifd == nil {
+		return nil
+	}
+	return &exifData{order: order, ifd0: ifd}
+}
+
+func parseIFD(tiff []byte, order binary.ByteOrder, offset, depth int) *exifIFD {
+	if depth > exifMaxDepth || offset < 8 || offset+2 > len(tiff) {
+		return nil
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	ifd := &exifIFD{}
+	for i := 0; i < count; i++ {
+		p := offset + 2 + i*12
+		if p+12 > len(tiff) {
+			break
+		}
+		e := exifEntry{
+			Tag:   order.Uint16(tiff[p:]),
+			Type:  order.Uint16(tiff[p+2:]),
+			Count: order.Uint32(tiff[p+4:]),
+		}
+		size, ok := exifTypeSizes[e.Type]
+		if !ok || int64(e.Count)*int64(size) > int64(len(tiff)) {
+			continue
+		}
+		n := int(e.Count) * size
+		if n <= 4 {
+			e.Value = append([]byte(nil), tiff[p+8:p+8+n]...)
+		} else {
+			off := int(order.Uint32(tiff[p+8:]))
+			if off < 0 || off+n > len(tiff) {
+				continue
+			}
+			e.Value = append([]byte(nil), tiff[off:off+n]...)
+		}
+
+		if isSubIFDTag(e.Tag) {
+			if n != 4 {
+				continue
+			}
+			if e.Sub = parseIFD(tiff, order, int(order.Uint32(e.Value)), depth+1); e.Sub == nil {
+				continue
+			}
+		}
+		ifd.Entries = append(ifd.Entries, e)
+	}
+	return ifd
+}
+
+func isSubIFDTag(tag uint16) bool {
+	return tag == exifTagExifIFD || tag == exifTagGPSIFD || tag == exifTagInteropIFD
+}
+
+// filter - оставляет записи, для которых keep вернула true. parent - тег-указатель
+// каталога (0 для IFD0). Опустевшие вложенные каталоги удаляются.
+func (e *exifData) filter(keep func(parent, tag uint16) bool) {
+	e.ifd0.filter(0, keep)
+}
+
+func (ifd *exifIFD) filter(parent uint16, keep func(parent, tag uint16) bool) {
+	entries := ifd.Entries[:0]
+	for _, en := range ifd.Entries {
+		if !keep(parent, en.Tag) {
+			continue
+		}
+		if en.Sub != nil {
+			if en.Sub.filter(en.Tag, keep); len(en.Sub.Entries) == 0 {
+				continue
+			}
+		}
+		entries = append(entries, en)
+	}
+	ifd.Entries = entries
+}
+
+// empty - не осталось ни одной записи
+func (e *exifData) empty() bool {
+	return len(e.ifd0.Entries) == 0
+}
+
+// setUint - замена числового значения существующего тега в каталоге parent
+func (e *exifData) setUint(parent, tag uint16, v uint32) {
+	var walk func(ifd *exifIFD, id uint16)
+	walk = func(ifd *exifIFD, id uint16) {
+		for i := range ifd.Entries {
+			en := &ifd.Entries[i]
+			if en.Sub != nil {
+				walk(en.Sub, en.Tag)
+				continue
+			}
+			if id != parent || en.Tag != tag {
+				continue
+			}
+			if v <= 0xffff {
+				en.Type, en.Count, en.Value = 3, 1, make([]byte, 2)
+				e.order.PutUint16(en.Value, uint16(v))
+			} else {
+				en.Type, en.Count, en.Value = 4, 1, make([]byte, 4)
+				e.order.PutUint32(en.Value, v)
+			}
+		}
+	}
+	walk(e.ifd0, 0)
+}
+
+// bytes - сборка TIFF-структуры с пересчитанными смещениями
+func (e *exifData) bytes() []byte {
+	buf := make([]byte, 8, 1024)
+	if e.order == binary.LittleEndian {
+		copy(buf, "II*\x00")
+	} else {
+		copy(buf, "MM\x00*")
+	}
+	e.order.PutUint32(buf[4:], 8)
+	return e.writeIFD(buf, e.ifd0)
+}
+
+func (e *exifData) writeIFD(buf []byte, ifd *exifIFD) []byte {
+	entries := ifd.Entries
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+
+	start := len(buf)
+	buf = append(buf, make([]byte, 2+len(entries)*12+4)...)
+	e.order.PutUint16(buf[start:], uint16(len(entries)))
+
+	for i, en := range entries {
+		p := start + 2 + i*12
+		e.order.PutUint16(buf[p:], en.Tag)
+		e.order.PutUint16(buf[p+2:], en.Type)
+		e.order.PutUint32(buf[p+4:], en.Count)
+
+		switch {
+		case en.Sub != nil:
+			buf = padWord(buf)
+			e.order.PutUint32(buf[p+8:], uint32(len(buf)))
+			buf = e.writeIFD(buf, en.Sub)
+		case len(en.Value) <= 4:
+			copy(buf[p+8:p+12], en.Value)
+		default:
+			buf = padWord(buf)
+			e.order.PutUint32(buf[p+8:], uint32(len(buf)))
+			buf = append(buf, en.Value...)
+		}
+	}
+	return buf
+}
+
+// padWord - выравнивание по границе слова, как требует TIFF
+func padWord(buf []byte) []byte {
+	if len(buf)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
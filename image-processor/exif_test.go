@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// exifWithOrientation - EXIF (TIFF II) с единственным тегом Orientation
+func exifWithOrientation(o uint16) []byte {
+	b := []byte("II*\x00")
+	b = binary.LittleEndian.AppendUint32(b, 8)
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, exifTagOrientation)
+	b = binary.LittleEndian.AppendUint16(b, 3) // SHORT
+	b = binary.LittleEndian.AppendUint32(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, o)
+	b = append(b, 0, 0)
+	return binary.LittleEndian.AppendUint32(b, 0)
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// красный пиксель в левом верхнем углу изображения 3×2
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	red := color.NRGBA{255, 0, 0, 255}
+	src.SetNRGBA(0, 0, red)
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		red         image.Point // куда попадает левый верхний угол
+	}{
+		{1, image.Pt(3, 2), image.Pt(0, 0)},
+		{2, image.Pt(3, 2), image.Pt(2, 0)},
+		{3, image.Pt(3, 2), image.Pt(2, 1)},
+		{4, image.Pt(3, 2), image.Pt(0, 1)},
+		{5, image.Pt(2, 3), image.Pt(0, 0)},
+		{6, image.Pt(2, 3), image.Pt(1, 0)},
+		{7, image.Pt(2, 3), image.Pt(1, 2)},
+		{8, image.Pt(2, 3), image.Pt(0, 2)},
+		{9, image.Pt(3, 2), image.Pt(0, 0)}, // неверное значение - без изменений
+	}
+	for _, tt := range tests {
+		out := applyOrientation(src, tt.orientation)
+		if got := out.Bounds().Size(); got != tt.size {
+			t.Errorf("orientation %d: размер %v, ожидается %v", tt.orientation, got, tt.size)
+			continue
+		}
+		if c := color.NRGBAModel.Convert(out.At(tt.red.X, tt.red.Y)); c != red {
+			t.Errorf("orientation %d: в %v цвет %v, ожидается красный", tt.orientation, tt.red, c)
+		}
+	}
+}
+
+func TestDecodeImageOrientation(t *testing.T) {
+	exif := exifWithOrientation(6)
+	for _, format := range []string{"jpg", "png", "webp"} {
+		t.Run(format, func(t *testing.T) {
+			out, err := lookupOutputFormat(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := out.Encode(&buf, testImage(3, 2), 90); err != nil {
+				t.Fatal(err)
+			}
+			data := embedMetadata(buf.Bytes(), format, &imageMeta{EXIF: exif})
+			src, _ := detectFormat(bytes.NewReader(data))
+			if got := exifOrientation(exifBlock(data, src)); got != 6 {
+				t.Fatalf("Orientation в файле %d, ожидается 6", got)
+			}
+
+			img, _, err := decodeImage(data, 0, true)
+			if err != nil {
+				t.Fatalf("decodeImage: %v", err)
+			}
+			if got := img.Bounds().Size(); got != image.Pt(2, 3) {
+				t.Errorf("с autorient размер %v, ожидается 2x3", got)
+			}
+			img, _, _ = decodeImage(data, 0, false)
+			if got := img.Bounds().Size(); got != image.Pt(3, 2) {
+				t.Errorf("без autorient размер %v, ожидается 3x2", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Filter - фильтр изображения. Фильтры регистрируются в init через
+// registerFilter; список в /api/filters и шаг "filter" конвейера строятся
+// по реестру, так что новый фильтр - это отдельный файл с init.
+type Filter interface {
+	ID() string            // идентификатор в запросах
+	Name() string          // название для интерфейса
+	Icon() string          // эмодзи для кнопки
+	Params() []filterParam // собственные параметры (сила эффекта добавляется всем)
+	// Apply - фильтр в полную силу; params проверены и дополнены умолчаниями
+	Apply(img image.Image, params map[string]float64) image.Image
+}
+
+// workLimited - фильтр с ограничением объема работы: CheckWork вызывается
+// перед Apply и отказывает для слишком больших изображений или параметров
+type workLimited interface {
+	CheckWork(w, h int, params map[string]float64) error
+}
+
+// filterParam - числовой параметр фильтра. Диапазон отдается в /api/filters,
+// чтобы интерфейс мог построить ползунок. Параметр с Options - выбор из
+// списка: в запросе передается имя варианта, значением становится его номер.
+// Параметр с Type "color" - цвет (#rrggbb или имя), значение - 0xRRGGBB.
+type filterParam struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Min     float64  `json:"min"`
+	Max     float64  `json:"max"`
+	Step    float64  `json:"step"`
+	Default float64  `json:"default"`
+	Options []string `json:"options,omitempty"`
+	Type    string   `json:"type,omitempty"`
+}
+
+// paramColor - тип параметра-цвета
+const paramColor = "color"
+
+// numberParam - числовой параметр с диапазоном и шагом
+func numberParam(id, name string, lo, hi, step, def float64) filterParam {
+	return filterParam{id, name, lo, hi, step, def, nil, ""}
+}
+
+// choiceParam - параметр-выбор; def - номер варианта по умолчанию
+func choiceParam(id, name string, options []string, def int) filterParam {
+	return filterParam{id, name, 0, float64(len(options) - 1), 1, float64(def), options, ""}
+}
+
+// colorParam - параметр-цвет; def - 0xRRGGBB
+func colorParam(id, name string, def uint32) filterParam {
+	return filterParam{id, name, 0, 0xffffff, 1, float64(def), nil, paramColor}
+}
+
+// packColor - значение параметра-цвета 0xRRGGBB
+func packColor(c color.NRGBA) float64 {
+	return float64(uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B))
+}
+
+// unpackColor - компоненты 0..1 значения параметра-цвета
+func unpackColor(v float64) (r, g, b float32) {
+	c := uint32(v)
+	return float32(c>>16&0xff) / 255, float32(c>>8&0xff) / 255, float32(c&0xff) / 255
+}
+
+// filterNone - пустой фильтр (исходное изображение)
+const filterNone = "none"
+
+// Реестр фильтров в порядке показа: сначала встроенные пресеты, затем
+// фильтры, зарегистрированные в init (порядок между файлами не важен)
+var (
+	filterList  = presetFilters()
+	filterIndex = indexFilters(filterList)
+)
+
+func indexFilters(list []Filter) map[string]Filter {
+	index := make(map[string]Filter, len(list))
+	for _, f := range list {
+		index[f.ID()] = f
+	}
+	return index
+}
+
+// registerFilter - добавление фильтра в реестр; повтор id - ошибка программы
+func registerFilter(f Filter) {
+	if _, ok := filterIndex[f.ID()]; ok {
+		panic(fmt.Sprintf("фильтр %q зарегистрирован дважды", f.ID()))
+	}
+	filterList = append(filterList, f)
+	filterIndex[f.ID()] = f
+}
+
+// lookupFilter - фильтр по id (nil, если такого нет); "lut:<имя>" -
+// загруженная 3D LUT
+func lookupFilter(id string) Filter {
+	if name, ok := strings.CutPrefix(id, lutPrefix); ok {
+		if lut := lookupLUT(name); lut != nil {
+			return lut.filter(name)
+		}
+		return nil
+	}
+	return filterIndex[id]
+}
+
+// allFilters - встроенные фильтры и загруженные LUT
+func allFilters() []Filter {
+	return append(filterList[:len(filterList):len(filterList)], lutFilters()...)
+}
+
+// funcFilter - фильтр из функции
+type funcFilter struct {
+	id, name, icon string
+	params         []filterParam
+	apply          func(img image.Image, params map[string]float64) image.Image
+}
+
+func (f *funcFilter) ID() string            { return f.id }
+func (f *funcFilter) Name() string          { return f.name }
+func (f *funcFilter) Icon() string          { return f.icon }
+func (f *funcFilter) Params() []filterParam { return f.params }
+
+func (f *funcFilter) Apply(img image.Image, params map[string]float64) image.Image {
+	return f.apply(img, params)
+}
+
+// newFilter - фильтр из функции apply
+func newFilter(id, name, icon string, params []filterParam, apply func(img image.Image, params map[string]float64) image.Image) Filter {
+	return &funcFilter{id, name, icon, params, apply}
+}
+
+// limitedFilter - фильтр с проверкой объема работы
+type limitedFilter struct {
+	Filter
+	check func(w, h int, params map[string]float64) error
+}
+
+func (f *limitedFilter) CheckWork(w, h int, params map[string]float64) error {
+	return f.check(w, h, params)
+}
+
+// withWorkLimit - фильтр f, отказывающий, когда check возвращает ошибку
+func withWorkLimit(f Filter, check func(w, h int, params map[string]float64) error) Filter {
+	return &limitedFilter{f, check}
+}
+
+// colorFilter - попиксельный фильтр: pixel по значениям параметров строит
+// преобразование цвета для mapPixels
+func colorFilter(id, name, icon string, params []filterParam, pixel func(p map[string]float64) pixelFunc) Filter {
+	return newFilter(id, name, icon, params, func(img image.Image, p map[string]float64) image.Image {
+		return mapPixels(img, pixel(p))
+	})
+}
+
+// fixedColor - попиксельный фильтр без параметров
+func fixedColor(id, name, icon string, f pixelFunc) Filter {
+	return colorFilter(id, name, icon, nil, func(map[string]float64) pixelFunc { return f })
+}
+
+// filterSchema - параметры фильтра: собственные и общая сила эффекта
+func filterSchema(f Filter) []filterParam {
+	schema := []filterParam{}
+	if f.ID() == filterNone {
+		return schema
+	}
+	schema = append(schema, f.Params()...)
+	return append(schema, intensityParam)
+}
+
+// filterParams - проверка параметров фильтра и подстановка значений по умолчанию
+func filterParams(filter string, given map[string]interface{}) (map[string]float64, error) {
+	f := lookupFilter(filter)
+	if f == nil {
+		return nil, fmt.Errorf("неизвестный фильтр %q", filter)
+	}
+	return resolveParams(filterSchema(f), given)
+}
+
+// resolveParams - значения всех параметров фильтра: недостающие берутся по
+// умолчанию, неизвестные и выходящие за диапазон - ошибка
+func resolveParams(schema []filterParam, given map[string]interface{}) (map[string]float64, error) {
+	values := make(map[string]float64, len(schema))
+	for _, p := range schema {
+		values[p.ID] = p.Default
+	}
+	for id, raw := range given {
+		var param *filterParam
+		for i := range schema {
+			if schema[i].ID == id {
+				param = &schema[i]
+			}
+		}
+		if param == nil {
+			if len(schema) == 0 {
+				return nil, fmt.Errorf("параметр %q: у фильтра нет параметров", id)
+			}
+			known := make([]string, len(schema))
+			for i, p := range schema {
+				known[i] = p.ID
+			}
+			return nil, fmt.Errorf("неизвестный параметр %q. Допустимые: %s", id, strings.Join(known, ", "))
+		}
+
+		var v float64
+		switch raw := raw.(type) {
+		case float64:
+			v = raw
+			if param.Options != nil && v != math.Trunc(v) {
+				return nil, fmt.Errorf("%s: ожидается одно из: %s", id, strings.Join(param.Options, ", "))
+			}
+			if param.Type == paramColor && v != math.Trunc(v) {
+				return nil, fmt.Errorf("%s: ожидается цвет #rrggbb", id)
+			}
+		case string:
+			if param.Type == paramColor {
+				c, err := parseColor(raw)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", id, err)
+				}
+				values[id] = packColor(c)
+				continue
+			}
+			v = -1
+			for i, o := range param.Options {
+				if strings.EqualFold(o, raw) {
+					v = float64(i)
+				}
+			}
+			if v < 0 {
+				if param.Options == nil {
+					return nil, fmt.Errorf("%s: ожидается число, получено %q", id, raw)
+				}
+				return nil, fmt.Errorf("%s: ожидается одно из: %s, получено %q", id, strings.Join(param.Options, ", "), raw)
+			}
+		default:
+			return nil, fmt.Errorf("%s: ожидается число", id)
+		}
+		if math.IsNaN(v) || v < param.Min || v > param.Max {
+			return nil, fmt.Errorf("%s: ожидается значение от %g до %g, получено %g", id, param.Min, param.Max, v)
+		}
+		values[id] = v
+	}
+	return values, nil
+}
+
+// applyFilter - применение фильтра; params проверены filterParams.
+// Результат смешивается с исходником по силе эффекта и маске (mask может быть nil).
+func applyFilter(img image.Image, filter string, params map[string]float64, mask image.Image) (image.Image, error) {
+	f := lookupFilter(filter)
+	if f == nil || filter == filterNone {
+		return img, nil
+	}
+	if limited, ok := f.(workLimited); ok {
+		b := img.Bounds()
+		if err := limited.CheckWork(b.Dx(), b.Dy(), params); err != nil {
+			return nil, err
+		}
+	}
+	return blendFilter(img, f.Apply(img, params), params[intensityParam.ID]/100, mask), nil
+}
+
+// presetFilters - встроенные фильтры-пресеты
+func presetFilters() []Filter {
+	return []Filter{
+		newFilter(filterNone, "Без фильтра", "🔄", nil, func(img image.Image, _ map[string]float64) image.Image {
+			return img
+		}),
+		fixedColor("grayscale", "Черно-белый", "⚫", func(r, g, b float32) (float32, float32, float32) {
+			gray := lumaR*r + lumaG*g + lumaB*b
+			return gray, gray, gray
+		}),
+		fixedColor("sepia", "Сепия", "🟤", func(r, g, b float32) (float32, float32, float32) {
+			tr := r*0.393 + g*0.769 + b*0.189
+			tg := r*0.349 + g*0.686 + b*0.168
+			tb := r*0.272 + g*0.534 + b*0.131
+			return tr, tg, tb
+		}),
+		fixedColor("invert", "Инверсия", "🔄", func(r, g, b float32) (float32, float32, float32) {
+			return 1 - r, 1 - g, 1 - b
+		}),
+		fixedColor("cool", "Холодный", "❄️", func(r, g, b float32) (float32, float32, float32) {
+			return r * 0.9, g * 0.9, b * 1.1
+		}),
+		fixedColor("warm", "Теплый", "🔥", func(r, g, b float32) (float32, float32, float32) {
+			return r * 1.1, g, b * 0.9
+		}),
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFilterRegistry(t *testing.T) {
+	seen := map[string]bool{}
+	for _, f := range filterList {
+		if seen[f.ID()] {
+			t.Errorf("фильтр %q зарегистрирован дважды", f.ID())
+		}
+		seen[f.ID()] = true
+		if lookupFilter(f.ID()) == nil {
+			t.Errorf("lookupFilter(%q) = nil", f.ID())
+		}
+		if f.Name() == "" || f.Icon() == "" {
+			t.Errorf("%s: нет названия или значка", f.ID())
+		}
+		for _, p := range f.Params() {
+			if p.Min > p.Max || p.Default < p.Min || p.Default > p.Max {
+				t.Errorf("%s.%s: значение по умолчанию %g вне %g..%g", f.ID(), p.ID, p.Default, p.Min, p.Max)
+			}
+		}
+	}
+	if lookupFilter("no-such-filter") != nil {
+		t.Error("неизвестный фильтр найден")
+	}
+}
+
+// Каждый фильтр с параметрами по умолчанию и крайними значениями
+// не меняет размер и не падает, в том числе на изображении 1×1
+func TestFiltersApply(t *testing.T) {
+	images := map[string]image.Image{
+		"8 бит":  testImage(9, 7),
+		"16 бит": image.NewNRGBA64(image.Rect(0, 0, 5, 4)),
+		"1x1":    testImage(1, 1),
+	}
+	for _, f := range filterList {
+		for _, extreme := range []string{"default", "min", "max"} {
+			given := map[string]interface{}{}
+			for _, p := range f.Params() {
+				switch {
+				case p.Options != nil || p.Type == paramColor:
+				case extreme == "min":
+					given[p.ID] = p.Min
+				case extreme == "max":
+					given[p.ID] = p.Max
+				}
+			}
+			values, err := filterParams(f.ID(), given)
+			if err != nil {
+				t.Errorf("%s (%s): %v", f.ID(), extreme, err)
+				continue
+			}
+			for name, img := range images {
+				out, err := applyFilter(img, f.ID(), values, nil)
+				if err != nil {
+					t.Errorf("%s (%s, %s): %v", f.ID(), extreme, name, err)
+					continue
+				}
+				if out.Bounds().Size() != img.Bounds().Size() {
+					t.Errorf("%s (%s, %s): размер %v", f.ID(), extreme, name, out.Bounds())
+				}
+			}
+		}
+	}
+}
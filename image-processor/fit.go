@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+// Режимы вписывания при изменении размера
+const (
+	fitFill    = "fill"    // растянуть точно в width×height
+	fitCover   = "cover"   // заполнить рамку, лишнее обрезать по gravity
+	fitContain = "contain" // вписать целиком, остаток залить background
+	fitInside  = "inside"  // вписать в рамку, не увеличивая
+	fitOutside = "outside" // покрыть рамку, не увеличивая
+	fitSeam    = "seam"    // удалить/вставить швы с наименьшей энергией (seam carving)
+)
+
+// gravity - точка привязки: 0 - левый/верхний край, 1 - правый/нижний.
+// Smart - область выбирается по содержимому (при размещении - как center).
+type gravity struct {
+	Name  string
+	X, Y  float64
+	Smart bool
+}
+
+var gravities = map[string]gravity{
+	"center":    {"center", 0.5, 0.5, false},
+	"north":     {"north", 0.5, 0, false},
+	"south":     {"south", 0.5, 1, false},
+	"west":      {"west", 0, 0.5, false},
+	"east":      {"east", 1, 0.5, false},
+	"northwest": {"northwest", 0, 0, false},
+	"northeast": {"northeast", 1, 0, false},
+	"southwest": {"southwest", 0, 1, false},
+	"southeast": {"southeast", 1, 1, false},
+	"smart":     {"smart", 0.5, 0.5, true},
+}
+
+// gravityAliases - привычные синонимы (top, left-top и т.п.)
+var gravityAliases = map[string]string{
+	"centre": "center", "middle": "center",
+	"top": "north", "bottom": "south", "left": "west", "right": "east",
+	"top-left": "northwest", "top-right": "northeast",
+	"bottom-left": "southwest", "bottom-right": "southeast",
+	"left-top": "northwest", "right-top": "northeast",
+	"left-bottom": "southwest", "right-bottom": "southeast",
+}
+
+// parseGravity - разбор точки привязки ("" - center)
+func parseGravity(s string) (gravity, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		s = "center"
+	}
+	if alias, ok := gravityAliases[s]; ok {
+		s = alias
+	}
+	g, ok := gravities[s]
+	if !ok {
+		return gravity{}, fmt.Errorf("gravity: неизвестное значение %q", s)
+	}
+	return g, nil
+}
+
+// parseFit - проверка режима вписывания
+func parseFit(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "", fitFill, fitCover, fitContain, fitInside, fitOutside, fitSeam:
+		return s, nil
+	}
+	return "", fmt.Errorf("fit: ожидается cover, contain, fill, inside, outside или seam, получено %q", s)
+}
+
+// resizeSpec - параметры одного изменения размера
+type resizeSpec struct {
+	Width, Height int
+	Fit           string
+	Gravity       gravity
+	Background    color.NRGBA
+	Kernel        *resampleKernel
+	Mask          image.Image // маска защиты/удаления для fit=seam
+}
+
+// validate - проверка сочетания размеров и режима
+func (s resizeSpec) validate() error {
+	if s.Width < 0 || s.Height < 0 {
+		return fmt.Errorf("размеры не могут быть отрицательными")
+	}
+	if s.Width == 0 && s.Height == 0 {
+		return fmt.Errorf("нужно указать width и/или height")
+	}
+	if s.Width > maxOutputSide || s.Height > maxOutputSide {
+		return fmt.Errorf("размер больше %d px", maxOutputSide)
+	}
+	switch s.Fit {
+	case fitCover, fitContain, fitFill:
+		if s.Width == 0 || s.Height == 0 {
+			return fmt.Errorf("fit=%s требует и width, и height", s.Fit)
+		}
+	}
+	return nil
+}
+
+// resizeFit - изменение размера с учетом режима вписывания
+func resizeFit(img image.Image, s resizeSpec, rc *runContext) (image.Image, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	switch s.Fit {
+	case fitCover:
+		// Вырезаем из источника область с пропорциями рамки и масштабируем ее
+		// при крайних пропорциях область округляется до 0 - берем хотя бы 1 px
+		cw, ch := w, h
+		if float64(w)*float64(s.Height) > float64(h)*float64(s.Width) {
+			cw = clampInt(int(math.Round(float64(h)*float64(s.Width)/float64(s.Height))), 1, w)
+		} else {
+			ch = clampInt(int(math.Round(float64(w)*float64(s.Height)/float64(s.Width))), 1, h)
+		}
+		crop := rc.placeRect(img, cw, ch, s.Gravity)
+		return resampleImage(cropImage(img, crop), s.Width, s.Height, s.Kernel), nil
+
+	case fitContain:
+		scale := math.Min(float64(s.Width)/float64(w), float64(s.Height)/float64(h))
+		sw, sh := scaledSize(w, h, scale)
+		scaled := resampleImage(img, sw, sh, s.Kernel)
+
+		dst := newCanvas(img, image.Rect(0, 0, s.Width, s.Height))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(s.Background), image.Point{}, draw.Src)
+		at := anchorRect(dst.Bounds(), sw, sh, s.Gravity)
+		draw.Draw(dst, at, scaled, scaled.Bounds().Min, draw.Over)
+		return dst, nil
+
+	case fitInside, fitOutside:
+		scale := 0.0
+		switch {
+		case s.Width == 0:
+			scale = float64(s.Height) / float64(h)
+		case s.Height == 0:
+			scale = float64(s.Width) / float64(w)
+		case s.Fit == fitInside:
+			scale = math.Min(float64(s.Width)/float64(w), float64(s.Height)/float64(h))
+		default:
+			scale = math.Max(float64(s.Width)/float64(w), float64(s.Height)/float64(h))
+		}
+		if scale >= 1 {
+			// без увеличения
+			return img, nil
+		}
+		sw, sh := scaledSize(w, h, scale)
+		if err := checkOutputSize(sw, sh); err != nil {
+			return nil, err
+		}
+		return resampleImage(img, sw, sh, s.Kernel), nil
+
+	case fitSeam:
+		return seamCarve(img, s.Width, s.Height, s.Mask)
+	}
+
+	// fill или режим не задан: прежнее поведение resizeImage
+	return resizeImage(img, s.Width, s.Height, s.Kernel)
+}
+
+// outputSizeError - размер результата, вычисленный по пропорциям, больше
+// maxOutputSide. Это ошибка запроса (400), а не изображения.
+type outputSizeError struct {
+	Width, Height int
+}
+
+func (e *outputSizeError) Error() string {
+	return fmt.Sprintf("размер результата %d×%d больше %d px", e.Width, e.Height, maxOutputSide)
+}
+
+// checkOutputSize - проверка вычисленного размера результата
+func checkOutputSize(w, h int) error {
+	if w > maxOutputSide || h > maxOutputSide {
+		return &outputSizeError{w, h}
+	}
+	return nil
+}
+
+// scaledSize - размеры после масштабирования, не меньше 1 px
+func scaledSize(w, h int, scale float64) (int, int) {
+	sw := int(math.Round(float64(w) * scale))
+	sh := int(math.Round(float64(h) * scale))
+	if sw < 1 {
+		sw = 1
+	}
+	if sh < 1 {
+		sh = 1
+	}
+	return sw, sh
+}
+
+// anchorRect - прямоугольник w×h внутри bounds, размещенный по gravity
+func anchorRect(bounds image.Rectangle, w, h int, g gravity) image.Rectangle {
+	x := bounds.Min.X + int(math.Round(float64(bounds.Dx()-w)*g.X))
+	y := bounds.Min.Y + int(math.Round(float64(bounds.Dy()-h)*g.Y))
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// cropImage - вырезание области (без копирования, если тип изображения это позволяет)
+func cropImage(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Intersect(img.Bounds())
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+
+	dst := newCanvas(img, image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
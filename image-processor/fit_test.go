@@ -0,0 +1,135 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testImage - непрозрачное изображение w×h с градиентом
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / max(w-1, 1)), uint8(y * 255 / max(h-1, 1)), 128, 255})
+		}
+	}
+	return img
+}
+
+func TestResizeFitSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		srcW, srcH   int
+		fit          string
+		w, h         int
+		wantW, wantH int
+	}{
+		{"fill", 200, 100, fitFill, 50, 50, 50, 50},
+		{"fill width only", 200, 100, "", 50, 0, 50, 25},
+		{"fill height only", 200, 100, "", 0, 50, 100, 50},
+		{"cover", 200, 100, fitCover, 50, 50, 50, 50},
+		{"contain", 200, 100, fitContain, 50, 50, 50, 50},
+		{"inside", 200, 100, fitInside, 50, 50, 50, 25},
+		{"inside no upscale", 200, 100, fitInside, 400, 400, 200, 100},
+		{"outside", 200, 100, fitOutside, 50, 40, 80, 40},
+		{"outside no upscale", 200, 100, fitOutside, 300, 300, 200, 100},
+		{"inside width only", 200, 100, fitInside, 100, 0, 100, 50},
+
+		// крайние пропорции: область обрезки не должна округляться до 0
+		{"cover 2x1 to 3x50", 2, 1, fitCover, 3, 50, 3, 50},
+		{"cover 1x3 to 3x50", 1, 3, fitCover, 3, 50, 3, 50},
+		{"cover 1000x1 to 1x5000", 1000, 1, fitCover, 1, 5000, 1, 5000},
+		{"cover 1x1000 to 5000x1", 1, 1000, fitCover, 5000, 1, 5000, 1},
+		{"contain 1000x1 to 10x10", 1000, 1, fitContain, 10, 10, 10, 10},
+		{"inside 1000x1", 1000, 1, fitInside, 10, 10, 10, 1},
+	}
+	kernel, _ := parseResample("")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := resizeSpec{Width: tt.w, Height: tt.h, Fit: tt.fit, Gravity: gravities["center"], Kernel: kernel}
+			if err := spec.validate(); err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			out, err := resizeFit(testImage(tt.srcW, tt.srcH), spec, newRunContext())
+			if err != nil {
+				t.Fatalf("resizeFit: %v", err)
+			}
+			if got := out.Bounds().Size(); got != image.Pt(tt.wantW, tt.wantH) {
+				t.Errorf("размер %v, ожидается %dx%d", got, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestResizeSpecValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    resizeSpec
+		wantErr bool
+	}{
+		{"width only", resizeSpec{Width: 10}, false},
+		{"both", resizeSpec{Width: 10, Height: 10, Fit: fitCover}, false},
+		{"nothing", resizeSpec{}, true},
+		{"negative", resizeSpec{Width: -1, Height: 10}, true},
+		{"too large", resizeSpec{Width: maxOutputSide + 1}, true},
+		{"cover one side", resizeSpec{Width: 10, Fit: fitCover}, true},
+		{"contain one side", resizeSpec{Height: 10, Fit: fitContain}, true},
+		{"inside one side", resizeSpec{Height: 10, Fit: fitInside}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.spec.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, ожидается ошибка: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResizeDerivedSideLimit(t *testing.T) {
+	kernel, _ := parseResample("")
+	// 1×100 при width=10000 дало бы 10000×1000000
+	spec := resizeSpec{Width: maxOutputSide, Kernel: kernel}
+	_, err := resizeFit(testImage(1, 100), spec, newRunContext())
+	if _, ok := err.(*outputSizeError); !ok {
+		t.Errorf("ожидается outputSizeError, получено %v", err)
+	}
+}
+
+func TestParseGravity(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "center", false},
+		{"top-left", "northwest", false},
+		{" East ", "east", false},
+		{"smart", "smart", false},
+		{"up", "", true},
+	}
+	for _, tt := range tests {
+		g, err := parseGravity(tt.in)
+		if (err != nil) != tt.wantErr || g.Name != tt.want {
+			t.Errorf("parseGravity(%q) = %q, %v", tt.in, g.Name, err)
+		}
+	}
+}
+
+func TestAnchorRect(t *testing.T) {
+	bounds := image.Rect(0, 0, 100, 50)
+	tests := []struct {
+		gravity string
+		want    image.Rectangle
+	}{
+		{"center", image.Rect(40, 15, 60, 35)},
+		{"northwest", image.Rect(0, 0, 20, 20)},
+		{"southeast", image.Rect(80, 30, 100, 50)},
+		{"east", image.Rect(80, 15, 100, 35)},
+	}
+	for _, tt := range tests {
+		if got := anchorRect(bounds, 20, 20, gravities[tt.gravity]); got != tt.want {
+			t.Errorf("%s: %v, ожидается %v", tt.gravity, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// acceptedFormats - входные форматы (имена как у image.DecodeConfig)
+var acceptedFormats = []string{"jpeg", "png", "gif", "bmp", "tiff", "webp"}
+
+// formatTitles - названия форматов для сообщений
+var formatTitles = map[string]string{
+	"jpeg": "JPEG", "png": "PNG", "gif": "GIF", "bmp": "BMP", "tiff": "TIFF", "webp": "WebP",
+}
+
+// errUnsupportedFormat - файл не распознан ни одним из декодеров
+var errUnsupportedFormat = errors.New("неподдерживаемый формат изображения")
+
+// detectFormat - определение формата по содержимому
+func detectFormat(r io.Reader) (string, error) {
+	_, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", errUnsupportedFormat
+	}
+	for _, f := range acceptedFormats {
+		if f == format {
+			return format, nil
+		}
+	}
+	return "", errUnsupportedFormat
+}
+
+// decodeImage - декодирование; для многостраничного TIFF берется страница page (с 0).
+// При autorient пиксели поворачиваются по тегу EXIF Orientation; результат
+// кодируется без EXIF, так что повторно поворот не применится.
+func decodeImage(data []byte, page int, autorient bool) (image.Image, string, error) {
+	format, err := detectFormat(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if format == "tiff" && page > 0 {
+		if data, err = tiffPage(data, page); err != nil {
+			return nil, "", err
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка декодирования %s: %v", formatTitles[format], err)
+	}
+	if autorient {
+		img = applyOrientation(img, exifOrientation(exifBlock(data, format)))
+	}
+	return img, format, nil
+}
+
+// maxTIFFPages - наибольший номер страницы TIFF в запросе
+const maxTIFFPages = 1000
+
+// tiffPage - копия TIFF, в которой первой страницей стала страница page.
+// Декодер читает только первый IFD, поэтому достаточно переписать
+// смещение первого IFD в заголовке.
+func tiffPage(data []byte, page int) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, errors.New("TIFF: файл поврежден")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("TIFF: неверный заголовок")
+	}
+
+	if page > maxTIFFPages {
+		return nil, fmt.Errorf("TIFF: номер страницы больше %d", maxTIFFPages)
+	}
+
+	offset := order.Uint32(data[4:8])
+	seen := map[uint32]bool{}
+	for i := 0; i < page; i++ {
+		if offset == 0 || int(offset)+2 > len(data) {
+			return nil, fmt.Errorf("TIFF: в файле только %d стр., запрошена страница %d", i, page)
+		}
+		if seen[offset] {
+			return nil, errors.New("TIFF: цепочка страниц зациклена")
+		}
+		seen[offset] = true
+		count := int(order.Uint16(data[offset:]))
+		next := int(offset) + 2 + count*12
+		if next+4 > len(data) {
+			return nil, errors.New("TIFF: файл поврежден")
+		}
+		offset = order.Uint32(data[next:])
+	}
+	if offset == 0 {
+		return nil, fmt.Errorf("TIFF: в файле только %d стр., запрошена страница %d", page, page)
+	}
+
+	out := make([]byte, len(data))
+	copy(out, data)
+	order.PutUint32(out[4:8], offset)
+	return out, nil
+}
+
+// sendUnsupportedFormat - JSON-ошибка со списком допустимых форматов
+func sendUnsupportedFormat(w http.ResponseWriter) {
+	names := make([]string, len(acceptedFormats))
+	for i, f := range acceptedFormats {
+		names[i] = formatTitles[f]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnsupportedMediaType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":    "Неподдерживаемый формат. Допустимые: " + strings.Join(names, ", "),
+		"accepted": acceptedFormats,
+		"success":  false,
+	})
+}
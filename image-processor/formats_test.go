@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// tiffChain - заголовок TIFF (II) и n пустых IFD, связанных в цепочку;
+// loop - последний IFD ссылается на первый
+func tiffChain(n int, loop bool) []byte {
+	b := []byte("II*\x00")
+	b = binary.LittleEndian.AppendUint32(b, 8)
+	for i := 0; i < n; i++ {
+		next := uint32(8 + (i+1)*6)
+		if i == n-1 {
+			next = 0
+			if loop {
+				next = 8
+			}
+		}
+		b = binary.LittleEndian.AppendUint16(b, 0) // записей нет
+		b = binary.LittleEndian.AppendUint32(b, next)
+	}
+	return b
+}
+
+func TestTIFFPage(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		page       int
+		wantOffset uint32
+		wantErr    string
+	}{
+		{"first", tiffChain(3, false), 0, 8, ""},
+		{"second", tiffChain(3, false), 1, 14, ""},
+		{"last", tiffChain(3, false), 2, 20, ""},
+		{"past end", tiffChain(3, false), 3, 0, "только"},
+		{"far past end", tiffChain(3, false), 50, 0, "только"},
+		{"loop", tiffChain(2, true), 5, 0, "зациклена"},
+		{"page limit", tiffChain(1, true), maxTIFFPages + 1, 0, "больше"},
+		{"short", []byte("II*\x00"), 1, 0, "поврежден"},
+		{"bad header", []byte("XX*\x00\x08\x00\x00\x00"), 1, 0, "заголовок"},
+		{"truncated ifd", tiffChain(2, false)[:12], 1, 0, "поврежден"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tiffPage(tt.data, tt.page)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидается с %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tiffPage: %v", err)
+			}
+			if got := binary.LittleEndian.Uint32(out[4:]); got != tt.wantOffset {
+				t.Errorf("смещение первого IFD %d, ожидается %d", got, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestDecodeImageFormats(t *testing.T) {
+	src := testImage(7, 5)
+	encode := map[string]func(*bytes.Buffer) error{
+		"png":  func(b *bytes.Buffer) error { return png.Encode(b, src) },
+		"bmp":  func(b *bytes.Buffer) error { return bmp.Encode(b, src) },
+		"tiff": func(b *bytes.Buffer) error { return tiff.Encode(b, src, nil) },
+	}
+	for format, enc := range encode {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := enc(&buf); err != nil {
+				t.Fatal(err)
+			}
+			img, got, err := decodeImage(buf.Bytes(), 0, true)
+			if err != nil {
+				t.Fatalf("decodeImage: %v", err)
+			}
+			if got != format || img.Bounds().Dx() != 7 || img.Bounds().Dy() != 5 {
+				t.Errorf("формат %s, размер %v", got, img.Bounds())
+			}
+		})
+	}
+
+	if _, _, err := decodeImage([]byte("not an image"), 0, false); err != errUnsupportedFormat {
+		t.Errorf("неизвестный формат: %v", err)
+	}
+}
@@ -1,3 +1,5 @@
 module image-processor
 
-go 1.21
\ No newline at end of file
+go 1.21
+
+require golang.org/x/image v0.18.0
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// Управление цветом: RGB- и Gray-профили вида "матрица + кривые" (matrix/TRC).
+// Пиксели переводятся в пространство связи профилей (XYZ, D50) и оттуда
+// в целевой профиль. Профили на таблицах (A2B0 и т.п.) не поддерживаются.
+
+// profileKeep - значение profile, при котором пиксели не преобразуются
+const profileKeep = "keep"
+
+// Размеры таблиц преобразования
+const (
+	iccInputLUT  = 1 << 16 // по значению на каждый 16-битный уровень
+	iccOutputLUT = 1 << 16 // линейная яркость → код
+)
+
+// iccD50 - белая точка пространства связи профилей
+var iccD50 = [3]float64{0.9642, 1.0, 0.8249}
+
+// bradford - матрица конуса Брэдфорда для хроматической адаптации
+var bradford = [3][3]float64{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+// toneCurve - кривая передачи канала (TRC): код 0..1 → линейная яркость 0..1.
+// Функция задается таблицей или параметрами как в типе para (ICC, 10.18).
+type toneCurve struct {
+	Func   int // 0..4; -1 - таблица
+	Params []float64
+	Table  []float64
+}
+
+// iccProfile - профиль: XYZ(D50) = Matrix · (кривые(rgb))
+type iccProfile struct {
+	Name   string
+	Matrix [3][3]float64 // столбцы - rXYZ, gXYZ, bXYZ
+	Curves [3]toneCurve
+}
+
+// srgbCurve - кривая sRGB (IEC 61966-2-1)
+var srgbCurve = toneCurve{Func: 3, Params: []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045}}
+
+// d65 - белая точка D65 (xy)
+var d65 = [2]float64{0.3127, 0.3290}
+
+// builtinProfiles - целевые профили, которые можно запросить по имени
+var builtinProfiles = map[string]*iccProfile{
+	"srgb":       newMatrixProfile("sRGB", [3][2]float64{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}}, d65, srgbCurve),
+	"display-p3": newMatrixProfile("Display P3", [3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}}, d65, srgbCurve),
+	"adobe-rgb":  newMatrixProfile("Adobe RGB (1998)", [3][2]float64{{0.64, 0.33}, {0.21, 0.71}, {0.15, 0.06}}, d65, toneCurve{Func: 0, Params: []float64{563.0 / 256}}),
+}
+
+// profileAliases - другие написания имен профилей
+var profileAliases = map[string]string{"p3": "display-p3", "adobergb": "adobe-rgb", "adobe-rgb-1998": "adobe-rgb"}
+
+// parseTargetProfile - целевой профиль по имени ("" - sRGB, keep - без преобразования, nil)
+func parseTargetProfile(s string) (*iccProfile, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "":
+		s = "srgb"
+	case profileKeep:
+		return nil, nil
+	}
+	if alias, ok := profileAliases[s]; ok {
+		s = alias
+	}
+	p, ok := builtinProfiles[s]
+	if !ok {
+		return nil, fmt.Errorf("profile: ожидается srgb, display-p3, adobe-rgb или keep, получено %q", s)
+	}
+	return p, nil
+}
+
+// newMatrixProfile - профиль по цветностям основных цветов и белой точке;
+// матрица приводится к D50 адаптацией Брэдфорда, как требует ICC
+func newMatrixProfile(name string, primaries [3][2]float64, white [2]float64, curve toneCurve) *iccProfile {
+	xyz := func(xy [2]float64) [3]float64 {
+		return [3]float64{xy[0] / xy[1], 1, (1 - xy[0] - xy[1]) / xy[1]}
+	}
+
+	// Столбцы - XYZ основных цветов, масштабированные так, чтобы 1,1,1 дало белый
+	var m [3][3]float64
+	for c, p := range primaries {
+		v := xyz(p)
+		for r := 0; r < 3; r++ {
+			m[r][c] = v[r]
+		}
+	}
+	s := mulVec(invert3(m), xyz(white))
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			m[r][c] *= s[c]
+		}
+	}
+
+	p := &iccProfile{Name: name, Matrix: mul3(adaptation(xyz(white), iccD50), m)}
+	p.Curves = [3]toneCurve{curve, curve, curve}
+	return p
+}
+
+// adaptation - матрица хроматической адаптации от белой точки src к dst
+func adaptation(src, dst [3]float64) [3][3]float64 {
+	s, d := mulVec(bradford, src), mulVec(bradford, dst)
+	var scale [3][3]float64
+	for i := 0; i < 3; i++ {
+		scale[i][i] = d[i] / s[i]
+	}
+	return mul3(invert3(bradford), mul3(scale, bradford))
+}
+
+// unmanagedHeader - заголовок ответа, если встроенный профиль не удалось
+// применить и цвета результата не приведены к целевому профилю
+const unmanagedHeader = "X-Color-Management"
+
+// manageColor - перевод пикселей из встроенного профиля src (nil - считается sRGB)
+// в target (nil - без преобразования). Возвращает профиль, описывающий
+// полученные пиксели, и признак того, что пиксели переведены в target.
+// Неподдерживаемые профили (CMYK, LUT) оставляют пиксели как есть, об этом
+// сообщает заголовок unmanagedHeader.
+func manageColor(img image.Image, src []byte, target *iccProfile, rc *runContext) (image.Image, []byte, bool) {
+	if target == nil {
+		return img, rgbICC(src), false
+	}
+	from := builtinProfiles["srgb"]
+	if src != nil {
+		p, err := parseICC(src)
+		if err != nil {
+			fmt.Printf("[ICC] %v - цвета оставлены без преобразования\n", err)
+			rc.Header.Set(unmanagedHeader, "unmanaged")
+			return img, rgbICC(src), false
+		}
+		from = p
+	}
+	return convertProfile(img, from, target), encodeICC(target), true
+}
+
+// rgbICC - профиль, если он годится для RGB-результата
+func rgbICC(icc []byte) []byte {
+	if iccColorSpace(icc) != "RGB " {
+		return nil
+	}
+	return icc
+}
+
+// parseICC - разбор профиля matrix/TRC
+func parseICC(data []byte) (*iccProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("ICC: поврежденный профиль")
+	}
+	space, pcs := string(data[16:20]), string(data[20:24])
+	if pcs != "XYZ " || space != "RGB " && space != "GRAY" {
+		return nil, fmt.Errorf("ICC: профили %q с PCS %q не поддерживаются", strings.TrimSpace(space), strings.TrimSpace(pcs))
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count && 132+i*12+12 <= len(data); i++ {
+		e := data[132+i*12:]
+		off, size := int(binary.BigEndian.Uint32(e[4:])), int(binary.BigEndian.Uint32(e[8:]))
+		if off < 0 || size < 0 || off+size > len(data) {
+			return nil, errors.New("ICC: таблица тегов выходит за границы профиля")
+		}
+		tags[string(e[:4])] = data[off : off+size]
+	}
+
+	p := &iccProfile{Name: iccDescription(tags["desc"])}
+	var err error
+	if space == "GRAY" {
+		// серый как RGB с одинаковыми кривыми: сумма столбцов дает белую точку D50
+		k, ok := tags["kTRC"]
+		if !ok {
+			return nil, errors.New("ICC: в Gray-профиле нет kTRC")
+		}
+		if p.Curves[0], err = parseCurve(k); err != nil {
+			return nil, err
+		}
+		p.Curves[1], p.Curves[2] = p.Curves[0], p.Curves[0]
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				p.Matrix[r][c] = iccD50[r] / 3
+			}
+		}
+		return p, nil
+	}
+
+	for c, name := range []string{"r", "g", "b"} {
+		xyzTag, ok1 := tags[name+"XYZ"]
+		trcTag, ok2 := tags[name+"TRC"]
+		if !ok1 || !ok2 {
+			return nil, errors.New("ICC: профиль не вида матрица+TRC (нет тегов rXYZ/rTRC)")
+		}
+		if len(xyzTag) < 20 || string(xyzTag[:4]) != "XYZ " {
+			return nil, fmt.Errorf("ICC: неверный тег %sXYZ", name)
+		}
+		for r := 0; r < 3; r++ {
+			p.Matrix[r][c] = s15Fixed16(xyzTag[8+r*4:])
+		}
+		if p.Curves[c], err = parseCurve(trcTag); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// parseCurve - тег curv или para
+func parseCurve(data []byte) (toneCurve, error) {
+	if len(data) < 12 {
+		return toneCurve{}, errors.New("ICC: поврежденная кривая")
+	}
+	switch string(data[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		switch {
+		case n == 0:
+			return toneCurve{Func: 0, Params: []float64{1}}, nil
+		case n == 1 && len(data) >= 14:
+			return toneCurve{Func: 0, Params: []float64{float64(binary.BigEndian.Uint16(data[12:])) / 256}}, nil
+		case n > 1 && len(data) >= 12+2*n:
+			t := make([]float64, n)
+			for i := range t {
+				t[i] = float64(binary.BigEndian.Uint16(data[12+2*i:])) / 65535
+			}
+			return toneCurve{Func: -1, Table: t}, nil
+		}
+	case "para":
+		fn := int(binary.BigEndian.Uint16(data[8:]))
+		counts := []int{1, 3, 4, 5, 7}
+		if fn < len(counts) && len(data) >= 12+4*counts[fn] {
+			params := make([]float64, counts[fn])
+			for i := range params {
+				params[i] = s15Fixed16(data[12+4*i:])
+			}
+			return toneCurve{Func: fn, Params: params}, nil
+		}
+	}
+	return toneCurve{}, errors.New("ICC: неподдерживаемый тип кривой")
+}
+
+// eval - линейная яркость по коду x (0..1)
+func (c toneCurve) eval(x float64) float64 {
+	if c.Func < 0 {
+		pos := x * float64(len(c.Table)-1)
+		i := min(int(pos), len(c.Table)-2)
+		f := pos - float64(i)
+		return c.Table[i]*(1-f) + c.Table[i+1]*f
+	}
+
+	var p [7]float64
+	copy(p[:], c.Params)
+	g, a, b, cc, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+	switch c.Func {
+	case 0:
+		return math.Pow(x, g)
+	case 1:
+		if x >= -b/a {
+			return math.Pow(a*x+b, g)
+		}
+		return 0
+	case 2:
+		if x >= -b/a {
+			return math.Pow(a*x+b, g) + cc
+		}
+		return cc
+	case 3:
+		if x >= d {
+			return math.Pow(a*x+b, g)
+		}
+		return cc * x
+	case 4:
+		if x >= d {
+			return math.Pow(a*x+b, g) + e
+		}
+		return cc*x + f
+	}
+	return x
+}
+
+// inverseTable - обратная кривая таблицей из n значений: код (0..1) для
+// линейной яркости i/(n-1). Кривые TRC монотонно возрастают, поэтому код
+// находится одним проходом по прямой кривой.
+func (c toneCurve) inverseTable(n int) []float64 {
+	forward := make([]float64, n)
+	for i := range forward {
+		forward[i] = c.eval(float64(i) / float64(n-1))
+	}
+	inv := make([]float64, n)
+	k := 0
+	for i := range inv {
+		y := float64(i) / float64(n-1)
+		for k+1 < n && forward[k+1] <= y {
+			k++
+		}
+		// линейная интерполяция между соседними кодами
+		x := float64(k)
+		if k+1 < n && forward[k+1] > forward[k] {
+			x += math.Max(0, math.Min(1, (y-forward[k])/(forward[k+1]-forward[k])))
+		}
+		inv[i] = x / float64(n-1)
+	}
+	return inv
+}
+
+// sameAs - профили совпадают с точностью до округления (преобразование не нужно)
+func (p *iccProfile) sameAs(q *iccProfile) bool {
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			if math.Abs(p.Matrix[r][c]-q.Matrix[r][c]) > 2e-3 {
+				return false
+			}
+		}
+	}
+	for ch := 0; ch < 3; ch++ {
+		for i := 0; i <= 16; i++ {
+			x := float64(i) / 16
+			if math.Abs(p.Curves[ch].eval(x)-q.Curves[ch].eval(x)) > 2e-3 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// convertProfile - перевод пикселей из профиля src в профиль dst
+func convertProfile(img image.Image, src, dst *iccProfile) image.Image {
+	if src.sameAs(dst) {
+		return img
+	}
+
+	// Таблицы: код → линейная яркость источника, линейная яркость → код назначения
+	var in [3][]float32
+	var out [3][]uint16
+	for ch := 0; ch < 3; ch++ {
+		in[ch] = make([]float32, iccInputLUT)
+		for i := range in[ch] {
+			in[ch][i] = float32(src.Curves[ch].eval(float64(i) / (iccInputLUT - 1)))
+		}
+		out[ch] = make([]uint16, iccOutputLUT)
+		for i, v := range dst.Curves[ch].inverseTable(iccOutputLUT) {
+			out[ch][i] = unitTo16(float32(v))
+		}
+	}
+
+	var m [3][3]float32
+	for r, row := range mul3(invert3(dst.Matrix), src.Matrix) {
+		for c, v := range row {
+			m[r][c] = float32(v)
+		}
+	}
+
+	b := img.Bounds()
+	// 16-битные изображения остаются 16-битными
+	deep := isDeep(img)
+	var res draw.Image = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	if deep {
+		res = image.NewNRGBA64(res.Bounds())
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := straightAt(img, b.Min.X+x, b.Min.Y+y)
+			lr, lg, lb := in[0][c.R], in[1][c.G], in[2][c.B]
+
+			var v [3]uint16
+			for ch := 0; ch < 3; ch++ {
+				l := m[ch][0]*lr + m[ch][1]*lg + m[ch][2]*lb
+				v[ch] = out[ch][int(clampF32(l, 0, 1)*(iccOutputLUT-1)+0.5)]
+			}
+			if deep {
+				res.Set(x, y, color.NRGBA64{v[0], v[1], v[2], c.A})
+				continue
+			}
+			// в 8 бит - с округлением, а не отбрасыванием младших разрядов
+			res.Set(x, y, color.NRGBA{round16to8(v[0]), round16to8(v[1]), round16to8(v[2]), uint8(c.A >> 8)})
+		}
+	}
+	return res
+}
+
+// encodeICC - профиль ICC v4 (монитор, RGB, PCS XYZ) для встраивания в результат
+func encodeICC(p *iccProfile) []byte {
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	xyzTag := func(v [3]float64) []byte {
+		b := []byte("XYZ \x00\x00\x00\x00")
+		for _, f := range v {
+			b = binary.BigEndian.AppendUint32(b, uint32(toS15Fixed16(f)))
+		}
+		return b
+	}
+	column := func(c int) [3]float64 {
+		return [3]float64{p.Matrix[0][c], p.Matrix[1][c], p.Matrix[2][c]}
+	}
+
+	tags := []tag{
+		{"desc", mlucTag(p.Name)},
+		{"cprt", mlucTag("No copyright, use freely")},
+		{"wtpt", xyzTag(iccD50)},
+		{"rXYZ", xyzTag(column(0))},
+		{"gXYZ", xyzTag(column(1))},
+		{"bXYZ", xyzTag(column(2))},
+		{"rTRC", curveTag(p.Curves[0])},
+		{"gTRC", curveTag(p.Curves[1])},
+		{"bTRC", curveTag(p.Curves[2])},
+	}
+
+	offset := 128 + 4 + 12*len(tags)
+	var table, body bytes.Buffer
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	for _, t := range tags {
+		for len(t.data)%4 != 0 {
+			t.data = append(t.data, 0)
+		}
+		table.WriteString(t.sig)
+		binary.Write(&table, binary.BigEndian, uint32(offset+body.Len()))
+		binary.Write(&table, binary.BigEndian, uint32(len(t.data)))
+		body.Write(t.data)
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(128+table.Len()+body.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x04300000) // версия 4.3
+	copy(header[12:], "mntrRGB XYZ ")
+	// дата создания фиксирована, чтобы результат был воспроизводимым
+	for i, v := range []uint16{2024, 1, 1, 0, 0, 0} {
+		binary.BigEndian.PutUint16(header[24+2*i:], v)
+	}
+	copy(header[36:], "acsp")
+	for i, v := range iccD50 {
+		binary.BigEndian.PutUint32(header[68+4*i:], uint32(toS15Fixed16(v)))
+	}
+
+	out := append(header, table.Bytes()...)
+	return append(out, body.Bytes()...)
+}
+
+// curveTag - кривая в виде тега para (или curv для таблицы)
+func curveTag(c toneCurve) []byte {
+	if c.Func < 0 {
+		b := []byte("curv\x00\x00\x00\x00")
+		b = binary.BigEndian.AppendUint32(b, uint32(len(c.Table)))
+		for _, v := range c.Table {
+			b = binary.BigEndian.AppendUint16(b, uint16(v*65535+0.5))
+		}
+		return b
+	}
+	b := []byte("para\x00\x00\x00\x00")
+	b = binary.BigEndian.AppendUint16(b, uint16(c.Func))
+	b = append(b, 0, 0)
+	for _, v := range c.Params {
+		b = binary.BigEndian.AppendUint32(b, uint32(toS15Fixed16(v)))
+	}
+	return b
+}
+
+// mlucTag - текст тега multiLocalizedUnicodeType (одна запись en-US)
+func mlucTag(s string) []byte {
+	text := utf16.Encode([]rune(s))
+	b := []byte("mluc\x00\x00\x00\x00")
+	b = binary.BigEndian.AppendUint32(b, 1)  // число записей
+	b = binary.BigEndian.AppendUint32(b, 12) // размер записи
+	b = append(b, "enUS"...)
+	b = binary.BigEndian.AppendUint32(b, uint32(2*len(text)))
+	b = binary.BigEndian.AppendUint32(b, 28)
+	for _, u := range text {
+		b = binary.BigEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// iccDescription - название профиля из тега desc (v2) или mluc (v4)
+func iccDescription(data []byte) string {
+	if len(data) < 12 {
+		return ""
+	}
+	switch string(data[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		if n > 0 && 12+n <= len(data) {
+			return strings.TrimRight(string(data[12:12+n]), "\x00")
+		}
+	case "mluc":
+		if len(data) < 28 {
+			return ""
+		}
+		n, off := int(binary.BigEndian.Uint32(data[20:])), int(binary.BigEndian.Uint32(data[24:]))
+		if off+n > len(data) {
+			return ""
+		}
+		u := make([]uint16, n/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(data[off+2*i:])
+		}
+		return string(utf16.Decode(u))
+	}
+	return ""
+}
+
+// iccColorSpace - цветовое пространство профиля ("RGB ", "CMYK", "GRAY"...)
+func iccColorSpace(data []byte) string {
+	if len(data) < 20 {
+		return ""
+	}
+	return string(data[16:20])
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func toS15Fixed16(v float64) int32 {
+	return int32(math.Round(v * 65536))
+}
+
+func mul3(a, b [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			for k := 0; k < 3; k++ {
+				m[r][c] += a[r][k] * b[k][c]
+			}
+		}
+	}
+	return m
+}
+
+func mulVec(a [3][3]float64, v [3]float64) [3]float64 {
+	var out [3]float64
+	for r := 0; r < 3; r++ {
+		out[r] = a[r][0]*v[0] + a[r][1]*v[1] + a[r][2]*v[2]
+	}
+	return out
+}
+
+func invert3(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	var inv [3][3]float64
+	inv[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	inv[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	inv[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	inv[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	inv[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	inv[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	inv[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	inv[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	inv[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+	return inv
+}
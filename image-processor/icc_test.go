@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// Опубликованные матрицы перевода линейного RGB в линейный sRGB (белая
+// точка у всех D65, адаптация не нужна)
+var (
+	adobeToSRGB = [3][3]float64{
+		{1.3983557, -0.3983557, 0},
+		{0, 1, 0},
+		{0, -0.0429289, 1.0429289},
+	}
+	p3ToSRGB = [3][3]float64{
+		{1.2249401, -0.2249404, 0},
+		{-0.0420569, 1.0420571, 0},
+		{-0.0196376, -0.0786361, 1.0982735},
+	}
+)
+
+// srgbEncode, srgbDecode - кривая sRGB по IEC 61966-2-1
+func srgbEncode(v float64) float64 {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// roundTripProfile - встроенный профиль после записи в ICC и разбора
+func roundTripProfile(t *testing.T, name string) *iccProfile {
+	t.Helper()
+	p, err := parseICC(encodeICC(builtinProfiles[name]))
+	if err != nil {
+		t.Fatalf("parseICC(%s): %v", name, err)
+	}
+	return p
+}
+
+func TestICCMatrixToSRGB(t *testing.T) {
+	srgb := builtinProfiles["srgb"]
+	tests := []struct {
+		profile string
+		want    [3][3]float64
+	}{
+		{"adobe-rgb", adobeToSRGB},
+		{"display-p3", p3ToSRGB},
+		{"srgb", [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			src := roundTripProfile(t, tt.profile)
+			got := mul3(invert3(srgb.Matrix), src.Matrix)
+			for r := 0; r < 3; r++ {
+				for c := 0; c < 3; c++ {
+					if math.Abs(got[r][c]-tt.want[r][c]) > 1e-3 {
+						t.Errorf("[%d][%d] = %.5f, ожидается %.5f", r, c, got[r][c], tt.want[r][c])
+					}
+				}
+			}
+
+			// белый остается белым
+			white := mulVec(got, [3]float64{1, 1, 1})
+			for c, v := range white {
+				if math.Abs(v-1) > 1e-3 {
+					t.Errorf("белый: канал %d = %.5f", c, v)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertProfilePixels(t *testing.T) {
+	adobeGamma := 563.0 / 256
+	tests := []struct {
+		profile string
+		matrix  [3][3]float64
+		decode  func(float64) float64
+	}{
+		{"adobe-rgb", adobeToSRGB, func(v float64) float64 { return math.Pow(v, adobeGamma) }},
+		{"display-p3", p3ToSRGB, srgbDecode},
+	}
+	colors := []color.NRGBA{
+		{255, 255, 255, 255},
+		{0, 0, 0, 255},
+		{128, 128, 128, 255},
+		{200, 100, 50, 255},
+		{60, 180, 90, 128},
+		{255, 0, 0, 255},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, len(colors), 1))
+			for i, c := range colors {
+				img.SetNRGBA(i, 0, c)
+			}
+			out := convertProfile(img, roundTripProfile(t, tt.profile), builtinProfiles["srgb"])
+
+			for i, c := range colors {
+				lin := mulVec(tt.matrix, [3]float64{
+					tt.decode(float64(c.R) / 255),
+					tt.decode(float64(c.G) / 255),
+					tt.decode(float64(c.B) / 255),
+				})
+				got := color.NRGBAModel.Convert(out.At(i, 0)).(color.NRGBA)
+				for ch, v := range []uint8{got.R, got.G, got.B} {
+					want := srgbEncode(lin[ch]) * 255
+					if math.Abs(float64(v)-want) > 1 {
+						t.Errorf("%v: канал %d = %d, ожидается %.1f", c, ch, v, want)
+					}
+				}
+				if got.A != c.A {
+					t.Errorf("%v: альфа %d", c, got.A)
+				}
+			}
+		})
+	}
+}
+
+// curvTag - тег curv из n значений
+func curvTag(values ...uint16) []byte {
+	b := []byte("curv\x00\x00\x00\x00")
+	b = binary.BigEndian.AppendUint32(b, uint32(len(values)))
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func TestParseCurve(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  []byte
+		want func(float64) float64
+	}{
+		{"curv identity", curvTag(), func(v float64) float64 { return v }},
+		{"curv gamma", curvTag(563), func(v float64) float64 { return math.Pow(v, 563.0/256) }},
+		{"curv sampled", curvTag(0, 0x4000, 0xffff), func(v float64) float64 {
+			// отрезки (0, 0)-(0.5, mid)-(1, 1)
+			mid := float64(0x4000) / 0xffff
+			if v < 0.5 {
+				return v * 2 * mid
+			}
+			return mid + (v-0.5)*2*(1-mid)
+		}},
+		{"para gamma", curveTag(toneCurve{Func: 0, Params: []float64{1.8}}), func(v float64) float64 { return math.Pow(v, 1.8) }},
+		{"para srgb", curveTag(srgbCurve), srgbDecode},
+		{"para type 1", curveTag(toneCurve{Func: 1, Params: []float64{2.2, 1.25, -0.25}}), func(v float64) float64 {
+			if v < 0.2 {
+				return 0
+			}
+			return math.Pow(1.25*v-0.25, 2.2)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCurve(tt.tag)
+			if err != nil {
+				t.Fatalf("parseCurve: %v", err)
+			}
+			for i := 0; i <= 20; i++ {
+				x := float64(i) / 20
+				if got, want := c.eval(x), tt.want(x); math.Abs(got-want) > 1e-4 {
+					t.Errorf("eval(%g) = %.6f, ожидается %.6f", x, got, want)
+				}
+			}
+
+			// обратная таблица возвращает исходный код
+			inv := c.inverseTable(4096)
+			for _, x := range []float64{0.3, 0.5, 0.8, 1} {
+				y := c.eval(x)
+				if got := inv[int(y*4095+0.5)]; math.Abs(got-x) > 0.01 {
+					t.Errorf("обратная кривая: %.4f → %.4f, ожидается %.4f", y, got, x)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertSRGBToSRGBIsNoop(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.SetNRGBA(1, 1, color.NRGBA{12, 34, 56, 78})
+	srgb := builtinProfiles["srgb"]
+
+	if out := convertProfile(img, srgb, srgb); out != image.Image(img) {
+		t.Error("sRGB → sRGB: изображение скопировано")
+	}
+	// встроенный sRGB-профиль, записанный и разобранный заново, - тоже sRGB
+	if out := convertProfile(img, roundTripProfile(t, "srgb"), srgb); out != image.Image(img) {
+		t.Error("sRGB (ICC) → sRGB: изображение скопировано")
+	}
+	// без встроенного профиля изображение считается sRGB
+	out, _, converted := manageColor(img, nil, srgb, newRunContext())
+	if out != image.Image(img) || !converted {
+		t.Error("manageColor без профиля должен вернуть исходное изображение")
+	}
+}
+
+func TestParseICCMalformed(t *testing.T) {
+	valid := encodeICC(builtinProfiles["srgb"])
+	// patched - копия профиля с s по смещению off
+	patched := func(off int, s string) []byte {
+		b := append([]byte(nil), valid...)
+		copy(b[off:], s)
+		return b
+	}
+	// withTag - копия профиля, в которой к записи тега sig применено edit
+	withTag := func(sig string, edit func(b, entry []byte)) []byte {
+		b := append([]byte(nil), valid...)
+		count := int(binary.BigEndian.Uint32(b[128:]))
+		for i := 0; i < count; i++ {
+			if e := b[132+i*12:]; string(e[:4]) == sig {
+				edit(b, e)
+			}
+		}
+		return b
+	}
+	withoutTag := func(sig string) []byte {
+		return withTag(sig, func(_, e []byte) { copy(e, "zzzz") })
+	}
+	badCurve := withTag("gTRC", func(b, e []byte) {
+		copy(b[binary.BigEndian.Uint32(e[4:]):], "junk") // неизвестный тип кривой
+	})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"header only", valid[:100]},
+		{"truncated tags", valid[:len(valid)-20]},
+		{"no signature", patched(36, "xxxx")},
+		{"cmyk", patched(16, "CMYK")},
+		{"no rTRC", withoutTag("rTRC")},
+		{"bad curve", badCurve},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseICC(tt.data); err == nil {
+				t.Error("ожидается ошибка")
+			}
+		})
+	}
+
+	// испорченный профиль: пиксели не меняются, ответ помечается
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	rc := newRunContext()
+	out, _, converted := manageColor(img, valid[:100], builtinProfiles["srgb"], rc)
+	if out != image.Image(img) || converted {
+		t.Error("manageColor с испорченным профилем изменил изображение")
+	}
+	if rc.Header.Get(unmanagedHeader) != "unmanaged" {
+		t.Errorf("заголовок %s не выставлен", unmanagedHeader)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 3D LUT (.cube): загруженные таблицы хранятся в lutDir и доступны как
+// фильтры "lut:<имя>" наравне со встроенными
+
+const (
+	lutDir       = "luts"
+	lutPrefix    = "lut:" // префикс id фильтра
+	maxLUTSize   = 65     // наибольшая сторона таблицы
+	minLUTSize   = 2
+	maxLUTNameLn = 64
+)
+
+// lutInterpolations - способы интерполяции между узлами таблицы
+var lutInterpolations = []string{"tetrahedral", "trilinear"}
+
+// lutNamePattern - допустимое имя LUT (оно же имя файла)
+var lutNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// cubeLUT - разобранная таблица .cube
+type cubeLUT struct {
+	title    string
+	size     int
+	min, max [3]float32 // DOMAIN_MIN, DOMAIN_MAX
+	table    []float32  // size³ троек RGB, быстрее всего меняется R
+}
+
+// lutStore - загруженные таблицы; загрузка новых идет параллельно с обработкой
+var lutStore = struct {
+	sync.RWMutex
+	luts map[string]*cubeLUT
+}{luts: map[string]*cubeLUT{}}
+
+// lookupLUT - таблица по имени (nil, если нет)
+func lookupLUT(name string) *cubeLUT {
+	lutStore.RLock()
+	defer lutStore.RUnlock()
+	return lutStore.luts[name]
+}
+
+// lutFilters - фильтры всех загруженных таблиц по алфавиту
+func lutFilters() []Filter {
+	lutStore.RLock()
+	defer lutStore.RUnlock()
+	names := make([]string, 0, len(lutStore.luts))
+	for name := range lutStore.luts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	filters := make([]Filter, len(names))
+	for i, name := range names {
+		filters[i] = lutStore.luts[name].filter(name)
+	}
+	return filters
+}
+
+// filter - таблица как фильтр "lut:<name>"
+func (l *cubeLUT) filter(name string) Filter {
+	title := l.title
+	if title == "" {
+		title = name
+	}
+	return newFilter(lutPrefix+name, "LUT: "+title, "🎞️",
+		[]filterParam{choiceParam("interpolation", "Интерполяция", lutInterpolations, 0)},
+		func(img image.Image, p map[string]float64) image.Image {
+			if lutInterpolations[int(p["interpolation"])] == "trilinear" {
+				return mapPixels(img, l.trilinear)
+			}
+			return mapPixels(img, l.tetrahedral)
+		})
+}
+
+// loadLUTs - загрузка сохраненных таблиц при запуске; испорченные файлы
+// пропускаются с сообщением в журнал
+func loadLUTs() {
+	paths, _ := filepath.Glob(filepath.Join(lutDir, "*.cube"))
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".cube")
+		lut, err := readLUT(name, path)
+		if err != nil {
+			fmt.Printf("[LUT] %s: %v - пропущен\n", path, err)
+			continue
+		}
+		storeLUT(name, lut)
+	}
+}
+
+// readLUT - таблица из файла path
+func readLUT(name, path string) (*cubeLUT, error) {
+	if err := checkLUTName(name); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCube(data)
+}
+
+// checkLUTName - проверка имени таблицы (оно же имя файла в lutDir)
+func checkLUTName(name string) error {
+	if len(name) > maxLUTNameLn || !lutNamePattern.MatchString(name) {
+		return fmt.Errorf("имя LUT: латинские буквы в нижнем регистре, цифры, - и _ (до %d символов)", maxLUTNameLn)
+	}
+	return nil
+}
+
+// lutPath - файл таблицы в lutDir
+func lutPath(name string) string {
+	return filepath.Join(lutDir, name+".cube")
+}
+
+// storeLUT - добавление таблицы; таблица с тем же именем заменяется
+func storeLUT(name string, lut *cubeLUT) {
+	lutStore.Lock()
+	lutStore.luts[name] = lut
+	lutStore.Unlock()
+}
+
+// parseCube - разбор формата .cube (Adobe/Resolve): TITLE, LUT_3D_SIZE,
+// DOMAIN_MIN/MAX и size³ строк "R G B"
+func parseCube(data []byte) (*cubeLUT, error) {
+	lut := &cubeLUT{max: [3]float32{1, 1, 1}}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+
+		switch fields[0] {
+		case "TITLE":
+			lut.title = strings.Trim(strings.TrimSpace(strings.TrimPrefix(text, "TITLE")), `"`)
+			continue
+		case "LUT_3D_SIZE":
+			n := 0
+			if len(fields) == 2 {
+				n, _ = strconv.Atoi(fields[1])
+			}
+			if n < minLUTSize || n > maxLUTSize {
+				return nil, fmt.Errorf("строка %d: LUT_3D_SIZE от %d до %d", line, minLUTSize, maxLUTSize)
+			}
+			lut.size = n
+			lut.table = make([]float32, 0, n*n*n*3)
+			continue
+		case "LUT_1D_SIZE":
+			return nil, errors.New("поддерживаются только 3D LUT")
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			v, err := parseTriple(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("строка %d: %s: %v", line, fields[0], err)
+			}
+			if fields[0] == "DOMAIN_MIN" {
+				lut.min = v
+			} else {
+				lut.max = v
+			}
+			continue
+		}
+
+		if fields[0][0] >= 'A' && fields[0][0] <= 'Z' {
+			continue // прочие ключевые слова (LUT_3D_INPUT_RANGE и т.п.) не нужны
+		}
+		if lut.size == 0 {
+			return nil, fmt.Errorf("строка %d: данные до LUT_3D_SIZE", line)
+		}
+		v, err := parseTriple(fields)
+		if err != nil {
+			return nil, fmt.Errorf("строка %d: %v", line, err)
+		}
+		if len(lut.table) == cap(lut.table) {
+			return nil, fmt.Errorf("строка %d: лишние строки данных", line)
+		}
+		lut.table = append(lut.table, v[:]...)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения LUT: %v", err)
+	}
+
+	if lut.size == 0 {
+		return nil, errors.New("не найден LUT_3D_SIZE")
+	}
+	if len(lut.table) != cap(lut.table) {
+		return nil, fmt.Errorf("ожидается %d строк данных, найдено %d", lut.size*lut.size*lut.size, len(lut.table)/3)
+	}
+	for c := 0; c < 3; c++ {
+		if lut.max[c] <= lut.min[c] {
+			return nil, errors.New("DOMAIN_MAX должен быть больше DOMAIN_MIN")
+		}
+	}
+	return lut, nil
+}
+
+// parseTriple - три конечных числа (NaN и Inf ParseFloat принимает, а в
+// таблице они испортили бы все пиксели своей ячейки)
+func parseTriple(fields []string) ([3]float32, error) {
+	var v [3]float32
+	if len(fields) != 3 {
+		return v, errors.New("ожидается три числа")
+	}
+	for i, f := range fields {
+		x, err := strconv.ParseFloat(f, 32)
+		if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+			return v, fmt.Errorf("неверное число %q", f)
+		}
+		v[i] = float32(x)
+	}
+	return v, nil
+}
+
+// cell - узел таблицы (нижний) и дробная часть по каждой оси для цвета r, g, b
+func (l *cubeLUT) cell(r, g, b float32) (base [3]int, frac [3]float32) {
+	last := float32(l.size - 1)
+	for c, v := range [3]float32{r, g, b} {
+		pos := clampF32((v-l.min[c])/(l.max[c]-l.min[c]), 0, 1) * last
+		i := min(int(pos), l.size-2)
+		base[c], frac[c] = i, pos-float32(i)
+	}
+	return base, frac
+}
+
+// node - значение узла base, сдвинутого на dr, dg, db
+func (l *cubeLUT) node(base [3]int, dr, dg, db int) []float32 {
+	i := (((base[2]+db)*l.size+base[1]+dg)*l.size + base[0] + dr) * 3
+	return l.table[i : i+3]
+}
+
+// trilinear - интерполяция по 8 узлам куба
+func (l *cubeLUT) trilinear(r, g, b float32) (float32, float32, float32) {
+	base, f := l.cell(r, g, b)
+	var out [3]float32
+	for db := 0; db < 2; db++ {
+		wb := f[2]
+		if db == 0 {
+			wb = 1 - wb
+		}
+		for dg := 0; dg < 2; dg++ {
+			wg := f[1]
+			if dg == 0 {
+				wg = 1 - wg
+			}
+			for dr := 0; dr < 2; dr++ {
+				wr := f[0]
+				if dr == 0 {
+					wr = 1 - wr
+				}
+				n, w := l.node(base, dr, dg, db), wr*wg*wb
+				out[0] += n[0] * w
+				out[1] += n[1] * w
+				out[2] += n[2] * w
+			}
+		}
+	}
+	return out[0], out[1], out[2]
+}
+
+// tetrahedral - интерполяция по 4 узлам тетраэдра, в который попадает цвет
+// (точнее трилинейной на нейтральных тонах, серый остается серым)
+func (l *cubeLUT) tetrahedral(r, g, b float32) (float32, float32, float32) {
+	base, f := l.cell(r, g, b)
+	fr, fg, fb := f[0], f[1], f[2]
+
+	// узлы пути от (0,0,0) к (1,1,1) по убыванию дробных частей и их веса
+	var path [2][3]int
+	var w [4]float32
+	switch {
+	case fr >= fg && fg >= fb:
+		path, w = [2][3]int{{1, 0, 0}, {1, 1, 0}}, [4]float32{1 - fr, fr - fg, fg - fb, fb}
+	case fr >= fb && fb >= fg:
+		path, w = [2][3]int{{1, 0, 0}, {1, 0, 1}}, [4]float32{1 - fr, fr - fb, fb - fg, fg}
+	case fb >= fr && fr >= fg:
+		path, w = [2][3]int{{0, 0, 1}, {1, 0, 1}}, [4]float32{1 - fb, fb - fr, fr - fg, fg}
+	case fb >= fg && fg >= fr:
+		path, w = [2][3]int{{0, 0, 1}, {0, 1, 1}}, [4]float32{1 - fb, fb - fg, fg - fr, fr}
+	case fg >= fb && fb >= fr:
+		path, w = [2][3]int{{0, 1, 0}, {0, 1, 1}}, [4]float32{1 - fg, fg - fb, fb - fr, fr}
+	default: // fg >= fr >= fb
+		path, w = [2][3]int{{0, 1, 0}, {1, 1, 0}}, [4]float32{1 - fg, fg - fr, fr - fb, fb}
+	}
+
+	nodes := [4][]float32{
+		l.node(base, 0, 0, 0),
+		l.node(base, path[0][0], path[0][1], path[0][2]),
+		l.node(base, path[1][0], path[1][1], path[1][2]),
+		l.node(base, 1, 1, 1),
+	}
+	var out [3]float32
+	for i, n := range nodes {
+		out[0] += n[0] * w[i]
+		out[1] += n[1] * w[i]
+		out[2] += n[2] * w[i]
+	}
+	return out[0], out[1], out[2]
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// identityCube - тождественная таблица .cube стороны n
+func identityCube(n int, header string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TITLE \"Identity\"\n# комментарий\nLUT_3D_SIZE %d\n%s", n, header)
+	for bi := 0; bi < n; bi++ {
+		for gi := 0; gi < n; gi++ {
+			for ri := 0; ri < n; ri++ {
+				d := float64(n - 1)
+				fmt.Fprintf(&b, "%g %g %g\n", float64(ri)/d, float64(gi)/d, float64(bi)/d)
+			}
+		}
+	}
+	return b.String()
+}
+
+func TestParseCube(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"identity", identityCube(3, ""), ""},
+		{"domain", identityCube(2, "DOMAIN_MIN 0 0 0\nDOMAIN_MAX 2 2 2\nLUT_3D_INPUT_RANGE 0 1\n"), ""},
+		{"nan domain", identityCube(2, "DOMAIN_MIN NaN 0 0\n"), "DOMAIN_MIN"},
+		{"inf domain", identityCube(2, "DOMAIN_MAX 1 +Inf 1\n"), "DOMAIN_MAX"},
+		{"nan data", "LUT_3D_SIZE 2\n" + strings.Repeat("0 0 0\n", 7) + "nan 0 0\n", "строка 9"},
+		{"inf data", "LUT_3D_SIZE 2\n-inf 0 0\n" + strings.Repeat("0 0 0\n", 7), "строка 2"},
+		{"overflow", "LUT_3D_SIZE 2\n1e39 0 0\n" + strings.Repeat("0 0 0\n", 7), "строка 2"},
+		{"bad number", "LUT_3D_SIZE 2\n0 x 0\n", "неверное число"},
+		{"two numbers", "LUT_3D_SIZE 2\n0 0\n", "три числа"},
+		{"size too small", "LUT_3D_SIZE 1\n0 0 0\n", "LUT_3D_SIZE от"},
+		{"size too big", "LUT_3D_SIZE 66\n", "LUT_3D_SIZE от"},
+		{"no size", "0 0 0\n", "до LUT_3D_SIZE"},
+		{"empty", "", "не найден"},
+		{"1d", "LUT_1D_SIZE 16\n", "только 3D"},
+		{"too few rows", "LUT_3D_SIZE 2\n0 0 0\n", "ожидается 8 строк"},
+		{"too many rows", identityCube(2, "") + "0 0 0\n", "лишние"},
+		{"inverted domain", identityCube(2, "DOMAIN_MIN 1 0 0\nDOMAIN_MAX 0 1 1\n"), "DOMAIN_MAX"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lut, err := parseCube([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидается с %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCube: %v", err)
+			}
+			if lut.title != "Identity" || len(lut.table) != lut.size*lut.size*lut.size*3 {
+				t.Errorf("title %q, %d значений при size %d", lut.title, len(lut.table), lut.size)
+			}
+		})
+	}
+}
+
+func TestLUTInterpolation(t *testing.T) {
+	lut, err := parseCube([]byte(identityCube(5, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := [][3]float32{{0, 0, 0}, {1, 1, 1}, {0.3, 0.7, 0.1}, {0.9, 0.2, 0.55}, {0.5, 0.5, 0.5}}
+	for _, c := range colors {
+		for name, interp := range map[string]func(r, g, b float32) (float32, float32, float32){
+			"tetrahedral": lut.tetrahedral,
+			"trilinear":   lut.trilinear,
+		} {
+			r, g, b := interp(c[0], c[1], c[2])
+			if math.Abs(float64(r-c[0])) > 1e-5 || math.Abs(float64(g-c[1])) > 1e-5 || math.Abs(float64(b-c[2])) > 1e-5 {
+				t.Errorf("%s%v = (%g, %g, %g), ожидается без изменений", name, c, r, g, b)
+			}
+		}
+	}
+
+	// за пределами DOMAIN цвет берется с края таблицы
+	if r, g, b := lut.tetrahedral(-0.5, 1.5, 0.5); r != 0 || g != 1 || b != 0.5 {
+		t.Errorf("вне диапазона: (%g, %g, %g)", r, g, b)
+	}
+}
+
+func TestCheckLUTName(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"film-01":               true,
+		"a_b":                   true,
+		"Film":                  false,
+		"-film":                 false,
+		"../etc":                false,
+		"":                      false,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+		"имя":                   false,
+	} {
+		if err := checkLUTName(name); (err == nil) != ok {
+			t.Errorf("checkLUTName(%q) = %v", name, err)
+		}
+	}
+}
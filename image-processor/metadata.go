@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"regexp"
+	"strings"
+)
+
+// Политики метаданных (поле формы metadata)
+const (
+	metadataStripAll      = "strip-all"                // ничего не переносить
+	metadataKeepAll       = "keep-all"                 // перенести все, что умеет формат результата
+	metadataKeepCopyright = "keep-copyright-and-color" // только авторство и ICC-профиль
+	metadataStripGPS      = "strip-gps"                // все, кроме координат
+)
+
+// parseMetadataPolicy - проверка политики ("" - strip-all)
+func parseMetadataPolicy(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "":
+		return metadataStripAll, nil
+	case metadataStripAll, metadataKeepAll, metadataKeepCopyright, metadataStripGPS:
+		return s, nil
+	}
+	return "", fmt.Errorf("metadata: ожидается %s, %s, %s или %s, получено %q",
+		metadataStripAll, metadataKeepAll, metadataKeepCopyright, metadataStripGPS, s)
+}
+
+// imageMeta - метаданные в независимом от контейнера виде
+type imageMeta struct {
+	EXIF []byte      // TIFF-структура (без префикса "Exif\0\0")
+	XMP  []byte      // XML-пакет
+	ICC  []byte      // ICC-профиль
+	IPTC []byte      // записи IPTC-IIM (без обертки Photoshop)
+	Text []fileChunk // текстовые чанки PNG (tEXt, zTXt, iTXt), кроме XMP
+}
+
+// tiffStructureTags - теги IFD0, описывающие пиксельные данные исходного TIFF.
+// В результат они не переносятся: у него свое кодирование.
+var tiffStructureTags = map[uint16]bool{
+	0x0100: true, 0x0101: true, 0x0102: true, 0x0103: true, // размеры, глубина, сжатие
+	0x0106: true, 0x010a: true, 0x0111: true, 0x0115: true, // фотометрия, FillOrder, StripOffsets, SamplesPerPixel
+	0x0116: true, 0x0117: true, 0x011c: true, 0x013d: true, // RowsPerStrip, StripByteCounts, PlanarConfig, Predictor
+	0x0140: true, 0x0142: true, 0x0143: true, 0x0144: true, // ColorMap, тайлы
+	0x0145: true, 0x014a: true, 0x0152: true, 0x0153: true, // TileByteCounts, SubIFDs, ExtraSamples, SampleFormat
+	0x015b: true, 0x0201: true, 0x0202: true, // JPEGTables, JPEG-миниатюра
+	0x02bc: true, 0x83bb: true, 0x8649: true, 0x8773: true, // XMP, IPTC, Photoshop, ICC - переносятся отдельно
+}
+
+// iptcCopyrightDatasets - наборы IPTC, которые сохраняет keep-copyright-and-color
+var iptcCopyrightDatasets = map[[2]byte]bool{
+	{1, 90}:  true, // кодировка
+	{2, 0}:   true, // версия записи
+	{2, 80}:  true, // By-line (автор)
+	{2, 85}:  true, // By-line Title
+	{2, 110}: true, // Credit
+	{2, 115}: true, // Source
+	{2, 116}: true, // Copyright Notice
+}
+
+// pngCopyrightKeywords - ключевые слова текстовых чанков PNG об авторстве
+var pngCopyrightKeywords = map[string]bool{"Author": true, "Copyright": true}
+
+// withPolicy - метаданные для результата по политике policy.
+// oriented - пиксели уже повернуты по EXIF, тег Orientation сбрасывается в 1;
+// size - размер результата для PixelXDimension/PixelYDimension.
+func (m *imageMeta) withPolicy(policy string, oriented bool, size image.Point) *imageMeta {
+	out := &imageMeta{}
+	if m == nil || policy == metadataStripAll {
+		return out
+	}
+	out.ICC = m.ICC
+
+	if exif := parseExif(m.EXIF); exif != nil {
+		exif.filter(func(parent, tag uint16) bool {
+			// MakerNote хранит смещения относительно исходного файла и после
+			// переупаковки становится некорректным
+			if tag == exifTagMakerNote || parent == 0 && tiffStructureTags[tag] {
+				return false
+			}
+			switch policy {
+			case metadataStripGPS:
+				return tag != exifTagGPSIFD
+			case metadataKeepCopyright:
+				if parent == 0 {
+					return tag == exifTagArtist || tag == exifTagCopyright || tag == exifTagExifIFD
+				}
+				return parent == exifTagExifIFD && tag == exifTagColorSpace
+			}
+			return true
+		})
+		if oriented {
+			exif.setUint(0, exifTagOrientation, 1)
+		}
+		exif.setUint(exifTagExifIFD, exifTagPixelX, uint32(size.X))
+		exif.setUint(exifTagExifIFD, exifTagPixelY, uint32(size.Y))
+		if !exif.empty() {
+			out.EXIF = exif.bytes()
+		}
+	}
+
+	switch policy {
+	case metadataKeepAll:
+		out.XMP, out.IPTC, out.Text = m.XMP, m.IPTC, m.Text
+	case metadataStripGPS:
+		out.XMP, out.IPTC, out.Text = stripXMPGPS(m.XMP), m.IPTC, m.Text
+	case metadataKeepCopyright:
+		out.XMP = copyrightXMP(m.XMP)
+		out.IPTC = filterIPTC(m.IPTC, iptcCopyrightDatasets)
+		for _, c := range m.Text {
+			if pngCopyrightKeywords[c.keyword()] {
+				out.Text = append(out.Text, c)
+			}
+		}
+	}
+	if oriented && out.XMP != nil {
+		out.XMP = normalizeXMPOrientation(out.XMP)
+	}
+	return out
+}
+
+// XMP правится регулярными выражениями: полноценный разбор RDF здесь не нужен,
+// а пространства имен на практике всегда записываются с префиксами exif:, tiff:, dc:
+var (
+	xmpGPSAttr         = regexp.MustCompile(`\s+exif:GPS\w*=("[^"]*"|'[^']*')`)
+	xmpGPSElement      = regexp.MustCompile(`(?s)<exif:GPS\w*(\s[^>]*)?(/>|>.*?</exif:GPS\w*>)`)
+	xmpOrientationAttr = regexp.MustCompile(`tiff:Orientation=("\d"|'\d')`)
+	xmpOrientationElem = regexp.MustCompile(`<tiff:Orientation>\s*\d\s*</tiff:Orientation>`)
+	xmpRightsElements  = []*regexp.Regexp{
+		regexp.MustCompile(`(?s)<dc:rights\b.*?</dc:rights>`),
+		regexp.MustCompile(`(?s)<dc:creator\b.*?</dc:creator>`),
+		regexp.MustCompile(`(?s)<xmpRights:\w+(\s[^>]*)?>.*?</xmpRights:\w+>`),
+	}
+	xmpRightsAttr = regexp.MustCompile(`\sxmpRights:\w+=("[^"]*"|'[^']*')`)
+)
+
+// stripXMPGPS - XMP без координат (свойства exif:GPS*)
+func stripXMPGPS(xmp []byte) []byte {
+	if xmp == nil {
+		return nil
+	}
+	xmp = xmpGPSAttr.ReplaceAll(xmp, nil)
+	return xmpGPSElement.ReplaceAll(xmp, nil)
+}
+
+// normalizeXMPOrientation - tiff:Orientation = 1
+func normalizeXMPOrientation(xmp []byte) []byte {
+	xmp = xmpOrientationAttr.ReplaceAll(xmp, []byte(`tiff:Orientation="1"`))
+	return xmpOrientationElem.ReplaceAll(xmp, []byte(`<tiff:Orientation>1</tiff:Orientation>`))
+}
+
+// copyrightXMP - новый XMP-пакет только с dc:rights, dc:creator и xmpRights:*
+func copyrightXMP(xmp []byte) []byte {
+	if xmp == nil {
+		return nil
+	}
+	var attrs, elems []string
+	for _, re := range xmpRightsElements {
+		for _, m := range re.FindAll(xmp, -1) {
+			elems = append(elems, string(m))
+		}
+	}
+	for _, m := range xmpRightsAttr.FindAll(xmp, -1) {
+		attrs = append(attrs, string(m))
+	}
+	if len(attrs) == 0 && len(elems) == 0 {
+		return nil
+	}
+
+	return []byte(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` +
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/">` +
+		`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description rdf:about=""` +
+		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
+		` xmlns:xmpRights="http://ns.adobe.com/xap/1.0/rights/"` +
+		strings.Join(attrs, "") + `>` +
+		strings.Join(elems, "") +
+		`</rdf:Description></rdf:RDF></x:xmpmeta><?xpacket end="w"?>`)
+}
+
+// filterIPTC - только наборы данных IPTC-IIM из keep; nil, если из
+// содержательных не осталось ни одного
+func filterIPTC(iim []byte, keep map[[2]byte]bool) []byte {
+	var out []byte
+	content := false
+	for p := 0; p+5 <= len(iim) && iim[p] == 0x1c; {
+		n := int(binary.BigEndian.Uint16(iim[p+3:]))
+		head := 5
+		if n&0x8000 != 0 {
+			// расширенная длина: n&0x7fff байт размера
+			k := n & 0x7fff
+			if k > 4 || p+5+k > len(iim) {
+				break
+			}
+			n = 0
+			for _, b := range iim[p+5 : p+5+k] {
+				n = n<<8 | int(b)
+			}
+			head += k
+		}
+		if p+head+n > len(iim) {
+			break
+		}
+		rec, ds := iim[p+1], iim[p+2]
+		if keep[[2]byte{rec, ds}] {
+			out = append(out, iim[p:p+head+n]...)
+			content = content || rec == 2 && ds != 0
+		}
+		p += head + n
+	}
+	if !content {
+		return nil
+	}
+	return out
+}
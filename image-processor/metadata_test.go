@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sampleExif - EXIF с ориентацией, авторством, камерой, Exif IFD и GPS
+func sampleExif() []byte {
+	le := binary.LittleEndian
+	short := func(tag uint16, v uint16) exifEntry {
+		return exifEntry{Tag: tag, Type: 3, Count: 1, Value: le.AppendUint16(nil, v)}
+	}
+	ascii := func(tag uint16, s string) exifEntry {
+		return exifEntry{Tag: tag, Type: 2, Count: uint32(len(s) + 1), Value: append([]byte(s), 0)}
+	}
+	e := &exifData{order: le, ifd0: &exifIFD{Entries: []exifEntry{
+		short(exifTagOrientation, 6),
+		ascii(0x010f, "Camera Maker"),
+		ascii(exifTagArtist, "Ann Author"),
+		ascii(exifTagCopyright, "(c) Ann"),
+		short(0x0100, 4000), // ImageWidth - структура исходного файла
+		{Tag: exifTagExifIFD, Type: 4, Count: 1, Value: make([]byte, 4), Sub: &exifIFD{Entries: []exifEntry{
+			short(exifTagColorSpace, 1),
+			short(exifTagPixelX, 4000),
+			short(exifTagPixelY, 3000),
+			ascii(0x9003, "2024:01:02 03:04:05"),
+			{Tag: exifTagMakerNote, Type: 7, Count: 8, Value: []byte("vendor!!")},
+		}}},
+		{Tag: exifTagGPSIFD, Type: 4, Count: 1, Value: make([]byte, 4), Sub: &exifIFD{Entries: []exifEntry{
+			ascii(0x0001, "N"),
+		}}},
+	}}}
+	return e.bytes()
+}
+
+// exifTags - теги EXIF в виде "каталог:тег=значение" (числа SHORT/LONG)
+func exifTags(tiff []byte) []string {
+	e := parseExif(tiff)
+	if e == nil {
+		return nil
+	}
+	var tags []string
+	var walk func(ifd *exifIFD, parent uint16)
+	walk = func(ifd *exifIFD, parent uint16) {
+		for _, en := range ifd.Entries {
+			s := fmt.Sprintf("%04x:%04x", parent, en.Tag)
+			switch {
+			case en.Sub != nil:
+				walk(en.Sub, en.Tag)
+			case en.Type == 3:
+				s += fmt.Sprintf("=%d", e.order.Uint16(en.Value))
+			case en.Type == 4:
+				s += fmt.Sprintf("=%d", e.order.Uint32(en.Value))
+			}
+			tags = append(tags, s)
+		}
+	}
+	walk(e.ifd0, 0)
+	sort.Strings(tags)
+	return tags
+}
+
+func TestParseMetadataPolicy(t *testing.T) {
+	for in, want := range map[string]string{
+		"": metadataStripAll, " Keep-All ": metadataKeepAll, "strip-gps": metadataStripGPS,
+		"keep-copyright-and-color": metadataKeepCopyright, "keep": "",
+	} {
+		got, err := parseMetadataPolicy(in)
+		if (err != nil) != (want == "") || got != want {
+			t.Errorf("parseMetadataPolicy(%q) = %q, %v", in, got, err)
+		}
+	}
+}
+
+func TestMetadataPolicyEXIF(t *testing.T) {
+	src := &imageMeta{EXIF: sampleExif(), ICC: []byte("icc")}
+	size := image.Pt(300, 400)
+	tests := []struct {
+		policy   string
+		oriented bool
+		want     string
+	}{
+		{metadataStripAll, true, ""},
+		{metadataKeepAll, true, "0000:010f 0000:0112=1 0000:013b 0000:8298 0000:8769 0000:8825 8769:9003 8769:a001=1 8769:a002=300 8769:a003=400 8825:0001"},
+		{metadataKeepAll, false, "0000:010f 0000:0112=6 0000:013b 0000:8298 0000:8769 0000:8825 8769:9003 8769:a001=1 8769:a002=300 8769:a003=400 8825:0001"},
+		{metadataStripGPS, true, "0000:010f 0000:0112=1 0000:013b 0000:8298 0000:8769 8769:9003 8769:a001=1 8769:a002=300 8769:a003=400"},
+		{metadataKeepCopyright, true, "0000:013b 0000:8298 0000:8769 8769:a001=1"},
+	}
+	for _, tt := range tests {
+		out := src.withPolicy(tt.policy, tt.oriented, size)
+		if got := strings.Join(exifTags(out.EXIF), " "); got != tt.want {
+			t.Errorf("%s (oriented=%v):\n получено  %s\n ожидается %s", tt.policy, tt.oriented, got, tt.want)
+		}
+		if (out.ICC != nil) != (tt.policy != metadataStripAll) {
+			t.Errorf("%s: ICC %q", tt.policy, out.ICC)
+		}
+	}
+
+	if out := (*imageMeta)(nil).withPolicy(metadataKeepAll, true, size); out == nil || out.EXIF != nil {
+		t.Errorf("нет метаданных: %+v", out)
+	}
+}
+
+func TestMetadataPolicyXMP(t *testing.T) {
+	xmp := []byte(`<x:xmpmeta><rdf:Description exif:GPSLatitude="55,45N" tiff:Orientation="6" xmpRights:Marked="True">` +
+		`<exif:GPSLongitude>37,37E</exif:GPSLongitude><dc:rights><rdf:Alt><rdf:li>(c) Ann</rdf:li></rdf:Alt></dc:rights>` +
+		`<dc:subject>cats</dc:subject></rdf:Description></x:xmpmeta>`)
+	src := &imageMeta{XMP: xmp}
+	tests := []struct {
+		policy          string
+		contains, lacks []string
+	}{
+		{metadataKeepAll, []string{"GPSLatitude", "GPSLongitude", `tiff:Orientation="1"`, "cats"}, []string{`Orientation="6"`}},
+		{metadataStripGPS, []string{"dc:rights", "cats", `tiff:Orientation="1"`}, []string{"GPS"}},
+		{metadataKeepCopyright, []string{"<dc:rights>", `xmpRights:Marked="True"`}, []string{"GPS", "cats", "Orientation"}},
+	}
+	for _, tt := range tests {
+		got := string(src.withPolicy(tt.policy, true, image.Pt(1, 1)).XMP)
+		for _, s := range tt.contains {
+			if !strings.Contains(got, s) {
+				t.Errorf("%s: нет %q в %s", tt.policy, s, got)
+			}
+		}
+		for _, s := range tt.lacks {
+			if strings.Contains(got, s) {
+				t.Errorf("%s: лишнее %q в %s", tt.policy, s, got)
+			}
+		}
+	}
+	if got := copyrightXMP([]byte(`<x:xmpmeta><dc:subject>cats</dc:subject></x:xmpmeta>`)); got != nil {
+		t.Errorf("XMP без авторства: %s", got)
+	}
+}
+
+func TestFilterIPTC(t *testing.T) {
+	ds := func(rec, n byte, value string) []byte {
+		b := []byte{0x1c, rec, n}
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+		return append(b, value...)
+	}
+	version := ds(2, 0, "\x00\x04")
+	byline := ds(2, 80, "Ann")
+	keywords := ds(2, 25, "cats")
+	iim := append(append(append([]byte{}, version...), keywords...), byline...)
+
+	if got := string(filterIPTC(iim, iptcCopyrightDatasets)); got != string(version)+string(byline) {
+		t.Errorf("получено %q", got)
+	}
+	if got := filterIPTC(append(append([]byte{}, version...), keywords...), iptcCopyrightDatasets); got != nil {
+		t.Errorf("без авторства: %q, ожидается nil", got)
+	}
+	if got := filterIPTC(append(byline, 0x1c, 2, 80, 0x00), iptcCopyrightDatasets); string(got) != string(byline) {
+		t.Errorf("обрезанный набор: %q", got)
+	}
+}
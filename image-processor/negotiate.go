@@ -0,0 +1,163 @@
+package main
+
+import (
+	"image"
+	"strconv"
+	"strings"
+)
+
+// formatAuto - значение format, при котором формат выбирается по Accept
+const formatAuto = "auto"
+
+// Параметры распознавания фотографий
+const (
+	photoMaxSamples  = 65_536 // при большем числе пикселей берется выборка
+	graphicMaxColors = 256    // столько цветов и меньше - точно графика
+	graphicFlatShare = 0.6    // доля пикселей, совпадающих с соседом справа
+)
+
+// acceptRange - элемент заголовка Accept
+type acceptRange struct {
+	mime string
+	q    float64
+}
+
+// parseAccept - разбор заголовка Accept (параметры кроме q игнорируются)
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		mime := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mime == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{mime, q})
+	}
+	return ranges
+}
+
+// acceptQuality - вес типа mime: точное совпадение важнее image/*, а тот важнее */*.
+// rank - какой элемент сработал: 2 - тип назван явно, 1 - image/*, 0 - */*,
+// -1 - тип в заголовке не упомянут.
+func acceptQuality(ranges []acceptRange, mime string) (q float64, rank int) {
+	if len(ranges) == 0 {
+		return 1, -1
+	}
+	group := mime[:strings.Index(mime, "/")] + "/*"
+	rank = -1
+	for _, r := range ranges {
+		cur := -1
+		switch r.mime {
+		case mime:
+			cur = 2
+		case group:
+			cur = 1
+		case "*/*":
+			cur = 0
+		}
+		if cur > rank {
+			rank, q = cur, r.q
+		}
+	}
+	return q, rank
+}
+
+// negotiateFormat - формат результата для format=auto.
+// Прозрачность сохраняют WebP и PNG, фотографии лучше сжимает JPEG,
+// графику - WebP без потерь. WebP выбирается, только если клиент назвал
+// его явно: */* в Accept шлют и клиенты, которые WebP не понимают.
+// nil - клиент явно отказался (q=0) от всех подходящих форматов.
+func negotiateFormat(img image.Image, accept string) *outputFormat {
+	ranges := parseAccept(accept)
+
+	// candidates - в порядке предпочтения, fallback - если клиент
+	// не принимает ни один из них
+	var candidates []string
+	var fallback string
+	switch {
+	case hasAlpha(img):
+		candidates, fallback = []string{"webp", "png"}, "png"
+	case isPhotographic(img):
+		candidates, fallback = []string{"jpg", "webp", "png"}, "jpg"
+	default:
+		candidates, fallback = []string{"webp", "png", "jpg"}, "png"
+	}
+
+	for _, name := range candidates {
+		f, _ := lookupOutputFormat(name)
+		q, rank := acceptQuality(ranges, f.ContentType)
+		if q > 0 && (rank == 2 || name != "webp") {
+			return f
+		}
+	}
+
+	// Ни один формат не принят явно (например, Accept: text/html) - берем
+	// fallback, но не тот, от которого клиент отказался через q=0
+	for _, name := range append([]string{fallback}, candidates...) {
+		f, _ := lookupOutputFormat(name)
+		q, rank := acceptQuality(ranges, f.ContentType)
+		if name != "webp" && (rank < 0 || q > 0) {
+			return f
+		}
+	}
+	return nil
+}
+
+// hasAlpha - есть ли хотя бы один не полностью непрозрачный пиксель
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPhotographic - грубая оценка "фотография или графика". У графики
+// (логотипы, скриншоты, схемы) мало различных цветов и много одинаковых
+// соседних пикселей.
+func isPhotographic(img image.Image) bool {
+	b := img.Bounds()
+	step := 1
+	for (b.Dx()/step)*(b.Dy()/step) > photoMaxSamples {
+		step++
+	}
+
+	colors := map[uint32]struct{}{}
+	flat, total := 0, 0
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			c := packRGB(img, x, y)
+			colors[c] = struct{}{}
+			if x+1 < b.Max.X && packRGB(img, x+1, y) == c {
+				flat++
+			}
+			total++
+		}
+	}
+
+	if len(colors) <= graphicMaxColors {
+		return false
+	}
+	return float64(flat) < graphicFlatShare*float64(total)
+}
+
+func packRGB(img image.Image, x, y int) uint32 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return r>>8<<16 | g>>8<<8 | b>>8
+}
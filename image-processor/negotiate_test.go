@@ -0,0 +1,119 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestParseAccept(t *testing.T) {
+	got := parseAccept(" image/WebP;q=0.9 , image/*; Q=0.5,*/*;level=1, ,text/html;q=x")
+	want := []acceptRange{{"image/webp", 0.9}, {"image/*", 0.5}, {"*/*", 1}, {"text/html", 1}}
+	if len(got) != len(want) {
+		t.Fatalf("%v, ожидается %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("элемент %d: %v, ожидается %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept   string
+		mime     string
+		wantQ    float64
+		wantRank int
+	}{
+		{"", "image/png", 1, -1},
+		{"image/png", "image/png", 1, 2},
+		{"image/*;q=0.4", "image/png", 0.4, 1},
+		{"*/*;q=0.1", "image/png", 0.1, 0},
+		{"*/*, image/png;q=0", "image/png", 0, 2},
+		{"image/png;q=0, image/*", "image/png", 0, 2},
+		{"text/html", "image/png", 0, -1},
+	}
+	for _, tt := range tests {
+		q, rank := acceptQuality(parseAccept(tt.accept), tt.mime)
+		if q != tt.wantQ || rank != tt.wantRank {
+			t.Errorf("Accept %q, %s: q=%g rank=%d, ожидается q=%g rank=%d", tt.accept, tt.mime, q, rank, tt.wantQ, tt.wantRank)
+		}
+	}
+}
+
+// photoImage - шумное изображение, похожее на фотографию
+func photoImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	return img
+}
+
+// flatImage - однотонное изображение (графика)
+func flatImage(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	photo := photoImage(64, 64)
+	graphic := flatImage(64, 64, color.NRGBA{0, 128, 255, 255})
+	alpha := flatImage(64, 64, color.NRGBA{0, 128, 255, 100})
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		accept string
+		want   string // "" - ничего не подходит
+	}{
+		{"photo any", photo, "*/*", "jpg"},
+		{"photo no header", photo, "", "jpg"},
+		{"photo no jpeg", photo, "image/webp, image/jpeg;q=0", "webp"},
+		{"photo png only", photo, "image/png", "png"},
+		{"graphic webp", graphic, "image/webp,*/*", "webp"},
+		{"graphic any", graphic, "*/*", "png"},
+		{"graphic no png", graphic, "image/png;q=0, */*", "jpg"},
+		{"alpha webp", alpha, "image/avif,image/webp,image/*", "webp"},
+		{"alpha browser", alpha, "image/*", "png"},
+		{"html", alpha, "text/html", "png"},
+		{"html no png", graphic, "text/html, image/png;q=0", "jpg"},
+		{"refused", alpha, "image/png;q=0, image/webp;q=0", ""},
+	}
+	for _, tt := range tests {
+		f := negotiateFormat(tt.img, tt.accept)
+		got := ""
+		if f != nil {
+			got = f.Name
+		}
+		if got != tt.want {
+			t.Errorf("%s: %q, ожидается %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsPhotographic(t *testing.T) {
+	if !isPhotographic(photoImage(300, 300)) {
+		t.Error("шум не распознан как фотография")
+	}
+	// много цветов, но блоками 4×4, как на скриншоте
+	blocks := photoImage(300, 300)
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			blocks.SetNRGBA(x, y, blocks.NRGBAAt(x&^3, y&^3))
+		}
+	}
+	if isPhotographic(blocks) {
+		t.Error("цветные блоки распознаны как фотография")
+	}
+	if isPhotographic(flatImage(10, 10, color.NRGBA{1, 2, 3, 255})) {
+		t.Error("однотонное изображение распознано как фотография")
+	}
+}
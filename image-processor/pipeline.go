@@ -0,0 +1,663 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Ограничения конвейера
+const (
+	maxPipelineSteps = 32
+	maxOutputSide    = 10000
+)
+
+// stepFunc - применение одного шага к изображению
+type stepFunc func(img image.Image, rc *runContext) (image.Image, error)
+
+// stepBuilder - разбор параметров операции, возвращает готовый шаг
+type stepBuilder func(params json.RawMessage, opts *pipelineOptions) (stepFunc, error)
+
+// pipelineOptions - общие параметры запроса, значения по умолчанию для шагов
+type pipelineOptions struct {
+	Resample   *resampleKernel
+	Fit        string
+	Gravity    gravity
+	Background color.NRGBA
+	Mask       image.Image // загруженная маска "mask" для fit=seam
+
+	files map[string][]*multipart.FileHeader // файлы формы (маски шагов)
+}
+
+// defaultBackground - цвет полей для fit=contain и подложки для форматов без прозрачности
+const defaultBackground = "white"
+
+// parsePipelineOptions - чтение общих параметров из формы
+func parsePipelineOptions(r *http.Request) (*pipelineOptions, error) {
+	var err error
+	opts := &pipelineOptions{}
+
+	if opts.Resample, err = parseResample(r.FormValue("resample")); err != nil {
+		return nil, err
+	}
+	if opts.Fit, err = parseFit(r.FormValue("fit")); err != nil {
+		return nil, err
+	}
+	if opts.Gravity, err = parseGravity(r.FormValue("gravity")); err != nil {
+		return nil, err
+	}
+
+	background := r.FormValue("background")
+	if background == "" {
+		background = defaultBackground
+	}
+	if opts.Background, err = parseColor(background); err != nil {
+		return nil, fmt.Errorf("background: %v", err)
+	}
+
+	if r.MultipartForm != nil {
+		opts.files = r.MultipartForm.File
+	}
+
+	// Необязательная маска: зеленое - защитить, красное - удалить
+	if _, ok := opts.files["mask"]; ok {
+		if opts.Mask, err = opts.formImage("mask"); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// formImage - изображение из файла формы name
+func (o *pipelineOptions) formImage(name string) (image.Image, error) {
+	headers := o.files[name]
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("%s: файл не загружен", name)
+	}
+	file, err := headers[0].Open()
+	if err != nil {
+		return nil, fmt.Errorf("%s: ошибка чтения", name)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: неверный формат изображения", name)
+	}
+	return img, nil
+}
+
+// pipelineStep - один шаг конвейера обработки
+type pipelineStep struct {
+	Op    string
+	Apply stepFunc
+}
+
+// pipeline - операции, выполняемые строго в заданном порядке
+type pipeline []pipelineStep
+
+// stepError - ошибка, привязанная к номеру шага конвейера
+type stepError struct {
+	Index int
+	Op    string
+	Err   error
+}
+
+func (e *stepError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("шаг %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("шаг %d (%s): %v", e.Index, e.Op, e.Err)
+}
+
+func (e *stepError) Unwrap() error {
+	return e.Err
+}
+
+// stepBuilders - известные операции конвейера
+var stepBuilders = map[string]stepBuilder{
+	"crop":        buildCropStep,
+	"rotate":      buildRotateStep,
+	"flip":        buildFlipStep,
+	"filter":      buildFilterStep,
+	"convolve":    buildConvolveStep,
+	"levels":      buildLevelsStep,
+	"curves":      buildCurvesStep,
+	"quantize":    buildQuantizeStep,
+	"gradientmap": buildGradientMapStep,
+	"resize":      buildResizeStep,
+}
+
+// parsePipeline - разбор JSON-массива "operations"
+func parsePipeline(data string, opts *pipelineOptions) (pipeline, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, fmt.Errorf("operations: ожидается JSON-массив операций: %v", err)
+	}
+	if len(raw) > maxPipelineSteps {
+		return nil, fmt.Errorf("operations: слишком много шагов (макс %d)", maxPipelineSteps)
+	}
+
+	p := make(pipeline, 0, len(raw))
+	for i, item := range raw {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(item, &fields); err != nil || fields == nil {
+			return nil, &stepError{Index: i, Err: errors.New("шаг должен быть JSON-объектом")}
+		}
+
+		var op string
+		if err := json.Unmarshal(fields["op"], &op); err != nil || op == "" {
+			return nil, &stepError{Index: i, Err: errors.New("не указано поле \"op\"")}
+		}
+		delete(fields, "op")
+
+		build, ok := stepBuilders[op]
+		if !ok {
+			return nil, &stepError{Index: i, Op: op, Err: errors.New("неизвестная операция")}
+		}
+
+		params, _ := json.Marshal(fields)
+		apply, err := build(params, opts)
+		if err != nil {
+			return nil, &stepError{Index: i, Op: op, Err: err}
+		}
+		p = append(p, pipelineStep{Op: op, Apply: apply})
+	}
+	return p, nil
+}
+
+// legacyPipeline - конвейер из старых полей формы (rotate → flip → filter → resize)
+func legacyPipeline(r *http.Request, opts *pipelineOptions) (pipeline, error) {
+	var p pipeline
+
+	rotate, _ := strconv.ParseFloat(r.FormValue("rotate"), 64)
+	if rotate != 0 {
+		p = append(p, pipelineStep{Op: "rotate", Apply: func(img image.Image, rc *runContext) (image.Image, error) {
+			return rotateImage(img, rotate), nil
+		}})
+	}
+
+	flip := r.FormValue("flip")
+	if flip != "" && flip != "none" {
+		p = append(p, pipelineStep{Op: "flip", Apply: func(img image.Image, rc *runContext) (image.Image, error) {
+			return flipImage(img, flip), nil
+		}})
+	}
+
+	// filter_params - JSON-объект значений параметров фильтра,
+	// filter_mask - файл маски области действия фильтра
+	filter := r.FormValue("filter")
+	if filter != "" && filter != "none" {
+		var given map[string]interface{}
+		if data := r.FormValue("filter_params"); data != "" {
+			if err := json.Unmarshal([]byte(data), &given); err != nil {
+				return nil, fmt.Errorf("filter_params: ожидается JSON-объект: %v", err)
+			}
+		}
+		values, err := filterParams(filter, given)
+		if err != nil {
+			return nil, err
+		}
+		var filterMask image.Image
+		if len(opts.files["filter_mask"]) > 0 {
+			if filterMask, err = opts.formImage("filter_mask"); err != nil {
+				return nil, err
+			}
+		}
+		p = append(p, pipelineStep{Op: "filter", Apply: func(img image.Image, rc *runContext) (image.Image, error) {
+			return applyFilter(img, filter, values, filterMask)
+		}})
+	}
+
+	width, err := formInt(r, "width")
+	if err != nil {
+		return nil, err
+	}
+	height, err := formInt(r, "height")
+	if err != nil {
+		return nil, err
+	}
+	spec := resizeSpec{
+		Width:      width,
+		Height:     height,
+		Fit:        opts.Fit,
+		Gravity:    opts.Gravity,
+		Background: opts.Background,
+		Kernel:     opts.Resample,
+		Mask:       opts.Mask,
+	}
+	if width != 0 || height != 0 {
+		if err := spec.validate(); err != nil {
+			return nil, err
+		}
+		p = append(p, pipelineStep{Op: "resize", Apply: func(img image.Image, rc *runContext) (image.Image, error) {
+			return resizeFit(img, spec, rc)
+		}})
+	}
+
+	return p, nil
+}
+
+// formInt - целое поле формы (пустое - 0)
+func formInt(r *http.Request, name string) (int, error) {
+	s := strings.TrimSpace(r.FormValue(name))
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: ожидается целое число, получено %q", name, s)
+	}
+	return v, nil
+}
+
+// runContext - состояние одного прогона конвейера
+type runContext struct {
+	step   int                     // номер выполняемого шага
+	Header http.Header             // заголовки ответа, выставленные шагами
+	smart  map[int]image.Rectangle // области, выбранные умной обрезкой, по номеру шага
+}
+
+func newRunContext() *runContext {
+	return &runContext{
+		Header: http.Header{},
+		smart:  map[int]image.Rectangle{},
+	}
+}
+
+// run - последовательное выполнение шагов
+func (p pipeline) run(img image.Image, rc *runContext) (image.Image, error) {
+	for i, step := range p {
+		rc.step = i
+		out, err := step.Apply(img, rc)
+		if err != nil {
+			return nil, &stepError{Index: i, Op: step.Op, Err: err}
+		}
+		img = out
+	}
+	return img, nil
+}
+
+// describe - краткое описание конвейера для лога
+func (p pipeline) describe() string {
+	if len(p) == 0 {
+		return "без операций"
+	}
+	ops := make([]string, len(p))
+	for i, step := range p {
+		ops[i] = step.Op
+	}
+	return strings.Join(ops, " → ")
+}
+
+// decodeParams - строгий разбор параметров шага (опечатки в полях - ошибка)
+func decodeParams(params json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("неверные параметры: %v", err)
+	}
+	return nil
+}
+
+func buildRotateStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	var p struct {
+		Angle float64 `json:"angle"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if math.IsNaN(p.Angle) || math.IsInf(p.Angle, 0) {
+		return nil, errors.New("недопустимый угол поворота")
+	}
+
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		return rotateImage(img, p.Angle), nil
+	}, nil
+}
+
+func buildFlipStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	var p struct {
+		Direction string `json:"direction"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	switch p.Direction {
+	case "horizontal", "vertical", "both":
+	default:
+		return nil, fmt.Errorf("direction: ожидается horizontal, vertical или both, получено %q", p.Direction)
+	}
+
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		return flipImage(img, p.Direction), nil
+	}, nil
+}
+
+func buildFilterStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	var p struct {
+		Name   string                 `json:"name"`
+		Params map[string]interface{} `json:"params"`
+		Mask   string                 `json:"mask"` // имя поля формы с файлом маски
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	values, err := filterParams(p.Name, p.Params)
+	if err != nil {
+		return nil, err
+	}
+	var mask image.Image
+	if p.Mask != "" {
+		if mask, err = opts.formImage(p.Mask); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		return applyFilter(img, p.Name, values, mask)
+	}, nil
+}
+
+// buildConvolveStep - свертка пользовательской матрицей:
+// {"op":"convolve","kernel":[[0,-1,0],[-1,5,-1],[0,-1,0]],"divisor":1,"bias":0,"edge":"mirror"}.
+// divisor 0 - сумма элементов ядра (или 1, если она нулевая).
+func buildConvolveStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	var p struct {
+		Kernel  [][]float64 `json:"kernel"`
+		Divisor float64     `json:"divisor"`
+		Bias    float64     `json:"bias"`
+		Edge    string      `json:"edge"`
+		blendSpec
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	k, err := parseKernel(p.Kernel, p.Divisor)
+	if err != nil {
+		return nil, err
+	}
+	given := map[string]interface{}{}
+	if p.Edge != "" {
+		given[edgeParam.ID] = p.Edge
+	}
+	values, err := resolveParams([]filterParam{edgeParam}, given)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(p.Bias) || math.IsInf(p.Bias, 0) {
+		return nil, errors.New("bias: ожидается число")
+	}
+	blend, err := p.resolve(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		b := img.Bounds()
+		if err := checkConvolveWork(b.Dx(), b.Dy(), k); err != nil {
+			return nil, err
+		}
+		out := convolve2D(newPlane(img), k, float32(p.Bias), edgeOf(values)).image(isDeep(img))
+		return blend.apply(img, out), nil
+	}, nil
+}
+
+func buildLevelsStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	p := struct {
+		RGB levelsChannel `json:"rgb"`
+		R   levelsChannel `json:"r"`
+		G   levelsChannel `json:"g"`
+		B   levelsChannel `json:"b"`
+		blendSpec
+	}{RGB: defaultLevels, R: defaultLevels, G: defaultLevels, B: defaultLevels}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	luts, err := levelsLUTs(p.RGB, [3]levelsChannel{p.R, p.G, p.B})
+	if err != nil {
+		return nil, err
+	}
+	blend, err := p.resolve(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		return blend.apply(img, mapPixels(img, luts.apply)), nil
+	}, nil
+}
+
+func buildCurvesStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	var p struct {
+		RGB  [][]float64 `json:"rgb"`
+		R    [][]float64 `json:"r"`
+		G    [][]float64 `json:"g"`
+		B    [][]float64 `json:"b"`
+		Luma [][]float64 `json:"luma"`
+		blendSpec
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	f, err := curvesPixelFunc(p.RGB, [3][][]float64{p.R, p.G, p.B}, p.Luma)
+	if err != nil {
+		return nil, err
+	}
+	blend, err := p.resolve(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		return blend.apply(img, mapPixels(img, f)), nil
+	}, nil
+}
+
+// buildQuantizeStep - перевод в палитру: {"op":"quantize","colors":16,
+// "method":"octree","dither":"atkinson"} или с готовой палитрой
+// {"palette":["black","white"],"dither":"bayer"} (1 бит для PNG)
+func buildQuantizeStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	p := struct {
+		Colors    int      `json:"colors"`
+		Method    string   `json:"method"`
+		Palette   []string `json:"palette"`
+		Dither    string   `json:"dither"`
+		BayerSize int      `json:"bayer_size"`
+	}{Colors: 256, Method: quantizeMethods[0], Dither: "floyd-steinberg", BayerSize: 4}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	spec := quantizeSpec{Colors: p.Colors, Method: p.Method, Dither: p.Dither, BayerSize: p.BayerSize}
+	if p.Colors < 2 || p.Colors > 256 {
+		return nil, fmt.Errorf("colors: ожидается от 2 до 256, получено %d", p.Colors)
+	}
+	if !slices.Contains(quantizeMethods, p.Method) {
+		return nil, fmt.Errorf("method: ожидается одно из: %s, получено %q", strings.Join(quantizeMethods, ", "), p.Method)
+	}
+	if !slices.Contains(ditherMethods, p.Dither) {
+		return nil, fmt.Errorf("dither: ожидается одно из: %s, получено %q", strings.Join(ditherMethods, ", "), p.Dither)
+	}
+	if !slices.Contains(bayerSizes, p.BayerSize) {
+		return nil, fmt.Errorf("bayer_size: ожидается 2, 4 или 8, получено %d", p.BayerSize)
+	}
+	if p.Palette != nil {
+		if len(p.Palette) < 2 || len(p.Palette) > 256 {
+			return nil, errors.New("palette: ожидается от 2 до 256 цветов")
+		}
+		for i, s := range p.Palette {
+			c, err := parseColor(s)
+			if err != nil {
+				return nil, fmt.Errorf("palette[%d]: %v", i, err)
+			}
+			spec.Palette = append(spec.Palette, c)
+		}
+	}
+
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		return quantize(img, spec), nil
+	}, nil
+}
+
+// buildGradientMapStep - карта градиента: {"op":"gradientmap",
+// "colors":["black","#c03030","#ffe080"]} - цвета от теней к светам
+func buildGradientMapStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	var p struct {
+		Colors []string `json:"colors"`
+		blendSpec
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Colors) < 2 || len(p.Colors) > maxGradientColors {
+		return nil, fmt.Errorf("colors: ожидается от 2 до %d цветов", maxGradientColors)
+	}
+	colors := make([]float64, len(p.Colors))
+	for i, s := range p.Colors {
+		c, err := parseColor(s)
+		if err != nil {
+			return nil, fmt.Errorf("colors[%d]: %v", i, err)
+		}
+		colors[i] = packColor(c)
+	}
+	blend, err := p.resolve(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	f := gradientMap(colors)
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		return blend.apply(img, mapPixels(img, f)), nil
+	}, nil
+}
+
+// blendSpec - сила эффекта (0..100) и поле формы с маской у операций,
+// работающих как фильтры
+type blendSpec struct {
+	Intensity *float64 `json:"intensity"`
+	Mask      string   `json:"mask"`
+}
+
+// stepBlend - проверенные сила эффекта (0..1) и маска
+type stepBlend struct {
+	intensity float64
+	mask      image.Image
+}
+
+func (s blendSpec) resolve(opts *pipelineOptions) (stepBlend, error) {
+	given := map[string]interface{}{}
+	if s.Intensity != nil {
+		given[intensityParam.ID] = *s.Intensity
+	}
+	values, err := resolveParams([]filterParam{intensityParam}, given)
+	if err != nil {
+		return stepBlend{}, err
+	}
+	b := stepBlend{intensity: values[intensityParam.ID] / 100}
+	if s.Mask != "" {
+		if b.mask, err = opts.formImage(s.Mask); err != nil {
+			return stepBlend{}, err
+		}
+	}
+	return b, nil
+}
+
+// apply - смешивание результата out с исходным img
+func (b stepBlend) apply(img, out image.Image) image.Image {
+	return blendFilter(img, out, b.intensity, b.mask)
+}
+
+func buildResizeStep(params json.RawMessage, opts *pipelineOptions) (stepFunc, error) {
+	var p struct {
+		Width      int    `json:"width"`
+		Height     int    `json:"height"`
+		Resample   string `json:"resample"`
+		Fit        string `json:"fit"`
+		Gravity    string `json:"gravity"`
+		Background string `json:"background"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	spec := resizeSpec{
+		Width:      p.Width,
+		Height:     p.Height,
+		Fit:        opts.Fit,
+		Gravity:    opts.Gravity,
+		Background: opts.Background,
+		Kernel:     opts.Resample,
+		Mask:       opts.Mask,
+	}
+
+	var err error
+	if p.Resample != "" {
+		if spec.Kernel, err = parseResample(p.Resample); err != nil {
+			return nil, err
+		}
+	}
+	if p.Fit != "" {
+		if spec.Fit, err = parseFit(p.Fit); err != nil {
+			return nil, err
+		}
+	}
+	if p.Gravity != "" {
+		if spec.Gravity, err = parseGravity(p.Gravity); err != nil {
+			return nil, err
+		}
+	}
+	if p.Background != "" {
+		if spec.Background, err = parseColor(p.Background); err != nil {
+			return nil, fmt.Errorf("background: %v", err)
+		}
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+
+	return func(img image.Image, rc *runContext) (image.Image, error) {
+		return resizeFit(img, spec, rc)
+	}, nil
+}
+
+// runErrorStatus - код ответа для ошибки выполнения конвейера: слишком
+// большой результат - ошибка запроса, прочее - 422
+func runErrorStatus(err error) int {
+	var size *outputSizeError
+	if errors.As(err, &size) {
+		return http.StatusBadRequest
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// sendStepError - JSON-ошибка с номером шага, если он известен
+func sendStepError(w http.ResponseWriter, err error, code int) {
+	var se *stepError
+	if !errors.As(err, &se) {
+		sendJSONError(w, err.Error(), code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   se.Error(),
+		"step":    se.Index,
+		"op":      se.Op,
+		"success": false,
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// formRequest - POST-запрос с полями формы
+func formRequest(fields map[string]string) *http.Request {
+	values := url.Values{}
+	for k, v := range fields {
+		values.Set(k, v)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/process", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// defaultOptions - настройки конвейера для запроса без полей
+func defaultOptions(t *testing.T) *pipelineOptions {
+	t.Helper()
+	opts, err := parsePipelineOptions(formRequest(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func TestLegacyPipeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		wantOps []string
+		wantErr string
+	}{
+		{"empty", nil, nil, ""},
+		{"all", map[string]string{"rotate": "90", "flip": "horizontal", "filter": "sepia", "width": "100"},
+			[]string{"rotate", "flip", "filter", "resize"}, ""},
+		{"filter none", map[string]string{"filter": "none", "filter_params": "{bad"}, nil, ""},
+		{"width zero", map[string]string{"width": "0", "height": ""}, nil, ""},
+
+		{"width not a number", map[string]string{"width": "abc"}, nil, "width"},
+		{"height fraction", map[string]string{"height": "1.5"}, nil, "height"},
+		{"width too large", map[string]string{"width": "20000"}, nil, "размер больше"},
+		{"negative", map[string]string{"width": "-5"}, nil, "отрицательными"},
+		{"cover one side", map[string]string{"fit": "cover", "width": "300"}, nil, "fit=cover"},
+		{"filter params json", map[string]string{"filter": "brightness", "filter_params": "{bad"}, nil, "filter_params"},
+		{"filter param range", map[string]string{"filter": "brightness", "filter_params": `{"amount":500}`}, nil, "amount"},
+		{"filter unknown param", map[string]string{"filter": "brightness", "filter_params": `{"foo":1}`}, nil, "foo"},
+		{"unknown filter", map[string]string{"filter": "zzz"}, nil, "zzz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := formRequest(tt.fields)
+			opts, err := parsePipelineOptions(r)
+			if err != nil {
+				t.Fatalf("parsePipelineOptions: %v", err)
+			}
+			p, err := legacyPipeline(r, opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидается с %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("legacyPipeline: %v", err)
+			}
+			if len(p) != len(tt.wantOps) {
+				t.Fatalf("шаги %q, ожидается %q", p.describe(), tt.wantOps)
+			}
+			for i, step := range p {
+				if step.Op != tt.wantOps[i] {
+					t.Errorf("шаг %d: %s, ожидается %s", i, step.Op, tt.wantOps[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParsePipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantOps  []string
+		wantStep int // номер шага в ошибке, -1 - ошибка без шага
+		wantErr  string
+	}{
+		{"empty", `[]`, nil, 0, ""},
+		{"ordered", `[{"op":"resize","width":10},{"op":"rotate","angle":90},{"op":"filter","name":"sepia"}]`,
+			[]string{"resize", "rotate", "filter"}, 0, ""},
+		{"repeated", `[{"op":"flip","direction":"both"},{"op":"flip","direction":"vertical"}]`,
+			[]string{"flip", "flip"}, 0, ""},
+
+		{"not array", `{"op":"rotate"}`, nil, -1, "JSON-массив"},
+		{"too many", "[" + strings.TrimSuffix(strings.Repeat(`{"op":"flip","direction":"both"},`, maxPipelineSteps+1), ",") + "]",
+			nil, -1, "слишком много"},
+		{"not object", `[{"op":"flip","direction":"both"},42]`, nil, 1, "JSON-объектом"},
+		{"null step", `[null]`, nil, 0, "JSON-объектом"},
+		{"no op", `[{"angle":90}]`, nil, 0, "op"},
+		{"unknown op", `[{"op":"explode"}]`, nil, 0, "неизвестная операция"},
+		{"unknown field", `[{"op":"rotate","angel":90}]`, nil, 0, "angel"},
+		{"bad direction", `[{"op":"rotate","angle":90},{"op":"flip","direction":"up"}]`, nil, 1, "direction"},
+		{"bad filter", `[{"op":"filter","name":"zzz"}]`, nil, 0, "zzz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parsePipeline(tt.data, defaultOptions(t))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидается с %q", err, tt.wantErr)
+				}
+				var se *stepError
+				if errors.As(err, &se) != (tt.wantStep >= 0) || (se != nil && se.Index != tt.wantStep) {
+					t.Errorf("ошибка %v, ожидается шаг %d", err, tt.wantStep)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePipeline: %v", err)
+			}
+			if len(p) != len(tt.wantOps) {
+				t.Fatalf("шаги %q, ожидается %q", p.describe(), tt.wantOps)
+			}
+			for i, step := range p {
+				if step.Op != tt.wantOps[i] {
+					t.Errorf("шаг %d: %s, ожидается %s", i, step.Op, tt.wantOps[i])
+				}
+			}
+		})
+	}
+}
+
+// Порядок шагов важен: обрезка до масштабирования и после дает разные размеры
+func TestPipelineOrder(t *testing.T) {
+	tests := []struct {
+		data string
+		want image.Point
+	}{
+		{`[{"op":"crop","width":10,"height":4},{"op":"resize","width":5}]`, image.Pt(5, 2)},
+		{`[{"op":"resize","width":10},{"op":"crop","width":10,"height":4}]`, image.Pt(10, 4)},
+		{`[{"op":"resize","width":6,"height":6,"fit":"fill"},{"op":"crop","x":50,"y":0,"width":50,"height":100,"unit":"%"}]`, image.Pt(3, 6)},
+	}
+	for _, tt := range tests {
+		p, err := parsePipeline(tt.data, defaultOptions(t))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.data, err)
+		}
+		out, err := p.run(testImage(20, 12), newRunContext())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.data, err)
+		}
+		if got := out.Bounds().Size(); got != tt.want {
+			t.Errorf("%s: размер %v, ожидается %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestSendStepError(t *testing.T) {
+	p, err := parsePipeline(`[{"op":"rotate","angle":90},{"op":"crop","width":100,"height":100}]`, defaultOptions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.run(testImage(20, 12), newRunContext())
+	if runErrorStatus(err) != http.StatusUnprocessableEntity {
+		t.Errorf("код %d для %v", runErrorStatus(err), err)
+	}
+
+	rec := httptest.NewRecorder()
+	sendStepError(rec, err, runErrorStatus(err))
+	var body struct {
+		Error   string `json:"error"`
+		Step    int    `json:"step"`
+		Op      string `json:"op"`
+		Success bool   `json:"success"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusUnprocessableEntity || body.Step != 1 || body.Op != "crop" || body.Success {
+		t.Errorf("ответ %d %+v", rec.Code, body)
+	}
+
+	// слишком большая вычисленная сторона - ошибка запроса
+	p, err = parsePipeline(`[{"op":"resize","width":9000}]`, defaultOptions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.run(testImage(1, 100), newRunContext()); runErrorStatus(err) != http.StatusBadRequest {
+		t.Errorf("код %d для %v", runErrorStatus(err), err)
+	}
+}
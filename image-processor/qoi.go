@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// Коды операций QOI (https://qoiformat.org/qoi-specification.pdf)
+const (
+	qoiOpIndex = 0x00
+	qoiOpDiff  = 0x40
+	qoiOpLuma  = 0x80
+	qoiOpRun   = 0xc0
+	qoiOpRGB   = 0xfe
+	qoiOpRGBA  = 0xff
+	qoiMaxRun  = 62
+)
+
+// encodeQOI - запись изображения в формат QOI
+func encodeQOI(w io.Writer, img image.Image) error {
+	src := toNRGBA(img)
+	b := src.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	channels := byte(3)
+	for i := 3; i < len(src.Pix); i += 4 {
+		if src.Pix[i] != 0xff {
+			channels = 4
+			break
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	var hdr [14]byte
+	copy(hdr[:], "qoif")
+	binary.BigEndian.PutUint32(hdr[4:], uint32(width))
+	binary.BigEndian.PutUint32(hdr[8:], uint32(height))
+	hdr[12] = channels
+	hdr[13] = 0 // sRGB
+	bw.Write(hdr[:])
+
+	var index [64][4]byte
+	prev := [4]byte{0, 0, 0, 255}
+	run := 0
+	total := width * height
+
+	for i := 0; i < total; i++ {
+		y, x := i/width, i%width
+		var px [4]byte
+		copy(px[:], src.Pix[y*src.Stride+x*4:])
+
+		if px == prev {
+			run++
+			if run == qoiMaxRun || i == total-1 {
+				bw.WriteByte(byte(qoiOpRun | (run - 1)))
+				run = 0
+			}
+			continue
+		}
+		if run > 0 {
+			bw.WriteByte(byte(qoiOpRun | (run - 1)))
+			run = 0
+		}
+
+		h := (int(px[0])*3 + int(px[1])*5 + int(px[2])*7 + int(px[3])*11) % 64
+		switch {
+		case index[h] == px:
+			bw.WriteByte(byte(qoiOpIndex | h))
+		case px[3] == prev[3]:
+			index[h] = px
+			dr := int(int8(px[0] - prev[0]))
+			dg := int(int8(px[1] - prev[1]))
+			db := int(int8(px[2] - prev[2]))
+			dgr, dgb := dr-dg, db-dg
+			switch {
+			case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+				bw.WriteByte(byte(qoiOpDiff | (dr+2)<<4 | (dg+2)<<2 | (db + 2)))
+			case dg >= -32 && dg <= 31 && dgr >= -8 && dgr <= 7 && dgb >= -8 && dgb <= 7:
+				bw.WriteByte(byte(qoiOpLuma | (dg + 32)))
+				bw.WriteByte(byte((dgr+8)<<4 | (dgb + 8)))
+			default:
+				bw.Write([]byte{qoiOpRGB, px[0], px[1], px[2]})
+			}
+		default:
+			index[h] = px
+			bw.Write([]byte{qoiOpRGBA, px[0], px[1], px[2], px[3]})
+		}
+		prev = px
+	}
+
+	bw.Write([]byte{0, 0, 0, 0, 0, 0, 0, 1})
+	return bw.Flush()
+}
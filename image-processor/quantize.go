@@ -0,0 +1,518 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Параметры квантования палитры
+const (
+	quantizeMaxSamples = 262_144 // при большем числе пикселей берется выборка
+	alphaThreshold     = 0x8000  // alpha ниже порога считается прозрачной
+)
+
+// colorBucket - ячейка гистограммы цветов для median cut
+type colorBucket struct {
+	key     uint16 // r<<10 | g<<5 | b (по 5 бит)
+	count   int
+	r, g, b float64 // суммы исходных значений 0..255
+}
+
+// Способы построения палитры и дизеринга (имена в запросах)
+var (
+	quantizeMethods = []string{"median-cut", "octree"}
+	ditherMethods   = []string{"none", "floyd-steinberg", "atkinson", "bayer"}
+	bayerSizes      = []int{2, 4, 8}
+)
+
+// quantizeSpec - параметры перевода в палитру
+type quantizeSpec struct {
+	Colors    int           // цветов в строящейся палитре (включая прозрачный)
+	Method    string        // quantizeMethods
+	Palette   color.Palette // готовая палитра; если задана, Colors и Method не нужны
+	Dither    string        // ditherMethods
+	BayerSize int           // сторона матрицы для dither=bayer
+}
+
+// palettize - перевод изображения в палитру до maxColors цветов
+// (median cut + диффузия ошибки Флойда-Стейнберга)
+func palettize(img image.Image, maxColors int) *image.Paletted {
+	return quantize(img, quantizeSpec{Colors: maxColors, Method: "median-cut", Dither: "floyd-steinberg"})
+}
+
+// quantize - перевод изображения в палитру. Если есть прозрачные пиксели,
+// под них резервируется отдельный индекс (в готовую палитру он
+// добавляется, если в ней нет прозрачного цвета и есть место).
+func quantize(img image.Image, spec quantizeSpec) *image.Paletted {
+	transparent := hasTransparency(img)
+
+	pal := spec.Palette
+	if pal == nil {
+		n := spec.Colors
+		if transparent {
+			n--
+		}
+		if spec.Method == "octree" {
+			pal = octreePalette(img, n)
+		} else {
+			pal = medianCutPalette(img, n)
+		}
+		if transparent {
+			pal = append(pal, color.Transparent)
+		}
+	} else if transparent && transparentIndex(pal) < 0 && len(pal) < 256 {
+		pal = append(pal[:len(pal):len(pal)], color.Transparent)
+	}
+
+	switch spec.Dither {
+	case "floyd-steinberg":
+		return diffuseError(img, pal, floydSteinberg)
+	case "atkinson":
+		return diffuseError(img, pal, atkinson)
+	case "bayer":
+		return ditherOrdered(img, pal, spec.BayerSize)
+	}
+	return diffuseError(img, pal, nil)
+}
+
+// hasTransparency - есть ли пиксели, которые в палитре станут прозрачными
+func hasTransparency(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return false
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a < alphaThreshold {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// medianCutPalette - палитра до n цветов по непрозрачным пикселям
+func medianCutPalette(img image.Image, n int) color.Palette {
+	b := img.Bounds()
+	step := 1
+	for (b.Dx()/step)*(b.Dy()/step) > quantizeMaxSamples {
+		step++
+	}
+
+	hist := map[uint16]*colorBucket{}
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if uint32(c.A)*0x101 < alphaThreshold {
+				continue
+			}
+			key := uint16(c.R>>3)<<10 | uint16(c.G>>3)<<5 | uint16(c.B>>3)
+			bk := hist[key]
+			if bk == nil {
+				bk = &colorBucket{key: key}
+				hist[key] = bk
+			}
+			bk.count++
+			bk.r += float64(c.R)
+			bk.g += float64(c.G)
+			bk.b += float64(c.B)
+		}
+	}
+
+	buckets := make([]*colorBucket, 0, len(hist))
+	for _, bk := range hist {
+		buckets = append(buckets, bk)
+	}
+	if len(buckets) == 0 {
+		return color.Palette{color.Black}
+	}
+	// порядок обхода map случаен - сортируем для воспроизводимости
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].key < buckets[j].key })
+
+	boxes := [][]*colorBucket{buckets}
+	for len(boxes) < n {
+		// Делим группу с наибольшим разбросом, взвешенным числом пикселей
+		best, bestScore, bestCh := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			ch, spread := widestChannel(box)
+			score := spread * boxPixels(box)
+			if score > bestScore {
+				best, bestScore, bestCh = i, score, ch
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool {
+			return bucketChannel(box[i], bestCh) < bucketChannel(box[j], bestCh)
+		})
+
+		// Медиана по числу пикселей
+		half, acc, cut := boxPixels(box)/2, 0, 1
+		for i, bk := range box[:len(box)-1] {
+			acc += bk.count
+			if acc >= half {
+				cut = i + 1
+				break
+			}
+		}
+		boxes[best] = box[:cut]
+		boxes = append(boxes, box[cut:])
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		var r, g, bl float64
+		cnt := 0
+		for _, bk := range box {
+			r += bk.r
+			g += bk.g
+			bl += bk.b
+			cnt += bk.count
+		}
+		f := float64(cnt)
+		pal = append(pal, color.NRGBA{uint8(r/f + 0.5), uint8(g/f + 0.5), uint8(bl/f + 0.5), 255})
+	}
+	return pal
+}
+
+func bucketChannel(bk *colorBucket, ch int) uint16 {
+	return bk.key >> (10 - 5*ch) & 0x1f
+}
+
+func widestChannel(box []*colorBucket) (int, int) {
+	best, bestSpread := 0, -1
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := uint16(31), uint16(0)
+		for _, bk := range box {
+			v := bucketChannel(bk, ch)
+			lo, hi = min(lo, v), max(hi, v)
+		}
+		if spread := int(hi) - int(lo); spread > bestSpread {
+			best, bestSpread = ch, spread
+		}
+	}
+	return best, bestSpread
+}
+
+func boxPixels(box []*colorBucket) int {
+	n := 0
+	for _, bk := range box {
+		n += bk.count
+	}
+	return n
+}
+
+// paletteIndex - поиск ближайшего непрозрачного цвета палитры с кэшем
+type paletteIndex struct {
+	pal   []color.NRGBA
+	cache map[uint32]uint8
+}
+
+func newPaletteIndex(pal color.Palette) *paletteIndex {
+	pi := &paletteIndex{cache: map[uint32]uint8{}}
+	for _, c := range pal {
+		pi.pal = append(pi.pal, color.NRGBAModel.Convert(c).(color.NRGBA))
+	}
+	return pi
+}
+
+func (pi *paletteIndex) nearest(r, g, b int) uint8 {
+	key := uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+	if idx, ok := pi.cache[key]; ok {
+		return idx
+	}
+	best, bestDist := 0, 1<<62
+	for i, c := range pi.pal {
+		if c.A == 0 {
+			continue
+		}
+		dr, dg, db := r-int(c.R), g-int(c.G), b-int(c.B)
+		// веса примерно соответствуют чувствительности глаза
+		d := 3*dr*dr + 4*dg*dg + 2*db*db
+		if d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	pi.cache[key] = uint8(best)
+	return uint8(best)
+}
+
+// transparentIndex - индекс прозрачного цвета палитры или -1
+func transparentIndex(pal color.Palette) int {
+	for i, c := range pal {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// diffusionWeight - доля ошибки, уходящая в пиксель со сдвигом dx, dy
+type diffusionWeight struct {
+	dx, dy int
+	w      float64
+}
+
+// Ядра диффузии ошибки. У Аткинсона распределяется только 3/4 ошибки:
+// картинка контрастнее, а светлые и темные участки остаются чистыми.
+var (
+	floydSteinberg = []diffusionWeight{{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16}}
+	atkinson       = []diffusionWeight{{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8}, {-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8}, {0, 2, 1.0 / 8}}
+)
+
+// diffuseError - перевод в палитру с диффузией ошибки по ядру kernel
+// (nil - ближайший цвет без дизеринга). Прозрачные пиксели получают
+// прозрачный индекс и ошибку не распространяют.
+func diffuseError(img image.Image, pal color.Palette, kernel []diffusionWeight) *image.Paletted {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewPaletted(image.Rect(0, 0, w, h), pal)
+	pi := newPaletteIndex(pal)
+	ti := transparentIndex(pal)
+
+	// ошибки текущей и двух следующих строк (с запасом по 2 пикселя с краев)
+	const margin = 2
+	var rows [3][][3]float64
+	for i := range rows {
+		rows[i] = make([][3]float64, w+2*margin)
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			if ti >= 0 && uint32(c.A)*0x101 < alphaThreshold {
+				dst.Pix[y*dst.Stride+x] = uint8(ti)
+				continue
+			}
+
+			e := rows[0][x+margin]
+			r := clampInt(int(float64(c.R)+e[0]+0.5), 0, 255)
+			g := clampInt(int(float64(c.G)+e[1]+0.5), 0, 255)
+			bl := clampInt(int(float64(c.B)+e[2]+0.5), 0, 255)
+
+			idx := pi.nearest(r, g, bl)
+			dst.Pix[y*dst.Stride+x] = idx
+
+			p := pi.pal[idx]
+			er := float64(r - int(p.R))
+			eg := float64(g - int(p.G))
+			eb := float64(bl - int(p.B))
+			for _, k := range kernel {
+				t := &rows[k.dy][x+margin+k.dx]
+				t[0] += er * k.w
+				t[1] += eg * k.w
+				t[2] += eb * k.w
+			}
+		}
+		rows[0], rows[1], rows[2] = rows[1], rows[2], rows[0]
+		for i := range rows[2] {
+			rows[2][i] = [3]float64{}
+		}
+	}
+	return dst
+}
+
+// ditherOrdered - упорядоченный дизеринг матрицей Байера size×size: к цвету
+// добавляется порог из матрицы, размах которого - примерно шаг между
+// цветами палитры
+func ditherOrdered(img image.Image, pal color.Palette, size int) *image.Paletted {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewPaletted(image.Rect(0, 0, w, h), pal)
+	pi := newPaletteIndex(pal)
+	ti := transparentIndex(pal)
+
+	matrix := bayerMatrix(size)
+	levels := math.Cbrt(float64(len(pi.pal)))
+	spread := 255 / math.Max(levels-1, 1)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			if ti >= 0 && uint32(c.A)*0x101 < alphaThreshold {
+				dst.Pix[y*dst.Stride+x] = uint8(ti)
+				continue
+			}
+			d := spread * matrix[(y%size)*size+x%size]
+			r := clampInt(int(float64(c.R)+d+0.5), 0, 255)
+			g := clampInt(int(float64(c.G)+d+0.5), 0, 255)
+			bl := clampInt(int(float64(c.B)+d+0.5), 0, 255)
+			dst.Pix[y*dst.Stride+x] = pi.nearest(r, g, bl)
+		}
+	}
+	return dst
+}
+
+// bayerMatrix - пороги матрицы Байера size×size (степень двойки) в
+// диапазоне -0.5..0.5
+func bayerMatrix(size int) []float64 {
+	m := []int{0}
+	for n := 1; n < size; n *= 2 {
+		next := make([]int, 4*n*n)
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				v := 4 * m[y*n+x]
+				next[y*2*n+x] = v
+				next[y*2*n+x+n] = v + 2
+				next[(y+n)*2*n+x] = v + 3
+				next[(y+n)*2*n+x+n] = v + 1
+			}
+		}
+		m = next
+	}
+
+	out := make([]float64, len(m))
+	for i, v := range m {
+		out[i] = (float64(v)+0.5)/float64(len(m)) - 0.5
+	}
+	return out
+}
+
+// octreeNode - узел октодерева цветов: дети по старшим битам R, G, B
+type octreeNode struct {
+	children [8]*octreeNode
+	leaf     bool
+	count    int
+	r, g, b  int // суммы цветов пикселей листа
+}
+
+// octreeDepth - глубина дерева (бит на канал)
+const octreeDepth = 6
+
+// octreePalette - палитра до n цветов по октодереву: цвета раскладываются
+// по дереву, затем самые редкие узлы нижних уровней сливаются, пока листьев
+// не станет не больше n
+func octreePalette(img image.Image, n int) color.Palette {
+	root := &octreeNode{}
+	levels := make([][]*octreeNode, octreeDepth) // узлы с детьми по уровням
+	leaves := 0
+
+	b := img.Bounds()
+	step := 1
+	for (b.Dx()/step)*(b.Dy()/step) > quantizeMaxSamples {
+		step++
+	}
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if uint32(c.A)*0x101 < alphaThreshold {
+				continue
+			}
+			node := root
+			for level := 0; level < octreeDepth && !node.leaf; level++ {
+				shift := 7 - level
+				i := int(c.R>>shift&1)<<2 | int(c.G>>shift&1)<<1 | int(c.B>>shift&1)
+				if node.children[i] == nil {
+					child := &octreeNode{leaf: level == octreeDepth-1}
+					node.children[i] = child
+					if child.leaf {
+						leaves++
+					} else {
+						levels[level+1] = append(levels[level+1], child)
+					}
+				}
+				node = node.children[i]
+			}
+			if node == root {
+				continue // у пустого изображения листьев нет
+			}
+			node.count++
+			node.r += int(c.R)
+			node.g += int(c.G)
+			node.b += int(c.B)
+		}
+	}
+	levels[0] = []*octreeNode{root}
+
+	for level := octreeDepth - 1; level >= 1 && leaves > n; level-- {
+		nodes := levels[level]
+		for _, node := range nodes {
+			node.count = subtreeCount(node)
+		}
+		// первыми сливаются узлы с наименьшим числом пикселей
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+		for _, node := range nodes {
+			if leaves <= n {
+				break
+			}
+			leaves -= node.merge() - 1
+		}
+	}
+
+	// слияние корня оставило бы один цвет: при малых n вместо этого
+	// берутся n самых частых листьев
+	var nodes []*octreeNode
+	collectOctree(root, &nodes)
+	if len(nodes) == 0 {
+		return color.Palette{color.Black}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count > nodes[j].count })
+	pal := make(color.Palette, 0, n)
+	for _, node := range nodes[:min(n, len(nodes))] {
+		pal = append(pal, color.NRGBA{
+			uint8((node.r + node.count/2) / node.count),
+			uint8((node.g + node.count/2) / node.count),
+			uint8((node.b + node.count/2) / node.count),
+			255,
+		})
+	}
+	return pal
+}
+
+// subtreeCount - пикселей в поддереве
+func subtreeCount(node *octreeNode) int {
+	if node.leaf {
+		return node.count
+	}
+	n := 0
+	for _, c := range node.children {
+		if c != nil {
+			n += subtreeCount(c)
+		}
+	}
+	return n
+}
+
+// merge - превращение узла в лист с суммой всего поддерева; возвращает
+// число листьев, которые он заменил
+func (node *octreeNode) merge() int {
+	if node.leaf {
+		return 1
+	}
+	leaves := 0
+	for i, c := range node.children {
+		if c == nil {
+			continue
+		}
+		leaves += c.merge()
+		node.r += c.r
+		node.g += c.g
+		node.b += c.b
+		node.children[i] = nil
+	}
+	node.leaf = true
+	return leaves
+}
+
+// collectOctree - непустые листья дерева
+func collectOctree(node *octreeNode, leaves *[]*octreeNode) {
+	if node.leaf {
+		if node.count > 0 {
+			*leaves = append(*leaves, node)
+		}
+		return
+	}
+	for _, c := range node.children {
+		if c != nil {
+			collectOctree(c, leaves)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// Палитра не больше colors (вместе с прозрачным), каждый метод и дизеринг
+// дают изображение того же размера
+func TestQuantize(t *testing.T) {
+	opaque := photoImage(40, 30)
+	transparent := alphaImage(40, 30)
+	for _, method := range quantizeMethods {
+		for _, dither := range ditherMethods {
+			for _, colors := range []int{2, 16, 256} {
+				for name, img := range map[string]image.Image{"opaque": opaque, "alpha": transparent} {
+					out := quantize(img, quantizeSpec{Colors: colors, Method: method, Dither: dither, BayerSize: 4})
+					if len(out.Palette) > colors || len(out.Palette) == 0 {
+						t.Errorf("%s/%s/%d/%s: %d цветов", method, dither, colors, name, len(out.Palette))
+					}
+					if out.Bounds().Size() != img.Bounds().Size() {
+						t.Errorf("%s/%s/%d/%s: размер %v", method, dither, colors, name, out.Bounds())
+					}
+					ti := transparentIndex(out.Palette)
+					if (name == "alpha") != (ti >= 0) {
+						t.Errorf("%s/%s/%d/%s: прозрачный индекс %d", method, dither, colors, name, ti)
+					}
+					if name == "alpha" && out.ColorIndexAt(0, 0) != uint8(ti) {
+						t.Errorf("%s/%s/%d: прозрачный пиксель получил индекс %d", method, dither, colors, out.ColorIndexAt(0, 0))
+					}
+				}
+			}
+		}
+	}
+}
+
+// Изображение из нескольких цветов переводится в палитру без потерь
+func TestQuantizeExact(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	colors := []color.NRGBA{{255, 0, 0, 255}, {0, 200, 0, 255}, {0, 0, 255, 255}, {240, 240, 240, 255}}
+	for i := 0; i < 64; i++ {
+		src.SetNRGBA(i%8, i/8, colors[(i/8+i%8)%4])
+	}
+	for _, method := range quantizeMethods {
+		out := quantize(src, quantizeSpec{Colors: 4, Method: method, Dither: "floyd-steinberg"})
+		for i := 0; i < 64; i++ {
+			got := color.NRGBAModel.Convert(out.At(i%8, i/8)).(color.NRGBA)
+			if want := src.NRGBAAt(i%8, i/8); !closeColor(got, want) {
+				t.Fatalf("%s: (%d,%d) %v, ожидается %v", method, i%8, i/8, got, want)
+			}
+		}
+	}
+}
+
+// 1 бит: серый 50% с дизерингом дает примерно половину белых пикселей,
+// без дизеринга - одноцветное изображение
+func TestQuantizeDitherBlackWhite(t *testing.T) {
+	gray := flatImage(32, 32, color.NRGBA{128, 128, 128, 255})
+	bw := color.Palette{color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}}
+	for _, tt := range []struct {
+		dither   string
+		min, max int
+	}{
+		{"none", 0, 1024},
+		{"floyd-steinberg", 462, 562},
+		{"atkinson", 400, 624},
+		{"bayer", 462, 562},
+	} {
+		out := quantize(gray, quantizeSpec{Palette: bw, Dither: tt.dither, BayerSize: 8})
+		white := 0
+		for _, v := range out.Pix {
+			white += int(v)
+		}
+		if white < tt.min || white > tt.max {
+			t.Errorf("%s: %d белых из 1024", tt.dither, white)
+		}
+		if tt.dither == "none" && white != 0 && white != 1024 {
+			t.Errorf("без дизеринга %d белых из 1024", white)
+		}
+	}
+}
+
+func TestBayerMatrix(t *testing.T) {
+	for _, size := range bayerSizes {
+		m := bayerMatrix(size)
+		if len(m) != size*size {
+			t.Fatalf("%d: %d элементов", size, len(m))
+		}
+		seen := map[float64]bool{}
+		var sum float64
+		for _, v := range m {
+			if v <= -0.5 || v >= 0.5 || seen[v] {
+				t.Fatalf("%d: значение %g вне диапазона или повторяется", size, v)
+			}
+			seen[v] = true
+			sum += v
+		}
+		if sum > 1e-9 || sum < -1e-9 {
+			t.Errorf("%d: сумма порогов %g, ожидается 0", size, sum)
+		}
+	}
+}
+
+func TestQuantizeStep(t *testing.T) {
+	tests := []struct {
+		params  string
+		wantErr string
+	}{
+		{`{"colors":256}`, ""},
+		{`{"colors":8,"method":"octree","dither":"atkinson"}`, ""},
+		{`{"palette":["black","white"],"dither":"bayer","bayer_size":2}`, ""},
+		{`{"colors":1}`, "colors"},
+		{`{"colors":257}`, "colors"},
+		{`{"method":"kmeans"}`, "method"},
+		{`{"dither":"random"}`, "dither"},
+		{`{"dither":"bayer","bayer_size":3}`, "bayer_size"},
+		{`{"palette":["black"]}`, "palette"},
+		{`{"palette":["black","nope"]}`, "palette[1]"},
+	}
+	for _, tt := range tests {
+		p, err := parsePipeline(`[{"op":"quantize",`+tt.params[1:]+`]`, defaultOptions(t))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: ошибка %v, ожидается с %q", tt.params, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.params, err)
+			continue
+		}
+		out, err := p.run(testImage(10, 10), newRunContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := out.(*image.Paletted); !ok {
+			t.Errorf("%s: результат %T, ожидается палитра", tt.params, out)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+)
+
+// resampleKernel - ядро интерполяции для изменения размера
+type resampleKernel struct {
+	Name    string
+	Support float64 // радиус ядра в пикселях источника (при увеличении)
+	At      func(x float64) float64
+}
+
+// defaultResample - ядро по умолчанию
+const defaultResample = "lanczos3"
+
+var resampleKernels = map[string]*resampleKernel{
+	"nearest": {Name: "nearest", Support: 0},
+	"bilinear": {Name: "bilinear", Support: 1, At: func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return 1 - x
+		}
+		return 0
+	}},
+	"catmullrom": {Name: "catmullrom", Support: 2, At: func(x float64) float64 {
+		return bcSpline(x, 0, 0.5)
+	}},
+	"mitchell": {Name: "mitchell", Support: 2, At: func(x float64) float64 {
+		return bcSpline(x, 1.0/3, 1.0/3)
+	}},
+	"lanczos3": {Name: "lanczos3", Support: 3, At: func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 3 {
+			return sinc(x) * sinc(x/3)
+		}
+		return 0
+	}},
+}
+
+// parseResample - выбор ядра по имени ("" - ядро по умолчанию)
+func parseResample(name string) (*resampleKernel, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "":
+		name = defaultResample
+	case "bicubic":
+		name = "catmullrom"
+	case "lanczos":
+		name = "lanczos3"
+	case "linear":
+		name = "bilinear"
+	}
+
+	k, ok := resampleKernels[name]
+	if !ok {
+		names := make([]string, 0, len(resampleKernels))
+		for n := range resampleKernels {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("resample: неизвестное ядро %q (доступны: %s)", name, strings.Join(names, ", "))
+	}
+	return k, nil
+}
+
+// bcSpline - семейство кубических фильтров Митчелла-Нетравали
+func bcSpline(x, b, c float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	}
+	return 0
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// resampleWeights - вклад пикселей источника в один пиксель результата
+type resampleWeights struct {
+	index  []int
+	weight []float32
+}
+
+// computeWeights - веса по одной оси. При уменьшении ядро растягивается
+// в scale раз, так что каждый пиксель результата усредняет всю свою область.
+func computeWeights(srcSize, dstSize int, k *resampleKernel) []resampleWeights {
+	scale := float64(srcSize) / float64(dstSize)
+	filterScale := math.Max(scale, 1)
+	support := k.Support * filterScale
+
+	out := make([]resampleWeights, dstSize)
+	for i := range out {
+		center := (float64(i)+0.5)*scale - 0.5
+		left := int(math.Ceil(center - support))
+		right := int(math.Floor(center + support))
+
+		var sum float64
+		ws := make([]float64, 0, right-left+1)
+		for j := left; j <= right; j++ {
+			w := k.At((float64(j) - center) / filterScale)
+			ws = append(ws, w)
+			sum += w
+		}
+
+		rw := resampleWeights{}
+		for n, w := range ws {
+			if w == 0 {
+				continue
+			}
+			j := left + n
+			if j < 0 {
+				j = 0
+			} else if j >= srcSize {
+				j = srcSize - 1
+			}
+			if sum != 0 {
+				w /= sum
+			}
+			rw.index = append(rw.index, j)
+			rw.weight = append(rw.weight, float32(w))
+		}
+		if len(rw.index) == 0 {
+			// ядро не задело ни одного пикселя - берем ближайший
+			j := int(math.Min(math.Max(math.Round(center), 0), float64(srcSize-1)))
+			rw.index = []int{j}
+			rw.weight = []float32{1}
+		}
+		out[i] = rw
+	}
+	return out
+}
+
+// resampleImage - сепарабельная свертка: сначала по горизонтали, затем по вертикали.
+// Вычисления ведутся в предумноженных (premultiplied) значениях.
+func resampleImage(img image.Image, width, height int, k *resampleKernel) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if k.At == nil {
+		return resizeNearest(img, width, height)
+	}
+
+	src := toPremul(img)
+
+	// Проход по горизонтали: h строк по width пикселей
+	xw := computeWeights(w, width, k)
+	tmp := make([]float32, width*h*4)
+	for y := 0; y < h; y++ {
+		row := src[y*w*4:]
+		for x, cw := range xw {
+			var r, g, b, a float32
+			for n, j := range cw.index {
+				wt := cw.weight[n]
+				p := row[j*4:]
+				r += p[0] * wt
+				g += p[1] * wt
+				b += p[2] * wt
+				a += p[3] * wt
+			}
+			i := (y*width + x) * 4
+			tmp[i], tmp[i+1], tmp[i+2], tmp[i+3] = r, g, b, a
+		}
+	}
+
+	// Проход по вертикали
+	yw := computeWeights(h, height, k)
+	dst := make([]float32, width*height*4)
+	for y, cw := range yw {
+		for x := 0; x < width; x++ {
+			var r, g, b, a float32
+			for n, j := range cw.index {
+				wt := cw.weight[n]
+				p := tmp[(j*width+x)*4:]
+				r += p[0] * wt
+				g += p[1] * wt
+				b += p[2] * wt
+				a += p[3] * wt
+			}
+
+			// Отрицательные лепестки ядра могут вывести значения за диапазон
+			a = clampF32(a, 0, 65535)
+			i := (y*width + x) * 4
+			dst[i] = clampF32(r, 0, a)
+			dst[i+1] = clampF32(g, 0, a)
+			dst[i+2] = clampF32(b, 0, a)
+			dst[i+3] = a
+		}
+	}
+	return fromPremul(dst, width, height, isDeep(img))
+}
+
+// resizeNearest - выборка ближайшего соседа (без сглаживания)
+func resizeNearest(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := newCanvas(img, image.Rect(0, 0, width, height))
+
+	xRatio := float64(w) / float64(width)
+	yRatio := float64(h) / float64(height)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			srcX := int(float64(x) * xRatio)
+			srcY := int(float64(y) * yRatio)
+
+			if srcX < w && srcY < h {
+				dst.Set(x, y, img.At(bounds.Min.X+srcX, bounds.Min.Y+srcY))
+			}
+		}
+	}
+
+	return dst
+}
+
+func clampF32(v, lo, hi float32) float32 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
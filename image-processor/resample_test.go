@@ -0,0 +1,88 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseResample(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"", defaultResample},
+		{"Nearest", "nearest"},
+		{" bicubic ", "catmullrom"},
+		{"lanczos", "lanczos3"},
+		{"linear", "bilinear"},
+		{"mitchell", "mitchell"},
+		{"sinc", ""},
+	}
+	for _, tt := range tests {
+		k, err := parseResample(tt.name)
+		if tt.want == "" {
+			if err == nil || !strings.Contains(err.Error(), "nearest") {
+				t.Errorf("parseResample(%q): ошибка %v, ожидается список ядер", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || k.Name != tt.want {
+			t.Errorf("parseResample(%q) = %v, %v, ожидается %s", tt.name, k, err, tt.want)
+		}
+	}
+}
+
+// Сумма весов каждого пикселя результата - 1, индексы в пределах источника
+func TestComputeWeights(t *testing.T) {
+	for name, k := range resampleKernels {
+		if k.At == nil {
+			continue // nearest - без весов
+		}
+		for _, size := range [][2]int{{10, 3}, {3, 10}, {7, 7}, {1, 5}, {1000, 1}} {
+			for i, rw := range computeWeights(size[0], size[1], k) {
+				var sum float64
+				for n, j := range rw.index {
+					if j < 0 || j >= size[0] {
+						t.Fatalf("%s %d→%d: индекс %d вне источника", name, size[0], size[1], j)
+					}
+					sum += float64(rw.weight[n])
+				}
+				if math.Abs(sum-1) > 1e-4 {
+					t.Errorf("%s %d→%d: сумма весов пикселя %d = %g", name, size[0], size[1], i, sum)
+				}
+			}
+		}
+	}
+}
+
+// Однотонное изображение остается однотонным при любом ядре, в том числе
+// полупрозрачное: цвет прозрачных пикселей не протекает
+func TestResampleImageFlat(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 9, 5))
+	fill := color.NRGBA{200, 100, 50, 128}
+	for i := 0; i < len(src.Pix); i += 4 {
+		copy(src.Pix[i:], []uint8{fill.R, fill.G, fill.B, fill.A})
+	}
+	for name, k := range resampleKernels {
+		for _, size := range []image.Point{{4, 3}, {20, 11}, {1, 1}} {
+			out := resampleImage(src, size.X, size.Y, k)
+			if out.Bounds().Size() != size {
+				t.Errorf("%s: размер %v, ожидается %v", name, out.Bounds().Size(), size)
+				continue
+			}
+			c := color.NRGBAModel.Convert(out.At(size.X/2, size.Y/2)).(color.NRGBA)
+			if absDiff(c.R, fill.R) > 1 || absDiff(c.G, fill.G) > 1 || absDiff(c.B, fill.B) > 1 || c.A != fill.A {
+				t.Errorf("%s → %v: цвет %v, ожидается %v", name, size, c, fill)
+			}
+		}
+	}
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
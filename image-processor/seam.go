@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Параметры контентно-зависимого изменения размера (seam carving)
+const (
+	seamProtectEnergy = 1e6 // добавка энергии для защищенных маской пикселей
+	seamRemoveEnergy  = 1e6 // вычитается у пикселей, отмеченных к удалению
+	seamMaxWork       = 3e9 // предел w*h*число швов, чтобы не зависать на больших кадрах
+)
+
+// seamGrid - изображение в виде, удобном для удаления и вставки швов
+type seamGrid struct {
+	w, h int
+	pix  []float32 // RGBA (premultiplied), 4 значения на пиксель
+	luma []float32
+	bias []float32 // поправка энергии из маски
+	orig []int32   // исходный столбец пикселя (заполняется для копии в insertSeams)
+}
+
+// seamCarve - изменение размера удалением/вставкой швов с наименьшей энергией.
+// Маска: зеленые области защищаются, красные удаляются в первую очередь.
+func seamCarve(img image.Image, width, height int, mask image.Image) (image.Image, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if width <= 0 {
+		width = w
+	}
+	if height <= 0 {
+		height = h
+	}
+
+	work := float64(w) * float64(h) * float64(absInt(w-width)+absInt(h-height))
+	if work > seamMaxWork {
+		return nil, fmt.Errorf("seam: слишком большой объем работы для %d×%d → %d×%d, уменьшите изображение заранее",
+			w, h, width, height)
+	}
+
+	g := newSeamGrid(img, mask)
+	if width != w {
+		if err := g.resizeWidth(width); err != nil {
+			return nil, err
+		}
+	}
+	if height != h {
+		g = g.transpose()
+		if err := g.resizeWidth(height); err != nil {
+			return nil, err
+		}
+		g = g.transpose()
+	}
+	return fromPremul(g.pix, g.w, g.h, isDeep(img)), nil
+}
+
+func newSeamGrid(img image.Image, mask image.Image) *seamGrid {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	g := &seamGrid{
+		w:    w,
+		h:    h,
+		pix:  make([]float32, w*h*4),
+		luma: make([]float32, w*h),
+		bias: make([]float32, w*h),
+		orig: make([]int32, w*h),
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, gr, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			i := y*w + x
+			g.pix[i*4], g.pix[i*4+1], g.pix[i*4+2], g.pix[i*4+3] = float32(r), float32(gr), float32(b), float32(a)
+			g.luma[i] = float32(0.299*float64(r)+0.587*float64(gr)+0.114*float64(b)) / 65535
+		}
+	}
+
+	if mask != nil {
+		// Маска приводится к размеру изображения
+		m := mask
+		if mb := mask.Bounds(); mb.Dx() != w || mb.Dy() != h {
+			m = resampleImage(mask, w, h, resampleKernels["nearest"])
+		}
+		mb := m.Bounds()
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, gr, _, a := m.At(mb.Min.X+x, mb.Min.Y+y).RGBA()
+				if a < 0x8000 {
+					continue
+				}
+				switch {
+				case gr > 0x8000 && gr > r*2:
+					g.bias[y*w+x] = seamProtectEnergy
+				case r > 0x8000 && r > gr*2:
+					g.bias[y*w+x] = -seamRemoveEnergy
+				}
+			}
+		}
+	}
+	return g
+}
+
+// energy - градиент яркости плюс поправка маски
+func (g *seamGrid) energy() []float64 {
+	e := make([]float64, g.w*g.h)
+	for y := 0; y < g.h; y++ {
+		up, down := max(y-1, 0), min(y+1, g.h-1)
+		for x := 0; x < g.w; x++ {
+			left, right := max(x-1, 0), min(x+1, g.w-1)
+			dx := g.luma[y*g.w+right] - g.luma[y*g.w+left]
+			dy := g.luma[down*g.w+x] - g.luma[up*g.w+x]
+			e[y*g.w+x] = math.Abs(float64(dx)) + math.Abs(float64(dy)) + float64(g.bias[y*g.w+x])
+		}
+	}
+	return e
+}
+
+// findSeam - вертикальный шов минимальной энергии (по столбцу на строку)
+func (g *seamGrid) findSeam() []int {
+	e := g.energy()
+	w, h := g.w, g.h
+
+	cost := make([]float64, w*h)
+	copy(cost[:w], e[:w])
+	for y := 1; y < h; y++ {
+		for x := 0; x < w; x++ {
+			best := cost[(y-1)*w+x]
+			if x > 0 && cost[(y-1)*w+x-1] < best {
+				best = cost[(y-1)*w+x-1]
+			}
+			if x < w-1 && cost[(y-1)*w+x+1] < best {
+				best = cost[(y-1)*w+x+1]
+			}
+			cost[y*w+x] = e[y*w+x] + best
+		}
+	}
+
+	seam := make([]int, h)
+	last := (h - 1) * w
+	for x := 1; x < w; x++ {
+		if cost[last+x] < cost[last+seam[h-1]] {
+			seam[h-1] = x
+		}
+	}
+	for y := h - 2; y >= 0; y-- {
+		x := seam[y+1]
+		best := x
+		if x > 0 && cost[y*w+x-1] < cost[y*w+best] {
+			best = x - 1
+		}
+		if x < w-1 && cost[y*w+x+1] < cost[y*w+best] {
+			best = x + 1
+		}
+		seam[y] = best
+	}
+	return seam
+}
+
+// removeSeam - удаление шва, ширина уменьшается на 1
+func (g *seamGrid) removeSeam(seam []int) {
+	nw := g.w - 1
+	for y := 0; y < g.h; y++ {
+		sx := seam[y]
+		for x := 0; x < nw; x++ {
+			from := y*g.w + x
+			if x >= sx {
+				from++
+			}
+			to := y*nw + x
+			copy(g.pix[to*4:to*4+4], g.pix[from*4:from*4+4])
+			g.luma[to] = g.luma[from]
+			g.bias[to] = g.bias[from]
+			g.orig[to] = g.orig[from]
+		}
+	}
+	g.w = nw
+	g.pix = g.pix[:nw*g.h*4]
+	g.luma = g.luma[:nw*g.h]
+	g.bias = g.bias[:nw*g.h]
+	g.orig = g.orig[:nw*g.h]
+}
+
+// resizeWidth - сужение удалением швов или расширение вставкой
+func (g *seamGrid) resizeWidth(width int) error {
+	for g.w > width {
+		if g.w <= 1 {
+			return errors.New("seam: изображение слишком узкое")
+		}
+		g.removeSeam(g.findSeam())
+	}
+	for g.w < width {
+		// За один проход вставляется не больше половины ширины,
+		// иначе одни и те же швы растягиваются в полосы
+		n := min(width-g.w, max(g.w/2, 1))
+		g.insertSeams(n)
+	}
+	return nil
+}
+
+// insertSeams - поиск n швов на копии и их дублирование в исходной сетке
+func (g *seamGrid) insertSeams(n int) {
+	tmp := &seamGrid{
+		w:    g.w,
+		h:    g.h,
+		pix:  append([]float32(nil), g.pix...),
+		luma: append([]float32(nil), g.luma...),
+		bias: append([]float32(nil), g.bias...),
+		orig: make([]int32, len(g.orig)),
+	}
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			tmp.orig[y*g.w+x] = int32(x)
+		}
+	}
+
+	// dup[y*w+x] - сколько раз продублировать пиксель
+	dup := make([]int, g.w*g.h)
+	for i := 0; i < n && tmp.w > 1; i++ {
+		seam := tmp.findSeam()
+		for y, x := range seam {
+			dup[y*g.w+int(tmp.orig[y*tmp.w+x])]++
+		}
+		tmp.removeSeam(seam)
+	}
+
+	nw := g.w + n
+	pix := make([]float32, nw*g.h*4)
+	luma := make([]float32, nw*g.h)
+	bias := make([]float32, nw*g.h)
+	orig := make([]int32, nw*g.h)
+	for y := 0; y < g.h; y++ {
+		to := y * nw
+		for x := 0; x < g.w; x++ {
+			from := y*g.w + x
+			copy(pix[to*4:to*4+4], g.pix[from*4:from*4+4])
+			luma[to], bias[to], orig[to] = g.luma[from], g.bias[from], g.orig[from]
+			to++
+
+			// Вставленный пиксель - среднее с правым соседом
+			right := y*g.w + min(x+1, g.w-1)
+			for k := 0; k < dup[from]; k++ {
+				for c := 0; c < 4; c++ {
+					pix[to*4+c] = (g.pix[from*4+c] + g.pix[right*4+c]) / 2
+				}
+				luma[to] = (g.luma[from] + g.luma[right]) / 2
+				// вставленные пиксели защищаются, чтобы следующий проход выбрал другие швы
+				bias[to] = g.bias[from] + seamProtectEnergy
+				orig[to] = g.orig[from]
+				to++
+			}
+		}
+		// если швов нашлось меньше n, строка добивается повтором крайнего пикселя
+		for ; to < (y+1)*nw; to++ {
+			copy(pix[to*4:to*4+4], pix[(to-1)*4:(to-1)*4+4])
+			luma[to], bias[to], orig[to] = luma[to-1], bias[to-1], orig[to-1]
+		}
+	}
+
+	g.w, g.pix, g.luma, g.bias, g.orig = nw, pix, luma, bias, orig
+}
+
+// transpose - поворот сетки относительно диагонали (строки ↔ столбцы)
+func (g *seamGrid) transpose() *seamGrid {
+	t := &seamGrid{
+		w:    g.h,
+		h:    g.w,
+		pix:  make([]float32, len(g.pix)),
+		luma: make([]float32, len(g.luma)),
+		bias: make([]float32, len(g.bias)),
+		orig: make([]int32, len(g.orig)),
+	}
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			from, to := y*g.w+x, x*t.w+y
+			copy(t.pix[to*4:to*4+4], g.pix[from*4:from*4+4])
+			t.luma[to], t.bias[to] = g.luma[from], g.bias[from]
+		}
+	}
+	return t
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
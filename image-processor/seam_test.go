@@ -0,0 +1,112 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// stripeImage - ровный серый фон и вертикальная полоса из красного, зеленого
+// и синего столбцов начиная со столбца x
+func stripeImage(w, h, x int) *image.NRGBA {
+	stripe := []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for py := 0; py < h; py++ {
+		for px := 0; px < w; px++ {
+			c := color.NRGBA{200, 200, 200, 255}
+			if px >= x && px < x+len(stripe) {
+				c = stripe[px-x]
+			}
+			img.SetNRGBA(px, py, c)
+		}
+	}
+	return img
+}
+
+// stripeColumns - число столбцов, в средней строке которых не серый пиксель
+func stripeColumns(img image.Image) int {
+	b := img.Bounds()
+	n := 0
+	for x := b.Min.X; x < b.Max.X; x++ {
+		c := color.NRGBAModel.Convert(img.At(x, b.Min.Y+b.Dy()/2)).(color.NRGBA)
+		if c.R != c.G || c.G != c.B {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSeamCarveSize(t *testing.T) {
+	tests := []struct {
+		w, h, width, height int
+	}{
+		{30, 20, 20, 20},
+		{30, 20, 45, 20},
+		{30, 20, 30, 10},
+		{30, 20, 30, 35},
+		{30, 20, 10, 40},
+		{30, 20, 0, 15}, // 0 - сторона не меняется
+	}
+	for _, tt := range tests {
+		out, err := seamCarve(testImage(tt.w, tt.h), tt.width, tt.height, nil)
+		if err != nil {
+			t.Errorf("%d×%d → %d×%d: %v", tt.w, tt.h, tt.width, tt.height, err)
+			continue
+		}
+		want := image.Pt(tt.width, tt.height)
+		if tt.width == 0 {
+			want.X = tt.w
+		}
+		if got := out.Bounds().Size(); got != want {
+			t.Errorf("%d×%d → %d×%d: размер %v", tt.w, tt.h, tt.width, tt.height, got)
+		}
+	}
+}
+
+// Швы обходят цветную полосу, а красная маска удаляет ее первой
+func TestSeamCarveContent(t *testing.T) {
+	img := stripeImage(40, 20, 10)
+
+	out, err := seamCarve(img, 25, 20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := stripeColumns(out); n != 3 {
+		t.Errorf("после сужения полоса шириной %d, ожидается 3", n)
+	}
+
+	mask := image.NewNRGBA(img.Bounds())
+	for y := 0; y < 20; y++ {
+		for x := 10; x < 13; x++ {
+			mask.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+	out, err = seamCarve(img, 37, 20, mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := stripeColumns(out); n != 0 {
+		t.Errorf("с маской удаления осталось %d столбцов полосы", n)
+	}
+}
+
+func TestFindSeamConnected(t *testing.T) {
+	g := newSeamGrid(testImage(25, 15), nil)
+	seam := g.findSeam()
+	if len(seam) != g.h {
+		t.Fatalf("длина шва %d, ожидается %d", len(seam), g.h)
+	}
+	for y, x := range seam {
+		if x < 0 || x >= g.w || (y > 0 && absInt(x-seam[y-1]) > 1) {
+			t.Fatalf("шов разрывается в строке %d: %v", y, seam)
+		}
+	}
+}
+
+func TestSeamCarveLimits(t *testing.T) {
+	if _, err := seamCarve(image.NewNRGBA(image.Rect(0, 0, 4000, 4000)), 2000, 0, nil); err == nil ||
+		!strings.Contains(err.Error(), "объем работы") {
+		t.Errorf("ошибка %v, ожидается отказ по объему работы", err)
+	}
+}
@@ -6,12 +6,11 @@ import (
 	"fmt"
 	"image"
 	"image/color"
-	"image/jpeg"
-	"image/png"
 	"io"
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -24,6 +23,8 @@ func main() {
 	// Создаем необходимые папки
 	os.MkdirAll("uploads", 0755)
 	os.MkdirAll("static", 0755)
+	os.MkdirAll(lutDir, 0755)
+	loadLUTs()
 
 	// Проверяем статические файлы
 	if _, err := os.Stat("static/index.html"); os.IsNotExist(err) {
@@ -36,13 +37,14 @@ func main() {
 	http.HandleFunc("/api/upload", handleUpload)
 	http.HandleFunc("/api/process", handleProcess)
 	http.HandleFunc("/api/filters", handleFilters)
+	http.HandleFunc("/api/luts", handleLUTUpload)
 	http.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads"))))
 
 	// Запуск сервера
 	fmt.Println("✅ Сервер готов к работе!")
 	fmt.Println("📌 Функции:")
 	fmt.Println("  • Загрузка изображений")
-	fmt.Println("  • 6 фильтров")
+	fmt.Printf("  • Фильтры: %d (LUT: %d)\n", len(allFilters()), len(lutFilters()))
 	fmt.Println("  • Поворот и отражение")
 	fmt.Println("  • Изменение размера")
 	fmt.Println("  • Скачивание результата")
@@ -85,6 +87,16 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	// Проверяем формат
+	if _, err := detectFormat(file); err != nil {
+		sendUnsupportedFormat(w)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		sendJSONError(w, "Ошибка чтения", http.StatusInternalServerError)
+		return
+	}
+
 	// Сохраняем файл
 	filename := fmt.Sprintf("%d_%s", time.Now().Unix(), sanitizeFilename(header.Filename))
 	filepath := "uploads/" + filename
@@ -113,6 +125,60 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleLUTUpload - загрузка 3D LUT (.cube): поле "lut" - файл, "name" -
+// имя (по умолчанию - имя файла). Таблица становится фильтром "lut:<имя>".
+func handleLUTUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		sendJSONError(w, "Только POST метод", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(20 << 20); err != nil {
+		sendJSONError(w, "Файл слишком большой (макс 20MB)", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("lut")
+	if err != nil {
+		sendJSONError(w, "Файл LUT не найден", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		sendJSONError(w, "Ошибка чтения", http.StatusInternalServerError)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		name = strings.ToLower(strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename)))
+	}
+	if err := checkLUTName(name); err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lut, err := parseCube(data)
+	if err != nil {
+		sendJSONError(w, "LUT: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := os.WriteFile(lutPath(name), data, 0644); err != nil {
+		sendJSONError(w, "Ошибка сохранения", http.StatusInternalServerError)
+		return
+	}
+	storeLUT(name, lut)
+
+	fmt.Printf("[LUT] %s загружен как %s%s\n", header.Filename, lutPrefix, name)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "LUT успешно загружен",
+		"filter":  lutPrefix + name,
+	})
+}
+
 // handleProcess - обработка изображения
 func handleProcess(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
@@ -143,9 +209,6 @@ func handleProcess(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Получаем параметры
-	width, _ := strconv.Atoi(r.FormValue("width"))
-	height, _ := strconv.Atoi(r.FormValue("height"))
-
 	quality, err := strconv.Atoi(r.FormValue("quality"))
 	if err != nil || quality <= 0 || quality > 100 {
 		quality = 85
@@ -155,67 +218,189 @@ func handleProcess(w http.ResponseWriter, r *http.Request) {
 	if format == "" {
 		format = "jpg"
 	}
+	// format=auto - выбор по заголовку Accept после обработки
+	auto := strings.EqualFold(format, formatAuto)
+	if !auto {
+		out, err := lookupOutputFormat(format)
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format = out.Name
+	}
 
-	filter := r.FormValue("filter")
-	rotate, _ := strconv.ParseFloat(r.FormValue("rotate"), 64)
-	flip := r.FormValue("flip")
+	// autorient=false - пиксели как есть, без учета EXIF Orientation
+	autorient := true
+	if v := r.FormValue("autorient"); v != "" {
+		autorient, err = strconv.ParseBool(v)
+		if err != nil {
+			sendJSONError(w, "autorient: ожидается true или false", http.StatusBadRequest)
+			return
+		}
+	}
 
-	// Декодируем изображение
-	img, _, err := image.Decode(bytes.NewReader(imgData))
+	metadata, err := parseMetadataPolicy(r.FormValue("metadata"))
 	if err != nil {
-		http.Error(w, "Неверный формат изображения", http.StatusBadRequest)
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Применяем операции
-	if rotate != 0 {
-		img = rotateImage(img, rotate)
+	// profile - целевой цветовой профиль (keep - без преобразования),
+	// embedprofile - встроить в результат профиль его пикселей
+	target, err := parseTargetProfile(r.FormValue("profile"))
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	if flip != "" && flip != "none" {
-		img = flipImage(img, flip)
+	embedProfile := false
+	if v := r.FormValue("embedprofile"); v != "" {
+		embedProfile, err = strconv.ParseBool(v)
+		if err != nil {
+			sendJSONError(w, "embedprofile: ожидается true или false", http.StatusBadRequest)
+			return
+		}
 	}
 
-	if filter != "" && filter != "none" {
-		img = applyFilter(img, filter)
+	opts, err := parsePipelineOptions(r)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if width > 0 || height > 0 {
-		img = resizeImage(img, width, height)
+	// Конвейер операций: JSON "operations" или старые поля формы
+	var ops pipeline
+	if data := r.FormValue("operations"); data != "" {
+		ops, err = parsePipeline(data, opts)
+		if err != nil {
+			sendStepError(w, err, http.StatusBadRequest)
+			return
+		}
+	} else {
+		ops, err = legacyPipeline(r, opts)
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Кодируем результат
-	result, err := encodeImage(img, format, quality)
+	rc := newRunContext()
+	var result []byte
+
+	// Анимированный GIF в GIF обрабатывается покадрово
+	anim, err := decodeAnimation(imgData)
 	if err != nil {
-		http.Error(w, "Ошибка кодирования", http.StatusInternalServerError)
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if anim != nil && (auto || format == "gif") {
+		format = "gif"
+		anim, err = processAnimation(anim, ops, rc)
+		if err != nil {
+			sendStepError(w, err, runErrorStatus(err))
+			return
+		}
+
+		result, err = encodeAnimation(anim)
+		if err != nil {
+			http.Error(w, "Ошибка кодирования", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		// Декодируем изображение (у анимации - первый кадр)
+		page, _ := strconv.Atoi(r.FormValue("page"))
+		img, srcFormat, err := decodeImage(imgData, page, autorient)
+		if err == errUnsupportedFormat {
+			sendUnsupportedFormat(w)
+			return
+		}
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Переводим цвета из встроенного профиля в целевой до фильтров
+		srcMeta := readMetadata(imgData, srcFormat, page)
+		img, pixelICC, converted := manageColor(img, srcMeta.ICC, target, rc)
+
+		// Применяем операции
+		img, err = ops.run(img, rc)
+		if err != nil {
+			sendStepError(w, err, runErrorStatus(err))
+			return
+		}
+
+		if auto {
+			out := negotiateFormat(img, r.Header.Get("Accept"))
+			if out == nil {
+				sendJSONError(w, "Клиент не принимает ни один подходящий формат (Accept)", http.StatusNotAcceptable)
+				return
+			}
+			format = out.Name
+		}
+
+		// Кодируем результат
+		result, err = encodeImage(img, format, quality, opts.Background)
+		if err != nil {
+			http.Error(w, "Ошибка кодирования", http.StatusInternalServerError)
+			return
+		}
+
+		// Переносим метаданные по выбранной политике
+		if metadata != metadataStripAll || embedProfile {
+			meta := srcMeta.withPolicy(metadata, autorient, img.Bounds().Size())
+			switch {
+			case embedProfile:
+				meta.ICC = pixelICC
+			case converted:
+				// исходный профиль к пикселям больше не относится
+				meta.ICC = nil
+			default:
+				meta.ICC = rgbICC(meta.ICC)
+			}
+			result = embedMetadata(result, format, meta)
+		}
+	}
+
 	// Отправляем результат
+	for key, values := range rc.Header {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	if auto {
+		w.Header().Add("Vary", "Accept")
+	}
+	w.Header().Set("X-Output-Format", format)
 	w.Header().Set("Content-Type", getContentType(format))
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"processed_%s\"", header.Filename))
 	w.Write(result)
 
 	elapsed := time.Since(startTime)
-	fmt.Printf("[PROCESS] %s -> %s (%s) за %v\n", header.Filename, format, filter, elapsed)
+	fmt.Printf("[PROCESS] %s -> %s (%s) за %v\n", header.Filename, format, ops.describe(), elapsed)
 }
 
 // handleFilters - список фильтров
 func handleFilters(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	filters := []map[string]string{
-		{"id": "none", "name": "Без фильтра", "icon": "🔄"},
-		{"id": "grayscale", "name": "Черно-белый", "icon": "⚫"},
-		{"id": "sepia", "name": "Сепия", "icon": "🟤"},
-		{"id": "invert", "name": "Инверсия", "icon": "🔄"},
-		{"id": "cool", "name": "Холодный", "icon": "❄️"},
-		{"id": "warm", "name": "Теплый", "icon": "🔥"},
+	// params - диапазоны параметров для ползунков
+	list := allFilters()
+	filters := make([]map[string]interface{}, 0, len(list))
+	for _, f := range list {
+		filters = append(filters, map[string]interface{}{
+			"id":     f.ID(),
+			"name":   f.Name(),
+			"icon":   f.Icon(),
+			"params": filterSchema(f),
+		})
 	}
 
+	// operations - операции конвейера со сложными параметрами (кривые, ядро свертки)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"filters": filters,
+		"success":    true,
+		"filters":    filters,
+		"operations": append(toneOperations(), convolveOperation(), gradientMapOperation()),
 	})
 }
 
@@ -233,7 +418,7 @@ func rotateImage(img image.Image, angle float64) image.Image {
 	newW := int(math.Ceil(math.Abs(float64(w)*cos) + math.Abs(float64(h)*sin)))
 	newH := int(math.Ceil(math.Abs(float64(w)*sin) + math.Abs(float64(h)*cos)))
 
-	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	dst := newCanvas(img, image.Rect(0, 0, newW, newH))
 
 	cx, cy := float64(w)/2, float64(h)/2
 	newCx, newCy := float64(newW)/2, float64(newH)/2
@@ -244,7 +429,7 @@ func rotateImage(img image.Image, angle float64) image.Image {
 			srcY := -(float64(x)-newCx)*sin + (float64(y)-newCy)*cos + cy
 
 			if srcX >= 0 && srcX < float64(w) && srcY >= 0 && srcY < float64(h) {
-				dst.Set(x, y, img.At(int(srcX), int(srcY)))
+				dst.Set(x, y, img.At(bounds.Min.X+int(srcX), bounds.Min.Y+int(srcY)))
 			}
 		}
 	}
@@ -254,7 +439,7 @@ func rotateImage(img image.Image, angle float64) image.Image {
 
 func flipImage(img image.Image, direction string) image.Image {
 	bounds := img.Bounds()
-	dst := image.NewRGBA(bounds)
+	dst := newCanvas(img, bounds)
 	w, h := bounds.Dx(), bounds.Dy()
 
 	for y := 0; y < h; y++ {
@@ -275,16 +460,17 @@ func flipImage(img image.Image, direction string) image.Image {
 				srcX, srcY = x, y
 			}
 
-			dst.Set(x, y, img.At(srcX, srcY))
+			dst.Set(bounds.Min.X+x, bounds.Min.Y+y, img.At(bounds.Min.X+srcX, bounds.Min.Y+srcY))
 		}
 	}
 
 	return dst
 }
 
-func resizeImage(img image.Image, width, height int) image.Image {
+// resizeImage - изменение размера; если одна из сторон 0, пропорции сохраняются
+func resizeImage(img image.Image, width, height int, kernel *resampleKernel) (image.Image, error) {
 	if width <= 0 && height <= 0 {
-		return img
+		return img, nil
 	}
 
 	bounds := img.Bounds()
@@ -297,174 +483,42 @@ func resizeImage(img image.Image, width, height int) image.Image {
 		ratio := float64(width) / float64(w)
 		height = int(float64(h) * ratio)
 	}
-
-	dst := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	xRatio := float64(w) / float64(width)
-	yRatio := float64(h) / float64(height)
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			srcX := int(float64(x) * xRatio)
-			srcY := int(float64(y) * yRatio)
-
-			if srcX < w && srcY < h {
-				dst.Set(x, y, img.At(srcX, srcY))
-			}
-		}
-	}
-
-	return dst
-}
-
-func applyFilter(img image.Image, filter string) image.Image {
-	switch filter {
-	case "grayscale":
-		return applyGrayscale(img)
-	case "sepia":
-		return applySepia(img)
-	case "invert":
-		return applyInvert(img)
-	case "cool":
-		return applyCool(img)
-	case "warm":
-		return applyWarm(img)
-	default:
-		return img
+	if width < 1 {
+		width = 1
 	}
-}
-
-func applyGrayscale(img image.Image) image.Image {
-	bounds := img.Bounds()
-	dst := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
-			gray := uint32(0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8))
-			gray8 := uint8(gray)
-			dst.Set(x, y, color.RGBA{gray8, gray8, gray8, uint8(a >> 8)})
-		}
+	if height < 1 {
+		height = 1
 	}
-	return dst
-}
-
-func applySepia(img image.Image) image.Image {
-	bounds := img.Bounds()
-	dst := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
-
-			tr := float64(r>>8)*0.393 + float64(g>>8)*0.769 + float64(b>>8)*0.189
-			tg := float64(r>>8)*0.349 + float64(g>>8)*0.686 + float64(b>>8)*0.168
-			tb := float64(r>>8)*0.272 + float64(g>>8)*0.534 + float64(b>>8)*0.131
-
-			tr = math.Min(255, tr)
-			tg = math.Min(255, tg)
-			tb = math.Min(255, tb)
-
-			dst.Set(x, y, color.RGBA{
-				uint8(tr), uint8(tg), uint8(tb), uint8(a >> 8),
-			})
-		}
+	if err := checkOutputSize(width, height); err != nil {
+		return nil, err
 	}
-	return dst
-}
 
-func applyInvert(img image.Image) image.Image {
-	bounds := img.Bounds()
-	dst := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
-			dst.Set(x, y, color.RGBA{
-				255 - uint8(r>>8),
-				255 - uint8(g>>8),
-				255 - uint8(b>>8),
-				uint8(a >> 8),
-			})
-		}
-	}
-	return dst
+	return resampleImage(img, width, height, kernel), nil
 }
 
-func applyCool(img image.Image) image.Image {
-	bounds := img.Bounds()
-	dst := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
-
-			tr := float64(r>>8) * 0.9
-			tg := float64(g>>8) * 0.9
-			tb := float64(b>>8) * 1.1
-
-			tr = math.Min(255, tr)
-			tg = math.Min(255, tg)
-			tb = math.Min(255, tb)
+// Вспомогательные функции
 
-			dst.Set(x, y, color.RGBA{
-				uint8(tr), uint8(tg), uint8(tb), uint8(a >> 8),
-			})
-		}
+// encodeImage - кодирование в формат format. Форматы без прозрачности
+// получают изображение, наложенное на цвет background.
+func encodeImage(img image.Image, format string, quality int, background color.NRGBA) ([]byte, error) {
+	out, err := lookupOutputFormat(format)
+	if err != nil {
+		return nil, err
 	}
-	return dst
-}
-
-func applyWarm(img image.Image) image.Image {
-	bounds := img.Bounds()
-	dst := image.NewRGBA(bounds)
-
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
-
-			tr := float64(r>>8) * 1.1
-			tg := float64(g>>8) * 1.0
-			tb := float64(b>>8) * 0.9
-
-			tr = math.Min(255, tr)
-			tg = math.Min(255, tg)
-			tb = math.Min(255, tb)
-
-			dst.Set(x, y, color.RGBA{
-				uint8(tr), uint8(tg), uint8(tb), uint8(a >> 8),
-			})
-		}
+	if !out.Alpha {
+		img = flattenAlpha(img, background)
 	}
-	return dst
-}
-
-// Вспомогательные функции
-func encodeImage(img image.Image, format string, quality int) ([]byte, error) {
 	var buf bytes.Buffer
-
-	switch strings.ToLower(format) {
-	case "jpg", "jpeg":
-		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
-		return buf.Bytes(), err
-	case "png":
-		err := png.Encode(&buf, img)
-		return buf.Bytes(), err
-	default:
-		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
-		return buf.Bytes(), err
-	}
+	err = out.Encode(&buf, img, quality)
+	return buf.Bytes(), err
 }
 
 func getContentType(format string) string {
-	switch strings.ToLower(format) {
-	case "jpg", "jpeg":
-		return "image/jpeg"
-	case "png":
-		return "image/png"
-	default:
+	out, err := lookupOutputFormat(format)
+	if err != nil {
 		return "application/octet-stream"
 	}
+	return out.ContentType
 }
 
 func sanitizeFilename(filename string) string {
@@ -523,7 +577,7 @@ func createHTMLFile() {
                 <div class="upload-icon">📁</div>
                 <h2>Перетащите изображение сюда</h2>
                 <p>или нажмите для выбора файла</p>
-                <p class="file-info">Поддерживаются: JPG, PNG, GIF, BMP, WebP (до 20MB)</p>
+                <p class="file-info">Поддерживаются: JPG, PNG, GIF, BMP, TIFF, WebP (до 20MB)</p>
             </div>
         </section>
         
@@ -555,6 +609,7 @@ func createHTMLFile() {
                     <div class="filters" id="filtersContainer">
                         <!-- Фильтры загрузятся через JS -->
                     </div>
+                    <div class="filter-params" id="filterParams"></div>
                 </div>
                 
                 <!-- Операции -->
@@ -610,6 +665,12 @@ func createHTMLFile() {
                             <select id="formatSelect">
                                 <option value="jpg">JPEG</option>
                                 <option value="png">PNG</option>
+                                <option value="gif">GIF</option>
+                                <option value="webp">WebP</option>
+                                <option value="bmp">BMP</option>
+                                <option value="tiff">TIFF</option>
+                                <option value="qoi">QOI</option>
+                                <option value="auto">Авто (по браузеру)</option>
                             </select>
                         </div>
                         <div class="setting">
@@ -839,6 +900,10 @@ h1 {
     border-color: #667eea;
 }
 
+.filter-params {
+    margin-top: 15px;
+}
+
 /* Операции */
 .operation {
     margin-bottom: 20px;
@@ -1076,16 +1141,18 @@ func createJSFile() {
 const CONFIG = {
     serverUrl: '',
     maxFileSize: 20 * 1024 * 1024, // 20MB
-    allowedTypes: ['image/jpeg', 'image/png', 'image/gif', 'image/bmp', 'image/webp']
+    allowedTypes: ['image/jpeg', 'image/png', 'image/gif', 'image/bmp', 'image/tiff', 'image/webp']
 };
 
 // Состояние приложения
 let state = {
     originalImage: null,
     processedImage: null,
+    processedFormat: null,
     originalFile: null,
     settings: {
         filter: 'none',
+        filterParams: {},
         rotate: 0,
         flip: 'none',
         width: 800,
@@ -1143,7 +1210,7 @@ function initUpload() {
         
         // Проверка типа файла
         if (!CONFIG.allowedTypes.includes(file.type)) {
-            alert('Пожалуйста, выберите изображение (JPG, PNG, GIF, BMP, WebP)');
+            alert('Пожалуйста, выберите изображение (JPG, PNG, GIF, BMP, TIFF, WebP)');
             return;
         }
         
@@ -1253,6 +1320,7 @@ function initFilters() {
                         // Активируем текущую
                         button.classList.add('active');
                         state.settings.filter = filter.id;
+                        renderFilterParams(filter);
                     });
                     
                     filtersContainer.appendChild(button);
@@ -1287,6 +1355,7 @@ function initFilters() {
                     });
                     button.classList.add('active');
                     state.settings.filter = filter.id;
+                    renderFilterParams(filter);
                 });
                 
                 filtersContainer.appendChild(button);
@@ -1294,6 +1363,83 @@ function initFilters() {
         });
 }
 
+// Ползунки параметров выбранного фильтра
+function renderFilterParams(filter) {
+    const container = document.getElementById('filterParams');
+    container.innerHTML = '';
+    state.settings.filterParams = {};
+    
+    (filter.params || []).forEach(param => {
+        state.settings.filterParams[param.id] = param.default;
+        
+        const operation = document.createElement('div');
+        operation.className = 'operation';
+        
+        // Параметр-выбор: список вариантов, в запрос уходит имя варианта
+        if (param.options) {
+            operation.classList.add('setting');
+            state.settings.filterParams[param.id] = param.options[param.default];
+            
+            const label = document.createElement('label');
+            label.textContent = param.name + ':';
+            
+            const select = document.createElement('select');
+            param.options.forEach(option => {
+                select.add(new Option(option, option));
+            });
+            select.value = param.options[param.default];
+            select.addEventListener('change', function() {
+                state.settings.filterParams[param.id] = this.value;
+            });
+            
+            operation.append(label, select);
+            container.appendChild(operation);
+            return;
+        }
+        
+        // Параметр-цвет: в запрос уходит строка #rrggbb
+        if (param.type === 'color') {
+            operation.classList.add('setting');
+            const hex = '#' + param.default.toString(16).padStart(6, '0');
+            state.settings.filterParams[param.id] = hex;
+            
+            const label = document.createElement('label');
+            label.textContent = param.name + ':';
+            
+            const input = document.createElement('input');
+            input.type = 'color';
+            input.value = hex;
+            input.addEventListener('input', function() {
+                state.settings.filterParams[param.id] = this.value;
+            });
+            
+            operation.append(label, input);
+            container.appendChild(operation);
+            return;
+        }
+        
+        const label = document.createElement('label');
+        const value = document.createElement('span');
+        value.textContent = param.default;
+        label.append(param.name + ': ', value);
+        
+        const slider = document.createElement('input');
+        slider.type = 'range';
+        slider.className = 'slider';
+        slider.min = param.min;
+        slider.max = param.max;
+        slider.step = param.step;
+        slider.value = param.default;
+        slider.addEventListener('input', function() {
+            value.textContent = this.value;
+            state.settings.filterParams[param.id] = parseFloat(this.value);
+        });
+        
+        operation.append(label, slider);
+        container.appendChild(operation);
+    });
+}
+
 // Инициализация элементов управления
 function initControls() {
     // Поворот
@@ -1361,6 +1507,9 @@ function initActions() {
             const formData = new FormData();
             formData.append('image', state.originalFile);
             formData.append('filter', state.settings.filter);
+            if (Object.keys(state.settings.filterParams).length > 0) {
+                formData.append('filter_params', JSON.stringify(state.settings.filterParams));
+            }
             formData.append('rotate', state.settings.rotate.toString());
             formData.append('flip', state.settings.flip);
             formData.append('width', state.settings.width.toString());
@@ -1380,6 +1529,7 @@ function initActions() {
             // Получаем результат
             const blob = await response.blob();
             state.processedImage = URL.createObjectURL(blob);
+            state.processedFormat = response.headers.get('X-Output-Format') || state.settings.format;
             
             // Показываем результат
             document.getElementById('resultImg').src = state.processedImage;
@@ -1408,7 +1558,7 @@ function initActions() {
         
         const a = document.createElement('a');
         a.href = state.processedImage;
-        a.download = 'processed_image.' + state.settings.format;
+        a.download = 'processed_image.' + (state.processedFormat || state.settings.format);
         document.body.appendChild(a);
         a.click();
         document.body.removeChild(a);
@@ -1420,9 +1570,11 @@ function initActions() {
         state = {
             originalImage: null,
             processedImage: null,
+            processedFormat: null,
             originalFile: null,
             settings: {
                 filter: 'none',
+                filterParams: {},
                 rotate: 0,
                 flip: 'none',
                 width: 800,
@@ -1451,6 +1603,7 @@ function initActions() {
         document.getElementById('qualitySlider').value = 85;
         document.getElementById('qualityValue').textContent = '85%';
         document.getElementById('formatSelect').value = 'jpg';
+        document.getElementById('filterParams').innerHTML = '';
         
         // Сброс активных кнопок
         document.querySelectorAll('.filter-btn').forEach(btn => {
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Параметры умной обрезки
+const (
+	smartAnalysisSide = 256 // анализ ведется на уменьшенной копии
+	smartSteps        = 24  // число положений окна по каждой оси
+	smartEntropyBins  = 32
+
+	smartEdgeWeight    = 1.0
+	smartEntropyWeight = 0.6
+	smartSkinWeight    = 1.8
+	smartCenterBias    = 0.05 // слабое предпочтение центра при равных оценках
+)
+
+// smartCropHeader - заголовок ответа с выбранной областью "x,y,width,height"
+const smartCropHeader = "X-Smart-Crop"
+
+// placeRect - область w×h внутри img по gravity; для smart - по содержимому.
+// Выбранная умной обрезкой область запоминается для шага и попадает в заголовок.
+func (rc *runContext) placeRect(img image.Image, w, h int, g gravity) image.Rectangle {
+	bounds := img.Bounds()
+	if !g.Smart {
+		return anchorRect(bounds, w, h, g)
+	}
+
+	if rect, ok := rc.smart[rc.step]; ok && rect.Size() == image.Pt(w, h) && rect.In(bounds) {
+		return rect
+	}
+
+	rect := smartCropRect(img, w, h)
+	rc.smart[rc.step] = rect
+	rc.Header.Add(smartCropHeader, fmt.Sprintf("%d,%d,%d,%d",
+		rect.Min.X-bounds.Min.X, rect.Min.Y-bounds.Min.Y, rect.Dx(), rect.Dy()))
+	return rect
+}
+
+// smartCropRect - выбор окна w×h с наибольшей "интересностью":
+// плотность границ, энтропия яркости и доля оттенков кожи
+func smartCropRect(img image.Image, w, h int) image.Rectangle {
+	bounds := img.Bounds()
+	iw, ih := bounds.Dx(), bounds.Dy()
+	if w >= iw && h >= ih {
+		return bounds
+	}
+
+	// Уменьшенная копия для анализа
+	scale := math.Min(1, float64(smartAnalysisSide)/float64(max(iw, ih)))
+	aw, ah := scaledSize(iw, ih, scale)
+	small := img
+	if aw != iw || ah != ih {
+		small = resampleImage(img, aw, ah, resampleKernels["bilinear"])
+	}
+	sx, sy := float64(aw)/float64(iw), float64(ah)/float64(ih)
+
+	f := newSmartFeatures(small)
+
+	// Размер окна в координатах анализа
+	ww := clampInt(int(math.Round(float64(w)*sx)), 1, aw)
+	wh := clampInt(int(math.Round(float64(h)*sy)), 1, ah)
+
+	stepX := max(1, (aw-ww)/smartSteps)
+	stepY := max(1, (ah-wh)/smartSteps)
+
+	bestScore := math.Inf(-1)
+	bestX, bestY := (aw-ww)/2, (ah-wh)/2
+	for y := 0; y <= ah-wh; y += stepY {
+		for x := 0; x <= aw-ww; x += stepX {
+			score := f.score(x, y, ww, wh)
+
+			// Расстояние центра окна от центра кадра, 0..1
+			dx := (float64(x) + float64(ww)/2 - float64(aw)/2) / float64(aw)
+			dy := (float64(y) + float64(wh)/2 - float64(ah)/2) / float64(ah)
+			score -= smartCenterBias * math.Hypot(dx, dy)
+
+			if score > bestScore {
+				bestScore, bestX, bestY = score, x, y
+			}
+		}
+	}
+
+	// Обратно в координаты исходного изображения
+	x := clampInt(int(math.Round(float64(bestX)/sx)), 0, iw-w)
+	y := clampInt(int(math.Round(float64(bestY)/sy)), 0, ih-h)
+	return image.Rect(x, y, x+w, y+h).Add(bounds.Min)
+}
+
+// smartFeatures - интегральные изображения признаков для быстрой оценки окон
+type smartFeatures struct {
+	w, h  int
+	edge  []float64 // суммы нормированной силы границ
+	skin  []float64 // суммы вероятности кожи
+	bins  [][]int32 // по одной таблице на корзину гистограммы яркости
+	width int       // ширина таблиц (w+1)
+}
+
+func newSmartFeatures(img image.Image) *smartFeatures {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	luma := make([]float64, w*h)
+	skin := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			rf, gf, bf := float64(r)/65535, float64(g)/65535, float64(b)/65535
+			luma[y*w+x] = 0.299*rf + 0.587*gf + 0.114*bf
+			skin[y*w+x] = skinLikelihood(rf, gf, bf)
+		}
+	}
+
+	// Сила границ - модуль лапласиана яркости
+	edge := make([]float64, w*h)
+	maxEdge := 0.0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := luma[y*w+x]
+			sum := 4*c -
+				luma[y*w+clampInt(x-1, 0, w-1)] - luma[y*w+clampInt(x+1, 0, w-1)] -
+				luma[clampInt(y-1, 0, h-1)*w+x] - luma[clampInt(y+1, 0, h-1)*w+x]
+			e := math.Abs(sum)
+			edge[y*w+x] = e
+			maxEdge = math.Max(maxEdge, e)
+		}
+	}
+	if maxEdge > 0 {
+		for i := range edge {
+			edge[i] /= maxEdge
+		}
+	}
+
+	f := &smartFeatures{w: w, h: h, width: w + 1}
+	f.edge = integral(edge, w, h)
+	f.skin = integral(skin, w, h)
+
+	f.bins = make([][]int32, smartEntropyBins)
+	for i := range f.bins {
+		f.bins[i] = make([]int32, (w+1)*(h+1))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			bin := clampInt(int(luma[y*w+x]*smartEntropyBins), 0, smartEntropyBins-1)
+			for i, t := range f.bins {
+				v := t[y*(w+1)+x+1] + t[(y+1)*(w+1)+x] - t[y*(w+1)+x]
+				if i == bin {
+					v++
+				}
+				t[(y+1)*(w+1)+x+1] = v
+			}
+		}
+	}
+	return f
+}
+
+// score - оценка окна (x, y, w, h)
+func (f *smartFeatures) score(x, y, w, h int) float64 {
+	area := float64(w * h)
+	edge := f.rectSum(f.edge, x, y, w, h) / area
+	skin := f.rectSum(f.skin, x, y, w, h) / area
+
+	entropy := 0.0
+	for _, t := range f.bins {
+		n := float64(t[(y+h)*f.width+x+w] - t[y*f.width+x+w] - t[(y+h)*f.width+x] + t[y*f.width+x])
+		if n > 0 {
+			p := n / area
+			entropy -= p * math.Log2(p)
+		}
+	}
+	entropy /= math.Log2(smartEntropyBins)
+
+	return smartEdgeWeight*edge + smartEntropyWeight*entropy + smartSkinWeight*skin
+}
+
+func (f *smartFeatures) rectSum(t []float64, x, y, w, h int) float64 {
+	return t[(y+h)*f.width+x+w] - t[y*f.width+x+w] - t[(y+h)*f.width+x] + t[y*f.width+x]
+}
+
+// integral - таблица сумм (summed-area table) размером (w+1)×(h+1)
+func integral(v []float64, w, h int) []float64 {
+	t := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		row := 0.0
+		for x := 0; x < w; x++ {
+			row += v[y*w+x]
+			t[(y+1)*(w+1)+x+1] = t[y*(w+1)+x+1] + row
+		}
+	}
+	return t
+}
+
+// skinLikelihood - близость цвета к типичному тону кожи (0..1)
+func skinLikelihood(r, g, b float64) float64 {
+	mag := math.Sqrt(r*r + g*g + b*b)
+	if mag < 0.1 {
+		return 0
+	}
+	// Направление цвета сравнивается с эталоном, яркость не учитывается
+	dr, dg, db := r/mag-0.78, g/mag-0.57, b/mag-0.44
+	d := math.Sqrt(dr*dr + dg*dg + db*db)
+	likelihood := 1 - d/0.25
+	if likelihood <= 0 {
+		return 0
+	}
+	// Слишком темные и пересвеченные пиксели на кожу не похожи
+	luma := 0.299*r + 0.587*g + 0.114*b
+	if luma < 0.2 || luma > 0.95 {
+		return 0
+	}
+	return likelihood
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
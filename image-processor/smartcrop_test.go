@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// detailImage - ровный серый фон и шумный квадрат side×side в точке at
+func detailImage(w, h int, at image.Point, side int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{128, 128, 128, 255}
+			if image.Pt(x, y).In(image.Rectangle{at, at.Add(image.Pt(side, side))}) {
+				c = color.NRGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestSmartCropRect(t *testing.T) {
+	tests := []struct {
+		name   string
+		img    *image.NRGBA
+		w, h   int
+		detail image.Rectangle
+	}{
+		{"right", detailImage(400, 100, image.Pt(320, 20), 60), 100, 100, image.Rect(320, 20, 380, 80)},
+		{"left", detailImage(400, 100, image.Pt(10, 20), 60), 100, 100, image.Rect(10, 20, 70, 80)},
+		{"bottom", detailImage(100, 600, image.Pt(20, 500), 60), 100, 100, image.Rect(20, 500, 80, 560)},
+		{"large image", detailImage(1200, 300, image.Pt(900, 100), 120), 300, 300, image.Rect(900, 100, 1020, 220)},
+	}
+	for _, tt := range tests {
+		rect := smartCropRect(tt.img, tt.w, tt.h)
+		if rect.Size() != image.Pt(tt.w, tt.h) || !rect.In(tt.img.Bounds()) {
+			t.Errorf("%s: окно %v, ожидается %d×%d внутри изображения", tt.name, rect, tt.w, tt.h)
+			continue
+		}
+		if !tt.detail.In(rect) {
+			t.Errorf("%s: окно %v не содержит детали %v", tt.name, rect, tt.detail)
+		}
+	}
+
+	// окно не меньше изображения - все изображение
+	img := testImage(30, 20)
+	if rect := smartCropRect(img, 30, 20); rect != img.Bounds() {
+		t.Errorf("окно во все изображение: %v", rect)
+	}
+}
+
+// Область выбирается один раз на шаг и попадает в заголовок ответа
+func TestPlaceRectSmart(t *testing.T) {
+	img := detailImage(400, 100, image.Pt(320, 20), 60)
+	rc := newRunContext()
+	smart := gravity{Smart: true}
+
+	first := rc.placeRect(img, 100, 100, smart)
+	want := fmt.Sprintf("%d,%d,100,100", first.Min.X, first.Min.Y)
+	if got := rc.Header.Get(smartCropHeader); got != want || first.Min.X < 280 {
+		t.Errorf("%s: %q, окно %v", smartCropHeader, got, first)
+	}
+	if again := rc.placeRect(img, 100, 100, smart); again != first || len(rc.Header.Values(smartCropHeader)) != 1 {
+		t.Errorf("повторный вызов: %v, заголовки %q", again, rc.Header.Values(smartCropHeader))
+	}
+
+	if rect := rc.placeRect(img, 100, 100, gravities["center"]); rect != image.Rect(150, 0, 250, 100) {
+		t.Errorf("без smart: %v, ожидается центр", rect)
+	}
+}
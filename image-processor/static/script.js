@@ -2,16 +2,18 @@
 const CONFIG = {
     serverUrl: '',
     maxFileSize: 20 * 1024 * 1024, // 20MB
-    allowedTypes: ['image/jpeg', 'image/png', 'image/gif', 'image/bmp', 'image/webp']
+    allowedTypes: ['image/jpeg', 'image/png', 'image/gif', 'image/bmp', 'image/tiff', 'image/webp']
 };
 
 // Состояние приложения
 let state = {
     originalImage: null,
     processedImage: null,
+    processedFormat: null,
     originalFile: null,
     settings: {
         filter: 'none',
+        filterParams: {},
         rotate: 0,
         flip: 'none',
         width: 800,
@@ -69,7 +71,7 @@ function initUpload() {
         
         // Проверка типа файла
         if (!CONFIG.allowedTypes.includes(file.type)) {
-            alert('Пожалуйста, выберите изображение (JPG, PNG, GIF, BMP, WebP)');
+            alert('Пожалуйста, выберите изображение (JPG, PNG, GIF, BMP, TIFF, WebP)');
             return;
         }
         
@@ -179,6 +181,7 @@ function initFilters() {
                         // Активируем текущую
                         button.classList.add('active');
                         state.settings.filter = filter.id;
+                        renderFilterParams(filter);
                     });
                     
                     filtersContainer.appendChild(button);
@@ -213,6 +216,7 @@ function initFilters() {
                     });
                     button.classList.add('active');
                     state.settings.filter = filter.id;
+                    renderFilterParams(filter);
                 });
                 
                 filtersContainer.appendChild(button);
@@ -220,6 +224,83 @@ function initFilters() {
         });
 }
 
+// Ползунки параметров выбранного фильтра
+function renderFilterParams(filter) {
+    const container = document.getElementById('filterParams');
+    container.innerHTML = '';
+    state.settings.filterParams = {};
+    
+    (filter.params || []).forEach(param => {
+        state.settings.filterParams[param.id] = param.default;
+        
+        const operation = document.createElement('div');
+        operation.className = 'operation';
+        
+        // Параметр-выбор: список вариантов, в запрос уходит имя варианта
+        if (param.options) {
+            operation.classList.add('setting');
+            state.settings.filterParams[param.id] = param.options[param.default];
+            
+            const label = document.createElement('label');
+            label.textContent = param.name + ':';
+            
+            const select = document.createElement('select');
+            param.options.forEach(option => {
+                select.add(new Option(option, option));
+            });
+            select.value = param.options[param.default];
+            select.addEventListener('change', function() {
+                state.settings.filterParams[param.id] = this.value;
+            });
+            
+            operation.append(label, select);
+            container.appendChild(operation);
+            return;
+        }
+        
+        // Параметр-цвет: в запрос уходит строка #rrggbb
+        if (param.type === 'color') {
+            operation.classList.add('setting');
+            const hex = '#' + param.default.toString(16).padStart(6, '0');
+            state.settings.filterParams[param.id] = hex;
+            
+            const label = document.createElement('label');
+            label.textContent = param.name + ':';
+            
+            const input = document.createElement('input');
+            input.type = 'color';
+            input.value = hex;
+            input.addEventListener('input', function() {
+                state.settings.filterParams[param.id] = this.value;
+            });
+            
+            operation.append(label, input);
+            container.appendChild(operation);
+            return;
+        }
+        
+        const label = document.createElement('label');
+        const value = document.createElement('span');
+        value.textContent = param.default;
+        label.append(param.name + ': ', value);
+        
+        const slider = document.createElement('input');
+        slider.type = 'range';
+        slider.className = 'slider';
+        slider.min = param.min;
+        slider.max = param.max;
+        slider.step = param.step;
+        slider.value = param.default;
+        slider.addEventListener('input', function() {
+            value.textContent = this.value;
+            state.settings.filterParams[param.id] = parseFloat(this.value);
+        });
+        
+        operation.append(label, slider);
+        container.appendChild(operation);
+    });
+}
+
 // Инициализация элементов управления
 function initControls() {
     // Поворот
@@ -287,6 +368,9 @@ function initActions() {
             const formData = new FormData();
             formData.append('image', state.originalFile);
             formData.append('filter', state.settings.filter);
+            if (Object.keys(state.settings.filterParams).length > 0) {
+                formData.append('filter_params', JSON.stringify(state.settings.filterParams));
+            }
             formData.append('rotate', state.settings.rotate.toString());
             formData.append('flip', state.settings.flip);
             formData.append('width', state.settings.width.toString());
@@ -306,6 +390,7 @@ function initActions() {
             // Получаем результат
             const blob = await response.blob();
             state.processedImage = URL.createObjectURL(blob);
+            state.processedFormat = response.headers.get('X-Output-Format') || state.settings.format;
             
             // Показываем результат
             document.getElementById('resultImg').src = state.processedImage;
@@ -334,7 +419,7 @@ function initActions() {
         
         const a = document.createElement('a');
         a.href = state.processedImage;
-        a.download = 'processed_image.' + state.settings.format;
+        a.download = 'processed_image.' + (state.processedFormat || state.settings.format);
         document.body.appendChild(a);
         a.click();
         document.body.removeChild(a);
@@ -346,9 +431,11 @@ function initActions() {
         state = {
             originalImage: null,
             processedImage: null,
+            processedFormat: null,
             originalFile: null,
             settings: {
                 filter: 'none',
+                filterParams: {},
                 rotate: 0,
                 flip: 'none',
                 width: 800,
@@ -377,6 +464,7 @@ function initActions() {
         document.getElementById('qualitySlider').value = 85;
         document.getElementById('qualityValue').textContent = '85%';
         document.getElementById('formatSelect').value = 'jpg';
+        document.getElementById('filterParams').innerHTML = '';
         
         // Сброс активных кнопок
         document.querySelectorAll('.filter-btn').forEach(btn => {
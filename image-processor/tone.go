@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Уровни и кривые - операции конвейера "levels" и "curves". Значения
+// задаются в уровнях 0..255 (как в редакторах), но применяются таблицами
+// на 0..1, так что 16-битные изображения не теряют точность. Сначала
+// применяется настройка отдельного канала (r, g, b), затем общая (rgb).
+
+// levelsChannel - уровни одного канала: входные точки черного и белого,
+// гамма средних тонов и выходной диапазон
+type levelsChannel struct {
+	Black    float64 `json:"black"`
+	White    float64 `json:"white"`
+	Gamma    float64 `json:"gamma"`
+	OutBlack float64 `json:"out_black"`
+	OutWhite float64 `json:"out_white"`
+}
+
+// defaultLevels - уровни без изменений
+var defaultLevels = levelsChannel{Black: 0, White: 255, Gamma: 1, OutBlack: 0, OutWhite: 255}
+
+// Описание параметров уровней и кривых для /api/filters
+var (
+	levelsParams = []filterParam{
+		numberParam("black", "Точка черного", 0, 254, 1, defaultLevels.Black),
+		numberParam("white", "Точка белого", 1, 255, 1, defaultLevels.White),
+		numberParam("gamma", "Гамма", 0.1, 10, 0.01, defaultLevels.Gamma),
+		numberParam("out_black", "Выход: черный", 0, 255, 1, defaultLevels.OutBlack),
+		numberParam("out_white", "Выход: белый", 0, 255, 1, defaultLevels.OutWhite),
+	}
+	levelsChannels = []string{"rgb", "r", "g", "b"}
+	curvesChannels = []string{"rgb", "r", "g", "b", "luma"}
+)
+
+// maxCurvePoints - наибольшее число точек кривой
+const maxCurvePoints = 16
+
+// toneOperations - описание операций уровней и кривых для /api/filters
+func toneOperations() []map[string]interface{} {
+	return []map[string]interface{}{
+		{
+			"op":       "levels",
+			"name":     "Уровни",
+			"channels": levelsChannels,
+			"params":   levelsParams,
+		},
+		{
+			"op":       "curves",
+			"name":     "Кривые",
+			"channels": curvesChannels,
+			// каждая кривая - массив точек [вход, выход]
+			"points": map[string]interface{}{"min": 0, "max": 255, "min_count": 2, "max_count": maxCurvePoints},
+		},
+	}
+}
+
+// check - проверка уровней канала name
+func (l levelsChannel) check(name string) error {
+	for i, v := range []float64{l.Black, l.White, l.Gamma, l.OutBlack, l.OutWhite} {
+		p := levelsParams[i]
+		if math.IsNaN(v) || v < p.Min || v > p.Max {
+			return fmt.Errorf("%s.%s: ожидается значение от %g до %g, получено %g", name, p.ID, p.Min, p.Max, v)
+		}
+	}
+	if l.Black >= l.White {
+		return fmt.Errorf("%s: точка черного (%g) должна быть меньше точки белого (%g)", name, l.Black, l.White)
+	}
+	return nil
+}
+
+// curve - функция уровней на 0..1
+func (l levelsChannel) curve(v float64) float64 {
+	v = math.Max(0, math.Min(1, (v*255-l.Black)/(l.White-l.Black)))
+	v = math.Pow(v, 1/l.Gamma)
+	return (l.OutBlack + v*(l.OutWhite-l.OutBlack)) / 255
+}
+
+// levelsLUTs - таблицы каналов: свои уровни канала, затем общие
+func levelsLUTs(master levelsChannel, channels [3]levelsChannel) (channelLUTs, error) {
+	if err := master.check("rgb"); err != nil {
+		return channelLUTs{}, err
+	}
+	var luts channelLUTs
+	for c, l := range channels {
+		if err := l.check(levelsChannels[c+1]); err != nil {
+			return channelLUTs{}, err
+		}
+		l := l
+		luts[c] = curveLUT(func(v float64) float64 { return master.curve(l.curve(v)) })
+	}
+	return luts, nil
+}
+
+// curvesPixelFunc - преобразование по кривым: кривые каналов, общая кривая,
+// затем кривая яркости (каналы сдвигаются на изменение яркости, цвет
+// сохраняется). Пустая кривая - без изменений.
+func curvesPixelFunc(master [][]float64, channels [3][][]float64, luma [][]float64) (pixelFunc, error) {
+	rgb, err := parseCurvePoints("rgb", master)
+	if err != nil {
+		return nil, err
+	}
+	var luts channelLUTs
+	for c, points := range channels {
+		s, err := parseCurvePoints(curvesChannels[c+1], points)
+		if err != nil {
+			return nil, err
+		}
+		luts[c] = curveLUT(func(v float64) float64 { return rgb.at(s.at(v)) })
+	}
+	l, err := parseCurvePoints("luma", luma)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		return luts.apply, nil
+	}
+
+	lumaLUT := curveLUT(l.at)
+	return func(r, g, b float32) (float32, float32, float32) {
+		r, g, b = luts.apply(r, g, b)
+		y := lumaR*r + lumaG*g + lumaB*b
+		d := lumaLUT.at(y) - y
+		return r + d, g + d, b + d
+	}, nil
+}
+
+// parseCurvePoints - кривая по точкам [вход, выход] в уровнях 0..255.
+// Для пустого списка - nil (тождественная кривая).
+func parseCurvePoints(name string, points [][]float64) (*monotoneSpline, error) {
+	if len(points) == 0 {
+		return nil, nil
+	}
+	if len(points) < 2 || len(points) > maxCurvePoints {
+		return nil, fmt.Errorf("%s: ожидается от 2 до %d точек [вход, выход]", name, maxCurvePoints)
+	}
+
+	xs := make([]float64, len(points))
+	ys := make([]float64, len(points))
+	order := make([]int, len(points))
+	for i, pt := range points {
+		if len(pt) != 2 {
+			return nil, fmt.Errorf("%s: точка %d - ожидается пара [вход, выход]", name, i)
+		}
+		for _, v := range pt {
+			if math.IsNaN(v) || v < 0 || v > 255 {
+				return nil, fmt.Errorf("%s: точка %d - значения от 0 до 255", name, i)
+			}
+		}
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return points[order[i]][0] < points[order[j]][0] })
+	for i, k := range order {
+		xs[i], ys[i] = points[k][0]/255, points[k][1]/255
+		if i > 0 && xs[i] == xs[i-1] {
+			return nil, fmt.Errorf("%s: две точки с входом %g", name, points[k][0])
+		}
+	}
+	return newMonotoneSpline(xs, ys), nil
+}
+
+// monotoneSpline - кубический сплайн Фрича-Карлсона: проходит через точки
+// и не дает выбросов между ними (монотонные участки остаются монотонными).
+// Левее первой и правее последней точки значение постоянно.
+type monotoneSpline struct {
+	xs, ys, ms []float64
+}
+
+func newMonotoneSpline(xs, ys []float64) *monotoneSpline {
+	n := len(xs)
+	d := make([]float64, n-1) // наклоны отрезков
+	for i := range d {
+		d[i] = (ys[i+1] - ys[i]) / (xs[i+1] - xs[i])
+	}
+
+	ms := make([]float64, n) // касательные в точках
+	ms[0], ms[n-1] = d[0], d[n-2]
+	for i := 1; i < n-1; i++ {
+		if d[i-1]*d[i] > 0 {
+			ms[i] = (d[i-1] + d[i]) / 2
+		}
+	}
+	for i, di := range d {
+		if di == 0 {
+			ms[i], ms[i+1] = 0, 0
+			continue
+		}
+		a, b := ms[i]/di, ms[i+1]/di
+		if s := a*a + b*b; s > 9 {
+			t := 3 / math.Sqrt(s)
+			ms[i], ms[i+1] = t*a*di, t*b*di
+		}
+	}
+	return &monotoneSpline{xs, ys, ms}
+}
+
+// at - значение кривой; nil - тождественная кривая
+func (s *monotoneSpline) at(x float64) float64 {
+	if s == nil {
+		return x
+	}
+	n := len(s.xs)
+	if x <= s.xs[0] {
+		return s.ys[0]
+	}
+	if x >= s.xs[n-1] {
+		return s.ys[n-1]
+	}
+	i := sort.SearchFloat64s(s.xs, x) - 1
+
+	h := s.xs[i+1] - s.xs[i]
+	t := (x - s.xs[i]) / h
+	t2, t3 := t*t, t*t*t
+	return (2*t3-3*t2+1)*s.ys[i] + (t3-2*t2+t)*h*s.ms[i] +
+		(-2*t3+3*t2)*s.ys[i+1] + (t3-t2)*h*s.ms[i+1]
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLevelsCurve(t *testing.T) {
+	tests := []struct {
+		name   string
+		levels levelsChannel
+		in     float64 // уровень 0..255
+		want   float64
+	}{
+		{"default", defaultLevels, 100, 100},
+		{"black point", levelsChannel{50, 255, 1, 0, 255}, 50, 0},
+		{"below black", levelsChannel{50, 255, 1, 0, 255}, 20, 0},
+		{"white point", levelsChannel{0, 200, 1, 0, 255}, 100, 127.5},
+		{"above white", levelsChannel{0, 200, 1, 0, 255}, 230, 255},
+		{"gamma", levelsChannel{0, 255, 2, 0, 255}, 63.75, 127.5},
+		{"output", levelsChannel{0, 255, 1, 55, 155}, 255, 155},
+		{"inverted output", levelsChannel{0, 255, 1, 255, 0}, 0, 255},
+	}
+	for _, tt := range tests {
+		if got := tt.levels.curve(tt.in/255) * 255; math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: %g → %g, ожидается %g", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLevelsCheck(t *testing.T) {
+	tests := []struct {
+		levels  levelsChannel
+		wantErr string
+	}{
+		{defaultLevels, ""},
+		{levelsChannel{200, 100, 1, 0, 255}, "меньше точки белого"},
+		{levelsChannel{0, 255, 0, 0, 255}, "r.gamma"},
+		{levelsChannel{0, 255, 1, -1, 255}, "r.out_black"},
+		{levelsChannel{math.NaN(), 255, 1, 0, 255}, "r.black"},
+	}
+	for _, tt := range tests {
+		err := tt.levels.check("r")
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%+v: ошибка %v, ожидается %q", tt.levels, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCurvePoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		points  [][]float64
+		wantErr string
+	}{
+		{"empty", nil, ""},
+		{"unsorted", [][]float64{{255, 255}, {0, 0}, {128, 160}}, ""},
+		{"one point", [][]float64{{0, 0}}, "от 2 до"},
+		{"too many", make([][]float64, maxCurvePoints+1), "от 2 до"},
+		{"not pair", [][]float64{{0, 0}, {1}}, "пара"},
+		{"out of range", [][]float64{{0, 0}, {256, 255}}, "от 0 до 255"},
+		{"duplicate", [][]float64{{0, 0}, {100, 50}, {100, 60}}, "две точки"},
+	}
+	for _, tt := range tests {
+		_, err := parseCurvePoints("rgb", tt.points)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: ошибка %v, ожидается %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// Сплайн проходит через точки и не выходит за соседние значения
+func TestMonotoneSpline(t *testing.T) {
+	points := [][]float64{{0, 0}, {64, 100}, {128, 110}, {192, 200}, {255, 255}}
+	s, err := parseCurvePoints("rgb", points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range points {
+		if got := s.at(p[0]/255) * 255; math.Abs(got-p[1]) > 1e-9 {
+			t.Errorf("в точке %g: %g, ожидается %g", p[0], got, p[1])
+		}
+	}
+	prev := s.at(0)
+	for i := 1; i <= 1000; i++ {
+		v := s.at(float64(i) / 1000)
+		if v < prev-1e-12 {
+			t.Fatalf("кривая убывает в %g", float64(i)/1000)
+		}
+		prev = v
+	}
+
+	// левее первой и правее последней точки - постоянные значения
+	s, _ = parseCurvePoints("rgb", [][]float64{{50, 20}, {200, 230}})
+	if s.at(0) != 20.0/255 || s.at(1) != 230.0/255 {
+		t.Errorf("за краями: %g, %g", s.at(0)*255, s.at(1)*255)
+	}
+	if (*monotoneSpline)(nil).at(0.3) != 0.3 {
+		t.Error("nil - не тождественная кривая")
+	}
+}
+
+func TestToneSteps(t *testing.T) {
+	in := color.NRGBA{100, 150, 200, 255}
+	tests := []struct {
+		op   string
+		want color.NRGBA
+	}{
+		{`{"op":"levels"}`, in},
+		{`{"op":"levels","rgb":{"out_black":255,"out_white":0}}`, color.NRGBA{155, 105, 55, 255}},
+		{`{"op":"levels","r":{"white":200},"rgb":{"black":50}}`, color.NRGBA{96, 124, 187, 255}},
+		{`{"op":"curves","b":[[0,0],[255,0]]}`, color.NRGBA{100, 150, 0, 255}},
+		{`{"op":"curves","rgb":[[0,255],[255,0]]}`, color.NRGBA{155, 105, 55, 255}},
+		// кривая яркости сдвигает каналы одинаково
+		{`{"op":"curves","luma":[[0,20],[235,255]]}`, color.NRGBA{120, 170, 220, 255}},
+	}
+	for _, tt := range tests {
+		p, err := parsePipeline("["+tt.op+"]", defaultOptions(t))
+		if err != nil {
+			t.Errorf("%s: %v", tt.op, err)
+			continue
+		}
+		src := flatImage(2, 2, in)
+		out, err := p.run(src, newRunContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := color.NRGBAModel.Convert(out.At(1, 1)).(color.NRGBA); !closeColor(got, tt.want) {
+			t.Errorf("%s: %v, ожидается %v", tt.op, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// Кодировщик WebP без потерь (VP8L). Используются преобразования
+// subtract green и predictor, LZ77-ссылки на соседа слева и сверху
+// и канонические коды Хаффмана. Цветовой кэш и meta-коды не используются.
+
+// Параметры VP8L
+const (
+	vp8lMaxSide       = 1 << 14
+	vp8lPredictorBits = 4    // тайлы предсказателя 16×16
+	vp8lMinRun        = 3    // более короткие повторы выгоднее писать литералами
+	vp8lMaxRun        = 4096 // наибольшая длина LZ77-ссылки
+	vp8lMaxCodeLength = 15
+	vp8lGreenSymbols  = 256 + 24 // литералы + коды длины
+	vp8lDistSymbols   = 40
+)
+
+// vp8lPredictorModes - проверяемые режимы предсказания (L, T, TL, среднее L/T,
+// select и два clamp-режима)
+var vp8lPredictorModes = []uint32{1, 2, 4, 7, 11, 12, 13}
+
+// vp8lCodeLengthOrder - порядок передачи длин кодов длин
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lBitWriter - запись битов младшими вперед
+type vp8lBitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint
+}
+
+func (bw *vp8lBitWriter) write(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.n
+	bw.n += n
+	for bw.n >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.n -= 8
+	}
+}
+
+func (bw *vp8lBitWriter) bytes() []byte {
+	if bw.n > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.n = 0, 0
+	}
+	return bw.buf
+}
+
+// encodeWebP - запись изображения в WebP без потерь
+func encodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSide || height > vp8lMaxSide {
+		return errors.New("WebP: размер изображения должен быть от 1 до 16384 пикселей")
+	}
+
+	src := toNRGBA(img)
+	argb := make([]uint32, width*height)
+	alpha := false
+	for y := 0; y < height; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < width; x++ {
+			p := row[x*4 : x*4+4]
+			argb[y*width+x] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+			if p[3] != 0xff {
+				alpha = true
+			}
+		}
+	}
+
+	bw := &vp8lBitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // версия
+
+	// subtract green (тип 2)
+	for i, p := range argb {
+		g := p >> 8 & 0xff
+		r := (p>>16 - g) & 0xff
+		bl := (p - g) & 0xff
+		argb[i] = p&0xff00ff00 | r<<16 | bl
+	}
+	bw.write(1, 1)
+	bw.write(2, 2)
+
+	// predictor (тип 0)
+	modes, tw, th := vp8lChooseModes(argb, width, height)
+	bw.write(1, 1)
+	bw.write(0, 2)
+	bw.write(vp8lPredictorBits-2, 3)
+	vp8lWriteImage(bw, modes, tw, th, false)
+	argb = vp8lResiduals(argb, width, height, modes, tw)
+
+	bw.write(0, 1) // преобразований больше нет
+	vp8lWriteImage(bw, argb, width, height, true)
+
+	data := bw.bytes()
+	size := len(data)
+	var hdr [20]byte
+	copy(hdr[0:], "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(4+8+size+size&1))
+	copy(hdr[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(size))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if size&1 == 1 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// vp8lPredict - предсказание для режима mode по соседям
+func vp8lPredict(mode uint32, l, t, tl, tr uint32) uint32 {
+	switch mode {
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 7:
+		return vp8lAverage(l, t)
+	case 11:
+		var pl, pt int
+		for s := 0; s < 32; s += 8 {
+			c, a, u := int(tl>>s&0xff), int(l>>s&0xff), int(t>>s&0xff)
+			pl += absInt(c - u)
+			pt += absInt(c - a)
+		}
+		if pl < pt {
+			return l
+		}
+		return t
+	case 12:
+		var out uint32
+		for s := 0; s < 32; s += 8 {
+			v := int(l>>s&0xff) + int(t>>s&0xff) - int(tl>>s&0xff)
+			out |= uint32(clampInt(v, 0, 255)) << s
+		}
+		return out
+	case 13:
+		avg := vp8lAverage(l, t)
+		var out uint32
+		for s := 0; s < 32; s += 8 {
+			a, c := int(avg>>s&0xff), int(tl>>s&0xff)
+			out |= uint32(clampInt(a+(a-c)/2, 0, 255)) << s
+		}
+		return out
+	}
+	return 0xff000000
+}
+
+// vp8lAverage - поканальное среднее с округлением вниз
+func vp8lAverage(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+// vp8lSub - поканальная разность по модулю 256
+func vp8lSub(a, b uint32) uint32 {
+	ag := (a | 0x00ff00ff) - (b & 0xff00ff00)
+	rb := (a | 0xff00ff00) - (b & 0x00ff00ff)
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+// vp8lPrediction - предсказание пикселя i с учетом особых правил для края
+func vp8lPrediction(pix []uint32, width, x, y int, mode uint32) uint32 {
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return pix[i-1]
+	case x == 0:
+		return pix[i-width]
+	}
+	// для последнего столбца "сверху справа" - первый пиксель текущей строки
+	return vp8lPredict(mode, pix[i-1], pix[i-width], pix[i-width-1], pix[i-width+1])
+}
+
+// vp8lChooseModes - для каждого тайла режим с наименьшей суммой остатков
+func vp8lChooseModes(pix []uint32, width, height int) ([]uint32, int, int) {
+	size := 1 << vp8lPredictorBits
+	tw, th := (width+size-1)/size, (height+size-1)/size
+	modes := make([]uint32, tw*th)
+
+	for ty := 0; ty < th; ty++ {
+		for tx := 0; tx < tw; tx++ {
+			best, bestCost := vp8lPredictorModes[0], -1
+			for _, mode := range vp8lPredictorModes {
+				cost := 0
+				for y := ty * size; y < min((ty+1)*size, height); y++ {
+					for x := tx * size; x < min((tx+1)*size, width); x++ {
+						r := vp8lSub(pix[y*width+x], vp8lPrediction(pix, width, x, y, mode))
+						for s := 0; s < 32; s += 8 {
+							v := int(r >> s & 0xff)
+							cost += min(v, 256-v)
+						}
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			// режим хранится в зеленом канале
+			modes[ty*tw+tx] = best << 8
+		}
+	}
+	return modes, tw, th
+}
+
+// vp8lResiduals - остатки предсказания
+func vp8lResiduals(pix []uint32, width, height int, modes []uint32, tw int) []uint32 {
+	res := make([]uint32, len(pix))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			mode := modes[(y>>vp8lPredictorBits)*tw+x>>vp8lPredictorBits] >> 8
+			res[y*width+x] = vp8lSub(pix[y*width+x], vp8lPrediction(pix, width, x, y, mode))
+		}
+	}
+	return res
+}
+
+// vp8lToken - литерал или LZ77-ссылка (length > 0)
+type vp8lToken struct {
+	argb   uint32
+	length int
+	dist   int // код расстояния: 1 - сверху, 2 - слева
+}
+
+// vp8lTokenize - разбиение на литералы и повторы соседей слева/сверху
+func vp8lTokenize(pix []uint32, width int) []vp8lToken {
+	var tokens []vp8lToken
+	for i := 0; i < len(pix); {
+		run, dist := 0, 0
+		if i >= 1 {
+			n := 0
+			for i+n < len(pix) && n < vp8lMaxRun && pix[i+n] == pix[i+n-1] {
+				n++
+			}
+			run, dist = n, 2
+		}
+		if i >= width {
+			n := 0
+			for i+n < len(pix) && n < vp8lMaxRun && pix[i+n] == pix[i+n-width] {
+				n++
+			}
+			if n > run {
+				run, dist = n, 1
+			}
+		}
+		if run >= vp8lMinRun {
+			tokens = append(tokens, vp8lToken{length: run, dist: dist})
+			i += run
+			continue
+		}
+		tokens = append(tokens, vp8lToken{argb: pix[i]})
+		i++
+	}
+	return tokens
+}
+
+// vp8lPrefix - префиксный код значения v >= 1: символ и дополнительные биты
+func vp8lPrefix(v int) (sym int, nbits uint, extra uint32) {
+	if v <= 4 {
+		return v - 1, 0, 0
+	}
+	v--
+	h := bits.Len(uint(v)) - 1
+	second := v >> (h - 1) & 1
+	nbits = uint(h - 1)
+	return 2*h + second, nbits, uint32(v) & (1<<nbits - 1)
+}
+
+// vp8lWriteImage - энтропийно-кодированное изображение: коды и данные
+func vp8lWriteImage(bw *vp8lBitWriter, pix []uint32, width, height int, topLevel bool) {
+	tokens := vp8lTokenize(pix, width)
+
+	green := make([]int, vp8lGreenSymbols)
+	red := make([]int, 256)
+	blue := make([]int, 256)
+	alpha := make([]int, 256)
+	dist := make([]int, vp8lDistSymbols)
+	for _, t := range tokens {
+		if t.length > 0 {
+			sym, _, _ := vp8lPrefix(t.length)
+			green[256+sym]++
+			sym, _, _ = vp8lPrefix(t.dist)
+			dist[sym]++
+			continue
+		}
+		green[t.argb>>8&0xff]++
+		red[t.argb>>16&0xff]++
+		blue[t.argb&0xff]++
+		alpha[t.argb>>24]++
+	}
+
+	bw.write(0, 1) // без цветового кэша
+	if topLevel {
+		bw.write(0, 1) // одна группа кодов на все изображение
+	}
+	codes := make([]*vp8lCode, 5)
+	for i, freq := range [][]int{green, red, blue, alpha, dist} {
+		codes[i] = newVP8LCode(freq, vp8lMaxCodeLength)
+		codes[i].writeHeader(bw)
+	}
+
+	for _, t := range tokens {
+		if t.length > 0 {
+			sym, n, extra := vp8lPrefix(t.length)
+			codes[0].writeSymbol(bw, 256+sym)
+			bw.write(extra, n)
+			sym, n, extra = vp8lPrefix(t.dist)
+			codes[4].writeSymbol(bw, sym)
+			bw.write(extra, n)
+			continue
+		}
+		codes[0].writeSymbol(bw, int(t.argb>>8&0xff))
+		codes[1].writeSymbol(bw, int(t.argb>>16&0xff))
+		codes[2].writeSymbol(bw, int(t.argb&0xff))
+		codes[3].writeSymbol(bw, int(t.argb>>24))
+	}
+}
+
+// vp8lCode - канонический код Хаффмана; коды хранятся в обратном
+// порядке битов, как их читает декодер
+type vp8lCode struct {
+	lengths []uint8
+	codes   []uint16
+	used    []int // символы с ненулевой длиной
+}
+
+func newVP8LCode(freq []int, maxLength int) *vp8lCode {
+	c := &vp8lCode{lengths: huffmanLengths(freq, maxLength)}
+	c.codes = canonicalCodes(c.lengths)
+	for s, l := range c.lengths {
+		if l > 0 {
+			c.used = append(c.used, s)
+		}
+	}
+	return c
+}
+
+// writeSymbol - код из одного символа занимает ноль бит
+func (c *vp8lCode) writeSymbol(bw *vp8lBitWriter, sym int) {
+	if len(c.used) > 1 {
+		bw.write(uint32(c.codes[sym]), uint(c.lengths[sym]))
+	}
+}
+
+// writeHeader - описание кода: простое (1-2 символа < 256) или через длины
+func (c *vp8lCode) writeHeader(bw *vp8lBitWriter) {
+	used := c.used
+	if len(used) == 0 {
+		used = []int{0}
+	}
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+		}
+		return
+	}
+	bw.write(0, 1)
+
+	// Длины сжимаются повторами: 16 - предыдущая длина, 17 и 18 - нули
+	type clToken struct {
+		sym   int
+		extra uint32
+	}
+	var tokens []clToken
+	lengths := c.lengths
+	for i := 0; i < len(lengths); {
+		l := int(lengths[i])
+		run := 1
+		for i+run < len(lengths) && int(lengths[i+run]) == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run > 0 {
+				switch {
+				case run >= 11:
+					n := min(run, 138)
+					tokens = append(tokens, clToken{18, uint32(n - 11)})
+					run -= n
+				case run >= 3:
+					tokens = append(tokens, clToken{17, uint32(run - 3)})
+					run = 0
+				default:
+					tokens = append(tokens, clToken{0, 0})
+					run--
+				}
+			}
+			continue
+		}
+		tokens = append(tokens, clToken{l, 0})
+		run--
+		for run > 0 {
+			if run >= 3 {
+				n := min(run, 6)
+				tokens = append(tokens, clToken{16, uint32(n - 3)})
+				run -= n
+			} else {
+				tokens = append(tokens, clToken{l, 0})
+				run--
+			}
+		}
+	}
+
+	freq := make([]int, 19)
+	for _, t := range tokens {
+		freq[t.sym]++
+	}
+	cl := newVP8LCode(freq, 7)
+
+	n := len(vp8lCodeLengthOrder)
+	for n > 4 && cl.lengths[vp8lCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		bw.write(uint32(cl.lengths[s]), 3)
+	}
+	bw.write(0, 1) // длины передаются для всего алфавита
+
+	for _, t := range tokens {
+		cl.writeSymbol(bw, t.sym)
+		switch t.sym {
+		case 16:
+			bw.write(t.extra, 2)
+		case 17:
+			bw.write(t.extra, 3)
+		case 18:
+			bw.write(t.extra, 7)
+		}
+	}
+}
+
+// huffmanLengths - длины кодов Хаффмана не длиннее maxLength.
+// Если дерево получается глубже, частоты сглаживаются и дерево строится заново.
+func huffmanLengths(freq []int, maxLength int) []uint8 {
+	lengths := make([]uint8, len(freq))
+	var syms []int
+	for s, f := range freq {
+		if f > 0 {
+			syms = append(syms, s)
+		}
+	}
+	switch len(syms) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[syms[0]] = 1
+		return lengths
+	}
+
+	f := append([]int(nil), freq...)
+	for !huffmanDepths(f, syms, lengths, maxLength) {
+		for _, s := range syms {
+			f[s] = (f[s] + 1) / 2
+		}
+	}
+	return lengths
+}
+
+// huffmanDepths - построение дерева двумя очередями; false, если глубина больше maxLength
+func huffmanDepths(freq []int, syms []int, lengths []uint8, maxLength int) bool {
+	n := len(syms)
+	order := append([]int(nil), syms...)
+	sort.SliceStable(order, func(i, j int) bool { return freq[order[i]] < freq[order[j]] })
+
+	weight := make([]int, 2*n-1)
+	parent := make([]int, 2*n-1)
+	for i, s := range order {
+		weight[i] = freq[s]
+	}
+
+	leaf, inner, next := 0, n, n
+	pick := func() int {
+		if leaf < n && (inner >= next || weight[leaf] <= weight[inner]) {
+			leaf++
+			return leaf - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for ; next < 2*n-1; next++ {
+		a, b := pick(), pick()
+		weight[next] = weight[a] + weight[b]
+		parent[a], parent[b] = next, next
+	}
+
+	depth := make([]int, 2*n-1)
+	for i := 2*n - 3; i >= 0; i-- {
+		depth[i] = depth[parent[i]] + 1
+	}
+	for i := 0; i < n; i++ {
+		if depth[i] > maxLength {
+			return false
+		}
+	}
+	for i, s := range order {
+		lengths[s] = uint8(depth[i])
+	}
+	return true
+}
+
+// canonicalCodes - канонические коды по длинам (как в DEFLATE), биты развернуты
+func canonicalCodes(lengths []uint8) []uint16 {
+	var count, next [vp8lMaxCodeLength + 1]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	code := 0
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[s] = uint16(bits.Reverse16(uint16(next[l])) >> (16 - l))
+		next[l]++
+	}
+	return codes
+}